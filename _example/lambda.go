@@ -29,12 +29,12 @@ func HandleRequest(ctx context.Context, name MyEvent) (events.APIGatewayProxyRes
 	cfg.Region = "us-east-1"
 	// testing S3
 	svc := s3.NewFromConfig(cfg)
-	_, err := svc.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+	_, err := svc.ListBuckets(ctx, &s3.ListBucketsInput{})
 	if err != nil {
 		return events.APIGatewayProxyResponse{Body: "", StatusCode: 500}, err
 	}
 
-	svc.CreateBucket(context.Background(), &s3.CreateBucketInput{
+	svc.CreateBucket(ctx, &s3.CreateBucketInput{
 		Bucket: aws.String("test-bucket-go-tracer-2"),
 	})
 
@@ -43,7 +43,7 @@ func HandleRequest(ctx context.Context, name MyEvent) (events.APIGatewayProxyRes
 	input := &ssm.GetParameterInput{
 		Name: aws.String("parameter-name"),
 	}
-	_, err = ssmClient.GetParameter(context.Background(), input)
+	_, err = ssmClient.GetParameter(ctx, input)
 	if err != nil {
 		return events.APIGatewayProxyResponse{Body: "ssm error", StatusCode: 500}, err
 	}
@@ -1,9 +1,26 @@
 package lumigotracer
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
 	"github.com/spf13/viper"
 )
 
+const (
+	// exporterFile writes the spans to files picked up by the Lumigo extension
+	exporterFile = "file"
+	// exporterHTTP posts the spans straight to the Lumigo edge
+	exporterHTTP = "http"
+	// exporterOTLP sends the spans to an OpenTelemetry collector over OTLP/HTTP
+	exporterOTLP = "otlp"
+)
+
 // Config describes the struct about the configuration
 // of the wrap handler for tracer
 type Config struct {
@@ -24,10 +41,44 @@ type Config struct {
 
 	// MaxSizeForRequest is the maximum amount of byte to be sent to the edge
 	MaxSizeForRequest int
-}
 
-// cfg it's a public empty config
-var cfg Config
+	// IsEnabled is consulted on every invocation, when set, to decide if
+	// the invocation is traced. It allows switching the tracer off without
+	// a redeploy, e.g. based on a remote config file.
+	IsEnabled func(ctx context.Context) bool
+
+	// exporters select where the spans are sent, the spans
+	// are fanned out when more than one is selected
+	exporters []string
+
+	// edgeURL is the Lumigo edge endpoint of the http exporter
+	edgeURL string
+
+	// edgeGzip compresses the spans sent to the edge
+	edgeGzip bool
+
+	// edgeTimeout is the timeout of a single request to the edge
+	edgeTimeout time.Duration
+
+	// edgeRetries is the number of retries of a failed request to the edge
+	edgeRetries int
+
+	// otlpEndpoint is the collector URL of the otlp exporter, the
+	// OTEL_EXPORTER_OTLP_ variables are used when it is empty
+	otlpEndpoint string
+
+	// otlpProtocol is the payload format of the otlp exporter,
+	// http/protobuf or http/json
+	otlpProtocol string
+
+	// secretMaskingRegexes match the keys whose values are masked,
+	// the default regexes are used when it is empty
+	secretMaskingRegexes []string
+
+	// timeoutTimerBuffer is how long before the lambda deadline the
+	// invocation is reported as timed out, zero switches it off
+	timeoutTimerBuffer time.Duration
+}
 
 // validate runs a validation to the required fields
 // for this Config struct
@@ -35,34 +86,125 @@ func (cfg Config) validate() error { // nolint
 	if cfg.Token == "" {
 		return ErrInvalidToken
 	}
+	if len(cfg.exporters) == 0 {
+		return ErrInvalidExporter
+	}
+	for _, exporter := range cfg.exporters {
+		if exporter != exporterFile && exporter != exporterHTTP && exporter != exporterOTLP {
+			return ErrInvalidExporter
+		}
+	}
+	if cfg.hasExporter(exporterOTLP) && cfg.otlpProtocol != otlpProtocolProtobuf && cfg.otlpProtocol != otlpProtocolJSON {
+		return ErrInvalidOTLPProtocol
+	}
+	if _, err := cfg.newMasker(); err != nil {
+		return ErrInvalidSecretMaskingRegex
+	}
 	return nil
 }
 
-// init not really used right now
-func init() {
-	viper.AutomaticEnv()
-	viper.SetEnvPrefix("LUMIGO")
-	viper.SetDefault("ENABLED", true)
-	viper.SetDefault("DEBUG", false)
+// newMasker returns the masker of the secrets
+// matching the secret masking regexes
+func (cfg Config) newMasker() (*masking.Masker, error) {
+	return masking.New(cfg.secretMaskingRegexes)
+}
+
+// hasExporter returns true if the given exporter is selected
+func (cfg Config) hasExporter(name string) bool {
+	for _, exporter := range cfg.exporters {
+		if exporter == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isEnabled returns true if the invocation of the given
+// context should be traced
+func (cfg Config) isEnabled(ctx context.Context) bool {
+	if !cfg.enabled {
+		return false
+	}
+	if cfg.IsEnabled != nil {
+		return cfg.IsEnabled(ctx)
+	}
+	return true
+}
+
+func loadConfig(conf Config) (loaded Config, err error) {
+	defer recoverWithLogs(newLogger(true))
+
+	loaded = newConfig(conf)
+	return loaded, loaded.validate()
 }
 
-func loadConfig(conf Config) error {
-	defer recoverWithLogs()
+// newConfig fills the given config with the values
+// of the environment variables and the defaults
+func newConfig(conf Config) Config {
+	v := viper.New()
+	v.AutomaticEnv()
+	v.SetEnvPrefix("LUMIGO")
+	v.SetDefault("ENABLED", true)
+	v.SetDefault("DEBUG", false)
+	v.SetDefault("EXPORTER", exporterFile)
+	v.SetDefault("EDGE_GZIP", true)
+	v.SetDefault("EDGE_TIMEOUT", time.Second)
+	v.SetDefault("EDGE_RETRIES", 2)
+	v.SetDefault("TIMEOUT_TIMER_BUFFER", 500*time.Millisecond)
 
-	cfg.Token = viper.GetString("TRACER_TOKEN")
-	if cfg.Token == "" {
-		cfg.Token = conf.Token
+	token := v.GetString("TRACER_TOKEN")
+	if token == "" {
+		token = conf.Token
 	}
-	cfg.enabled = viper.GetBool("ENABLED")
-	cfg.debug = viper.GetBool("DEBUG")
-	cfg.MaxSizeForRequest = viper.GetInt("MAX_SIZE_FOR_REQUEST")
-	if cfg.MaxSizeForRequest == 0 {
-		cfg.MaxSizeForRequest = 1024 * 500
+	conf.Token = token
+	conf.enabled = v.GetBool("ENABLED")
+	conf.debug = v.GetBool("DEBUG")
+	conf.MaxSizeForRequest = v.GetInt("MAX_SIZE_FOR_REQUEST")
+	if conf.MaxSizeForRequest == 0 {
+		conf.MaxSizeForRequest = 1024 * 500
+	}
+	conf.MaxEntrySize = v.GetInt("DEFAULT_MAX_ENTRY_SIZE")
+	if conf.MaxEntrySize == 0 {
+		conf.MaxEntrySize = 2048
+	}
+	conf.exporters = parseExporters(v.GetString("EXPORTER"))
+	conf.edgeURL = v.GetString("EDGE_URL")
+	if conf.edgeURL == "" {
+		conf.edgeURL = fmt.Sprintf("https://%s.lumigo-tracer-edge.golumigo.com/api/spans", os.Getenv("AWS_REGION"))
+	}
+	conf.edgeGzip = v.GetBool("EDGE_GZIP")
+	conf.edgeTimeout = v.GetDuration("EDGE_TIMEOUT")
+	conf.edgeRetries = v.GetInt("EDGE_RETRIES")
+	conf.otlpEndpoint = v.GetString("OTLP_ENDPOINT")
+	conf.otlpProtocol = v.GetString("OTLP_PROTOCOL")
+	if conf.otlpProtocol == "" {
+		conf.otlpProtocol = otlpProtocolProtobuf
+	}
+	conf.secretMaskingRegexes = parseRegexes(v.GetString("SECRET_MASKING_REGEX"))
+	conf.timeoutTimerBuffer = v.GetDuration("TIMEOUT_TIMER_BUFFER")
+	return conf
+}
+
+// parseExporters splits a comma separated list of exporters
+func parseExporters(value string) []string {
+	var exporters []string
+	for _, exporter := range strings.Split(value, ",") {
+		if exporter = strings.ToLower(strings.TrimSpace(exporter)); exporter != "" {
+			exporters = append(exporters, exporter)
+		}
+	}
+	return exporters
+}
+
+// parseRegexes parses a JSON list of regexes,
+// a value which isn't a list is a single regex
+func parseRegexes(value string) []string {
+	if value == "" {
+		return nil
 	}
-	cfg.MaxEntrySize = viper.GetInt("DEFAULT_MAX_ENTRY_SIZE")
-	if cfg.MaxEntrySize == 0 {
-		cfg.MaxEntrySize = 2048
+	var regexes []string
+	if err := json.Unmarshal([]byte(value), &regexes); err != nil {
+		return []string{value}
 	}
-	cfg.PrintStdout = conf.PrintStdout
-	return cfg.validate()
+	return regexes
 }
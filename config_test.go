@@ -3,6 +3,7 @@ package lumigotracer
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -22,10 +23,20 @@ func (conf *configTestSuite) TearDownTest() {
 	os.Unsetenv("LUMIGO_ENABLED")
 	os.Unsetenv("LUMIGO_MAX_SIZE_FOR_REQUEST")
 	os.Unsetenv("LUMIGO_DEFAULT_MAX_ENTRY_SIZE")
+	os.Unsetenv("LUMIGO_EXPORTER")
+	os.Unsetenv("LUMIGO_EDGE_URL")
+	os.Unsetenv("LUMIGO_EDGE_GZIP")
+	os.Unsetenv("LUMIGO_EDGE_TIMEOUT")
+	os.Unsetenv("LUMIGO_EDGE_RETRIES")
+	os.Unsetenv("LUMIGO_OTLP_ENDPOINT")
+	os.Unsetenv("LUMIGO_SECRET_MASKING_REGEX")
+	os.Unsetenv("LUMIGO_TIMEOUT_TIMER_BUFFER")
+	os.Unsetenv("AWS_REGION")
 }
 
 func (conf *configTestSuite) TestConfigValidationMissingToken() {
-	assert.Error(conf.T(), ErrInvalidToken, loadConfig(Config{}))
+	_, err := loadConfig(Config{})
+	assert.Error(conf.T(), ErrInvalidToken, err)
 }
 
 func (conf *configTestSuite) TestConfigEnvVariables() {
@@ -36,7 +47,7 @@ func (conf *configTestSuite) TestConfigEnvVariables() {
 	os.Setenv("LUMIGO_MAX_SIZE_FOR_REQUEST", "42")
 	os.Setenv("LUMIGO_DEFAULT_MAX_ENTRY_SIZE", "42")
 
-	err := loadConfig(Config{})
+	cfg, err := loadConfig(Config{})
 	assert.NoError(conf.T(), err)
 	assert.Equal(conf.T(), "token", cfg.Token)
 	assert.Equal(conf.T(), true, cfg.debug)
@@ -48,7 +59,7 @@ func (conf *configTestSuite) TestConfigEnvVariables() {
 func (conf *configTestSuite) TestConfigEnabledByDefault() {
 	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
 
-	err := loadConfig(Config{})
+	cfg, err := loadConfig(Config{})
 	assert.NoError(conf.T(), err)
 	assert.Equal(conf.T(), "token", cfg.Token)
 	assert.Equal(conf.T(), false, cfg.debug)
@@ -56,3 +67,97 @@ func (conf *configTestSuite) TestConfigEnabledByDefault() {
 	assert.Equal(conf.T(), 2048, cfg.MaxEntrySize)
 	assert.Equal(conf.T(), 512000, cfg.MaxSizeForRequest)
 }
+
+func (conf *configTestSuite) TestConfigEdgeEnvVariables() {
+	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+	os.Setenv("LUMIGO_EXPORTER", "http")
+	os.Setenv("LUMIGO_EDGE_URL", "https://edge.example.com/api/spans")
+	os.Setenv("LUMIGO_EDGE_GZIP", "false")
+	os.Setenv("LUMIGO_EDGE_TIMEOUT", "250ms")
+	os.Setenv("LUMIGO_EDGE_RETRIES", "5")
+
+	cfg, err := loadConfig(Config{})
+	assert.NoError(conf.T(), err)
+	assert.Equal(conf.T(), []string{exporterHTTP}, cfg.exporters)
+	assert.Equal(conf.T(), "https://edge.example.com/api/spans", cfg.edgeURL)
+	assert.Equal(conf.T(), false, cfg.edgeGzip)
+	assert.Equal(conf.T(), 250*time.Millisecond, cfg.edgeTimeout)
+	assert.Equal(conf.T(), 5, cfg.edgeRetries)
+}
+
+func (conf *configTestSuite) TestConfigEdgeDefaults() {
+	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+	os.Setenv("AWS_REGION", "eu-west-1")
+
+	cfg, err := loadConfig(Config{})
+	assert.NoError(conf.T(), err)
+	assert.Equal(conf.T(), []string{exporterFile}, cfg.exporters)
+	assert.Equal(conf.T(), "https://eu-west-1.lumigo-tracer-edge.golumigo.com/api/spans", cfg.edgeURL)
+	assert.Equal(conf.T(), true, cfg.edgeGzip)
+	assert.Equal(conf.T(), time.Second, cfg.edgeTimeout)
+	assert.Equal(conf.T(), 2, cfg.edgeRetries)
+}
+
+func (conf *configTestSuite) TestConfigTimeoutTimerBuffer() {
+	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+
+	cfg, err := loadConfig(Config{})
+	assert.NoError(conf.T(), err)
+	assert.Equal(conf.T(), 500*time.Millisecond, cfg.timeoutTimerBuffer)
+
+	os.Setenv("LUMIGO_TIMEOUT_TIMER_BUFFER", "2s")
+	cfg, err = loadConfig(Config{})
+	assert.NoError(conf.T(), err)
+	assert.Equal(conf.T(), 2*time.Second, cfg.timeoutTimerBuffer)
+}
+
+func (conf *configTestSuite) TestConfigInvalidExporter() {
+	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+	os.Setenv("LUMIGO_EXPORTER", "ftp")
+
+	_, err := loadConfig(Config{})
+	assert.Equal(conf.T(), ErrInvalidExporter, err)
+}
+
+func (conf *configTestSuite) TestConfigExportersList() {
+	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+	os.Setenv("LUMIGO_EXPORTER", " file, OTLP ,")
+	os.Setenv("LUMIGO_OTLP_ENDPOINT", "http://localhost:4318")
+
+	cfg, err := loadConfig(Config{})
+	assert.NoError(conf.T(), err)
+	assert.Equal(conf.T(), []string{exporterFile, exporterOTLP}, cfg.exporters)
+	assert.Equal(conf.T(), "http://localhost:4318", cfg.otlpEndpoint)
+	assert.True(conf.T(), cfg.hasExporter(exporterOTLP))
+	assert.False(conf.T(), cfg.hasExporter(exporterHTTP))
+}
+
+func (conf *configTestSuite) TestConfigNoExporter() {
+	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+	os.Setenv("LUMIGO_EXPORTER", ",")
+
+	_, err := loadConfig(Config{})
+	assert.Equal(conf.T(), ErrInvalidExporter, err)
+}
+
+func (conf *configTestSuite) TestConfigSecretMaskingRegex() {
+	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+	os.Setenv("LUMIGO_SECRET_MASKING_REGEX", `["user.*", ".*pin"]`)
+
+	cfg, err := loadConfig(Config{})
+	assert.NoError(conf.T(), err)
+	assert.Equal(conf.T(), []string{"user.*", ".*pin"}, cfg.secretMaskingRegexes)
+
+	os.Setenv("LUMIGO_SECRET_MASKING_REGEX", ".*pin")
+	cfg, err = loadConfig(Config{})
+	assert.NoError(conf.T(), err)
+	assert.Equal(conf.T(), []string{".*pin"}, cfg.secretMaskingRegexes)
+}
+
+func (conf *configTestSuite) TestConfigInvalidSecretMaskingRegex() {
+	os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+	os.Setenv("LUMIGO_SECRET_MASKING_REGEX", `["("]`)
+
+	_, err := loadConfig(Config{})
+	assert.Equal(conf.T(), ErrInvalidSecretMaskingRegex, err)
+}
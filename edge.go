@@ -0,0 +1,192 @@
+package lumigotracer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// edgeDeadlineMargin is the time left to the handler after the
+	// last request to the edge, before the lambda deadline
+	edgeDeadlineMargin = 100 * time.Millisecond
+
+	// edgeBackoff is the delay before the first retry of a request to
+	// the edge, it doubles with each retry up to edgeMaxBackoff. The
+	// retries asked later than edgeMaxBackoff by the edge are dropped.
+	edgeBackoff    = 50 * time.Millisecond
+	edgeMaxBackoff = time.Second
+)
+
+// edgeWriter posts the spans straight to the Lumigo edge
+type edgeWriter struct {
+	url     string
+	gzip    bool
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	logger  logrus.FieldLogger
+}
+
+// newEdgeWriter creates an edgeWriter with the settings of the Tracer
+func newEdgeWriter(t *Tracer) *edgeWriter {
+	return &edgeWriter{
+		url:     t.cfg.edgeURL,
+		gzip:    t.cfg.edgeGzip,
+		timeout: t.cfg.edgeTimeout,
+		retries: t.cfg.edgeRetries,
+		backoff: edgeBackoff,
+		client:  &http.Client{},
+		logger:  t.logger,
+	}
+}
+
+func (w *edgeWriter) writeSpans(ctx context.Context, spans []telemetry.Span, isStart bool) error {
+	body, err := w.encode(spans)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode spans")
+	}
+
+	var postErr error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		var retryAfter time.Duration
+		if retry, retryAfter, postErr = w.post(ctx, body); postErr == nil {
+			return nil
+		}
+		if !retry || attempt == w.retries {
+			break
+		}
+		w.logger.WithError(postErr).Warnf("failed to send spans to edge, attempt %d", attempt+1)
+		if !w.wait(ctx, w.retryDelay(attempt, retryAfter)) {
+			break
+		}
+	}
+	return errors.Wrapf(postErr, "failed to send spans to edge: %s", w.url)
+}
+
+// retryDelay returns the delay before the retry following the given
+// attempt, the exponential backoff or the delay asked by the edge
+func (w *edgeWriter) retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := w.backoff << uint(attempt)
+	if delay > edgeMaxBackoff || delay <= 0 {
+		delay = edgeMaxBackoff
+	}
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// wait waits for delay before a retry, it returns false if the
+// retry is dropped: the delay is longer than edgeMaxBackoff or
+// ends too close to the lambda deadline, or ctx is done
+func (w *edgeWriter) wait(ctx context.Context, delay time.Duration) bool {
+	if delay > edgeMaxBackoff {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline)-edgeDeadlineMargin <= delay {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *edgeWriter) encode(spans []telemetry.Span) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.Writer = &buf
+	var gz *gzip.Writer
+	if w.gzip {
+		gz = gzip.NewWriter(&buf)
+		writer = gz
+	}
+	if err := json.NewEncoder(writer).Encode(spans); err != nil {
+		return nil, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends the body to the edge once, it returns true if a failed
+// request is worth retrying, with the delay asked by the edge if any
+func (w *edgeWriter) post(ctx context.Context, body []byte) (bool, time.Duration, error) {
+	timeout := w.requestTimeout(ctx)
+	if timeout <= 0 {
+		return false, 0, errors.New("no time left before the lambda deadline")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return true, parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("edge responded with status code %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return false, 0, fmt.Errorf("edge responded with status code %d", resp.StatusCode)
+	}
+	return false, 0, nil
+}
+
+// parseRetryAfter returns the delay of a Retry-After header,
+// in seconds or at an HTTP date, or 0 if it is missing or invalid
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// requestTimeout returns the timeout of a single request,
+// it never exceeds the time left before the lambda deadline
+func (w *edgeWriter) requestTimeout(ctx context.Context) time.Duration {
+	timeout := w.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline) - edgeDeadlineMargin; left < timeout {
+			timeout = left
+		}
+	}
+	return timeout
+}
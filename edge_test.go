@@ -0,0 +1,267 @@
+package lumigotracer
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type edgeTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupEdgeSuite(t *testing.T) {
+	suite.Run(t, &edgeTestSuite{})
+}
+
+func (e *edgeTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(e.T())
+}
+
+func (e *edgeTestSuite) TearDownTest() {
+	_ = os.Unsetenv("LUMIGO_TRACER_TOKEN")
+	_ = os.Unsetenv("LUMIGO_EXPORTER")
+	_ = os.Unsetenv("LUMIGO_EDGE_URL")
+}
+
+// edgeStub is an httptest stand-in for the Lumigo edge
+type edgeStub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []edgeRequest
+	statuses []int
+	delay    time.Duration
+	// retryAfter is the Retry-After header of the failed responses
+	retryAfter string
+}
+
+type edgeRequest struct {
+	header http.Header
+	spans  []telemetry.Span
+}
+
+// newEdgeStub starts an edge which responds with the given status codes
+// in order and with 200 once they are exhausted
+func newEdgeStub(t *testing.T, statuses ...int) *edgeStub {
+	stub := &edgeStub{statuses: statuses}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		delay := stub.delay
+		status := http.StatusOK
+		if len(stub.statuses) > 0 {
+			status, stub.statuses = stub.statuses[0], stub.statuses[1:]
+		}
+		stub.mu.Unlock()
+		time.Sleep(delay)
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			body = gz
+		}
+		var spans []telemetry.Span
+		assert.NoError(t, json.NewDecoder(body).Decode(&spans))
+
+		stub.mu.Lock()
+		stub.requests = append(stub.requests, edgeRequest{header: r.Header, spans: spans})
+		if status != http.StatusOK && stub.retryAfter != "" {
+			w.Header().Set("Retry-After", stub.retryAfter)
+		}
+		stub.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *edgeStub) received() []edgeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (e *edgeTestSuite) newWriter(url string, opts ...Option) *edgeWriter {
+	opts = append([]Option{WithToken("token"), WithHTTPExporter(url), WithLogger(newLogger(false))}, opts...)
+	lt, err := New(opts...)
+	assert.NoError(e.T(), err)
+	return newEdgeWriter(lt)
+}
+
+func (e *edgeTestSuite) TestWriteSpansGzip() {
+	edge := newEdgeStub(e.T())
+	writer := e.newWriter(edge.URL)
+
+	spans := []telemetry.Span{{ID: "1", Token: "token"}, {ID: "2", Token: "token"}}
+	assert.NoError(e.T(), writer.writeSpans(context.Background(), spans, false))
+
+	requests := edge.received()
+	assert.Equal(e.T(), 1, len(requests))
+	assert.Equal(e.T(), "application/json", requests[0].header.Get("Content-Type"))
+	assert.Equal(e.T(), "gzip", requests[0].header.Get("Content-Encoding"))
+	assert.Equal(e.T(), spans, requests[0].spans)
+}
+
+func (e *edgeTestSuite) TestWriteSpansNoGzip() {
+	edge := newEdgeStub(e.T())
+	writer := e.newWriter(edge.URL, WithEdgeGzip(false))
+
+	spans := []telemetry.Span{{ID: "1", Token: "token"}}
+	assert.NoError(e.T(), writer.writeSpans(context.Background(), spans, true))
+
+	requests := edge.received()
+	assert.Equal(e.T(), 1, len(requests))
+	assert.Empty(e.T(), requests[0].header.Get("Content-Encoding"))
+	assert.Equal(e.T(), spans, requests[0].spans)
+}
+
+func (e *edgeTestSuite) TestWriteSpansRetries() {
+	edge := newEdgeStub(e.T(), http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	writer := e.newWriter(edge.URL, WithEdgeRetries(2))
+
+	assert.NoError(e.T(), writer.writeSpans(context.Background(), []telemetry.Span{{ID: "1"}}, false))
+	assert.Equal(e.T(), 3, len(edge.received()))
+}
+
+func (e *edgeTestSuite) TestWriteSpansRetriesExhausted() {
+	edge := newEdgeStub(e.T(), http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	writer := e.newWriter(edge.URL, WithEdgeRetries(1))
+
+	err := writer.writeSpans(context.Background(), []telemetry.Span{{ID: "1"}}, false)
+	assert.Error(e.T(), err)
+	assert.Contains(e.T(), err.Error(), "status code 502")
+	assert.Equal(e.T(), 2, len(edge.received()))
+}
+
+func (e *edgeTestSuite) TestWriteSpansBackoff() {
+	edge := newEdgeStub(e.T(), http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	writer := e.newWriter(edge.URL, WithEdgeRetries(2))
+
+	started := time.Now()
+	assert.NoError(e.T(), writer.writeSpans(context.Background(), []telemetry.Span{{ID: "1"}}, false))
+	assert.Equal(e.T(), 3, len(edge.received()))
+	assert.GreaterOrEqual(e.T(), int64(time.Since(started)), int64(edgeBackoff+2*edgeBackoff))
+}
+
+func (e *edgeTestSuite) TestWriteSpansHonorsRetryAfter() {
+	edge := newEdgeStub(e.T(), http.StatusTooManyRequests)
+	edge.retryAfter = "1"
+	writer := e.newWriter(edge.URL, WithEdgeRetries(2))
+
+	started := time.Now()
+	assert.NoError(e.T(), writer.writeSpans(context.Background(), []telemetry.Span{{ID: "1"}}, false))
+	assert.Equal(e.T(), 2, len(edge.received()))
+	assert.GreaterOrEqual(e.T(), int64(time.Since(started)), int64(time.Second))
+}
+
+func (e *edgeTestSuite) TestWriteSpansRetryAfterTooLate() {
+	edge := newEdgeStub(e.T(), http.StatusTooManyRequests)
+	edge.retryAfter = "30"
+	writer := e.newWriter(edge.URL, WithEdgeRetries(2))
+
+	err := writer.writeSpans(context.Background(), []telemetry.Span{{ID: "1"}}, false)
+	assert.Error(e.T(), err)
+	assert.Contains(e.T(), err.Error(), "status code 429")
+	assert.Equal(e.T(), 1, len(edge.received()))
+}
+
+func (e *edgeTestSuite) TestWriteSpansBackoffTiedToDeadline() {
+	edge := newEdgeStub(e.T(), http.StatusServiceUnavailable)
+	writer := e.newWriter(edge.URL, WithEdgeRetries(2))
+	writer.backoff = 500 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), edgeDeadlineMargin+300*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	assert.Error(e.T(), writer.writeSpans(ctx, []telemetry.Span{{ID: "1"}}, false))
+	assert.Equal(e.T(), 1, len(edge.received()))
+	assert.Less(e.T(), int64(time.Since(started)), int64(writer.backoff))
+}
+
+func (e *edgeTestSuite) TestParseRetryAfter() {
+	assert.Equal(e.T(), 2*time.Second, parseRetryAfter("2"))
+	assert.Zero(e.T(), parseRetryAfter(""))
+	assert.Zero(e.T(), parseRetryAfter("soon"))
+	assert.Zero(e.T(), parseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)))
+	delay := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.Greater(e.T(), int64(delay), int64(58*time.Second))
+}
+
+func (e *edgeTestSuite) TestWriteSpansNoRetryOnClientError() {
+	edge := newEdgeStub(e.T(), http.StatusBadRequest)
+	writer := e.newWriter(edge.URL, WithEdgeRetries(2))
+
+	assert.Error(e.T(), writer.writeSpans(context.Background(), []telemetry.Span{{ID: "1"}}, false))
+	assert.Equal(e.T(), 1, len(edge.received()))
+}
+
+func (e *edgeTestSuite) TestWriteSpansTimeoutTiedToDeadline() {
+	edge := newEdgeStub(e.T())
+	edge.delay = time.Second
+	writer := e.newWriter(edge.URL, WithEdgeTimeout(5*time.Second), WithEdgeRetries(0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), edgeDeadlineMargin+200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	assert.Error(e.T(), writer.writeSpans(ctx, []telemetry.Span{{ID: "1"}}, false))
+	assert.Less(e.T(), int64(time.Since(started)), int64(time.Second))
+}
+
+func (e *edgeTestSuite) TestWriteSpansNoTimeLeft() {
+	edge := newEdgeStub(e.T())
+	writer := e.newWriter(edge.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), edgeDeadlineMargin/2)
+	defer cancel()
+	assert.Error(e.T(), writer.writeSpans(ctx, []telemetry.Span{{ID: "1"}}, false))
+	assert.Equal(e.T(), 0, len(edge.received()))
+}
+
+func (e *edgeTestSuite) TestWrapHandlerHTTPExporter() {
+	edge := newEdgeStub(e.T())
+	_ = os.Setenv("LUMIGO_EXPORTER", "http")
+	_ = os.Setenv("LUMIGO_EDGE_URL", edge.URL)
+	dir := e.T().TempDir()
+
+	lt, err := New(WithToken("token"), WithSpansDir(dir))
+	assert.NoError(e.T(), err)
+	handler := reflect.ValueOf(lt.WrapHandler(func(name string) (string, error) {
+		return "Hello " + name, nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+
+	requests := edge.received()
+	assert.Equal(e.T(), 2, len(requests))
+	assert.Equal(e.T(), mockLambdaContext.AwsRequestID+"_started", requests[0].spans[0].ID)
+	assert.Equal(e.T(), mockLambdaContext.AwsRequestID, requests[1].spans[0].ID)
+	assert.Equal(e.T(), "token", requests[1].spans[0].Token)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(e.T(), err)
+	assert.Equal(e.T(), 0, len(files))
+}
+
+func (e *edgeTestSuite) TestInvalidExporter() {
+	_ = os.Setenv("LUMIGO_EXPORTER", "carrier-pigeon")
+	_, err := New(WithToken("token"))
+	assert.Equal(e.T(), ErrInvalidExporter, err)
+}
@@ -2,9 +2,8 @@ package lumigotracer
 
 import (
 	"runtime"
-	"strconv"
-	"strings"
 
+	"github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -12,10 +11,30 @@ import (
 // ErrInvalidToken an error about a missing token
 var ErrInvalidToken = errors.New("invalid Token. Go to Lumigo Settings to get a valid token")
 
+// ErrInvalidExporter an error about an unknown or empty LUMIGO_EXPORTER
+var ErrInvalidExporter = errors.New("invalid exporter. Supported exporters are file, http and otlp")
+
+// ErrInvalidOTLPProtocol an error about an unknown LUMIGO_OTLP_PROTOCOL
+var ErrInvalidOTLPProtocol = errors.New("invalid otlp protocol. Supported protocols are http/protobuf and http/json")
+
+// ErrInvalidSecretMaskingRegex an error about a LUMIGO_SECRET_MASKING_REGEX which doesn't compile
+var ErrInvalidSecretMaskingRegex = errors.New("invalid secret masking regex. Set a JSON list of valid regexes")
+
+// panicErrorType is the type of the error reported
+// when the handler panics
+const panicErrorType = "Runtime.Panic"
+
+// timeoutErrorType and timeoutErrorMessage describe the error
+// reported when the lambda is about to time out
+const (
+	timeoutErrorType    = "TimeoutError"
+	timeoutErrorMessage = "The lambda is about to time out"
+)
+
 // defaultStackLength specifies the default maximum size of a stack trace.
 const defaultStackLength = 64
 
-func recoverWithLogs() {
+func recoverWithLogs(logger logrus.FieldLogger) {
 	if err := recover(); err != nil {
 		logger.WithFields(logrus.Fields{
 			"stacktrace": takeStacktrace(),
@@ -25,29 +44,36 @@ func recoverWithLogs() {
 }
 
 func takeStacktrace() string {
-	var builder strings.Builder
 	pcs := make([]uintptr, defaultStackLength)
 
 	// +2 to exclude runtime.Callers and takeStacktrace
 	numFrames := runtime.Callers(2+int(0), pcs)
-	if numFrames == 0 {
-		return ""
-	}
-	frames := runtime.CallersFrames(pcs[:numFrames])
-	for i := 0; ; i++ {
-		frame, more := frames.Next()
-		if i != 0 {
-			builder.WriteByte('\n')
-		}
-		builder.WriteString(frame.Function)
-		builder.WriteByte('\n')
-		builder.WriteByte('\t')
-		builder.WriteString(frame.File)
-		builder.WriteByte(':')
-		builder.WriteString(strconv.Itoa(frame.Line))
-		if !more {
-			break
+	return instrumentation.FormatStacktrace(instrumentation.CallersFrames(pcs[:numFrames]))
+}
+
+// takeCallerStacktrace returns the stack trace from the caller of
+// the function calling it, e.g. the code which called ReportError
+func takeCallerStacktrace() string {
+	pcs := make([]uintptr, defaultStackLength)
+
+	// +3 to exclude runtime.Callers, takeCallerStacktrace and its caller
+	numFrames := runtime.Callers(3, pcs)
+	return instrumentation.FormatStacktrace(instrumentation.CallersFrames(pcs[:numFrames]))
+}
+
+// takePanicStacktrace returns the stack trace of the goroutine at the
+// panic, it must be called by the deferred function which recovered it
+func takePanicStacktrace() string {
+	pcs := make([]uintptr, defaultStackLength)
+
+	// +2 to exclude runtime.Callers and takePanicStacktrace
+	numFrames := runtime.Callers(2, pcs)
+	frames := instrumentation.CallersFrames(pcs[:numFrames])
+	// skip the frames of the recovery, up to the call to panic
+	for i, frame := range frames {
+		if frame.Function == "runtime.gopanic" {
+			return instrumentation.FormatStacktrace(frames[i+1:])
 		}
 	}
-	return builder.String()
+	return instrumentation.FormatStacktrace(frames)
 }
@@ -1,6 +1,7 @@
 package lumigotracer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,3 +13,29 @@ func TestTakeStackTrace(t *testing.T) {
 	assert.Contains(t, stacktrace, "testing.tRunner")
 	assert.Contains(t, stacktrace, "lumigo-go-tracer.TestTakeStackTrace")
 }
+
+func panicking() {
+	panic("failed")
+}
+
+func TestTakePanicStackTrace(t *testing.T) {
+	var stacktrace string
+	func() {
+		defer func() {
+			_ = recover()
+			stacktrace = takePanicStacktrace()
+		}()
+		panicking()
+	}()
+	assert.True(t, strings.HasPrefix(stacktrace, "github.com/lumigo-io/lumigo-go-tracer.panicking\n"))
+	assert.Contains(t, stacktrace, "lumigo-go-tracer.TestTakePanicStackTrace")
+	assert.NotContains(t, stacktrace, "runtime.gopanic")
+}
+
+type notFoundError struct {
+	key string
+}
+
+func (e *notFoundError) Error() string {
+	return e.key + " not found"
+}
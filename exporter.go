@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"reflect"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
 	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
 	"github.com/lumigo-io/lumigo-go-tracer/internal/transform"
 	"github.com/pkg/errors"
@@ -17,29 +20,82 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
+const (
+	// spansSizeLimitReason is the reason reported for the spans
+	// dropped to keep the request under MaxSizeForRequest
+	spansSizeLimitReason = "SPANS_SIZE_LIMIT"
+
+	// spansCountLimitReason is the reason reported for the spans
+	// dropped once maxBufferedSpans spans are buffered
+	spansCountLimitReason = "SPANS_COUNT_LIMIT"
+
+	// maxBufferedSpans is the maximum number of spans buffered
+	// until the end of an invocation
+	maxBufferedSpans = 1000
+
+	// trimmedEntrySize is the size the bodies and headers are
+	// truncated to when the spans don't fit in MaxSizeForRequest
+	trimmedEntrySize = 128
+)
+
+// spansWriter stores the Lumigo spans of an invocation
+type spansWriter interface {
+	writeSpans(ctx context.Context, spans []telemetry.Span, isStart bool) error
+}
+
+// fileWriter writes the spans to files picked up by the Lumigo extension
+type fileWriter struct {
+	dir string
+}
+
+func (w fileWriter) writeSpans(ctx context.Context, spans []telemetry.Span, isStart bool) error {
+	return writeSpan(w.dir, spans, isStart)
+}
+
 // Exporter exports OpenTelemetry data to Lumigo.
 type Exporter struct {
-	spansTotalSizeBytes int
-	lumigoStartSpan     telemetry.Span
-	lumigoSpans         []telemetry.Span
-	context             context.Context
-	logger              logrus.FieldLogger
-	encoderMu           sync.Mutex
+	writer            spansWriter
+	maxEntrySize      int
+	maxSizeForRequest int
+	lumigoStartSpan   telemetry.Span
+	lumigoSpans       []telemetry.Span
+	maxSpans          int
+	droppedSpans      int
+	masker            *masking.Masker
+	context           context.Context
+	logger            logrus.FieldLogger
+	encoderMu         sync.Mutex
 
 	stoppedMu sync.RWMutex
 	stopped   bool
 }
 
-// newExporter creates an Exporter with the passed options.
-func newExporter(ctx context.Context, logger logrus.FieldLogger) (*Exporter, error) {
+// newExporter creates an Exporter with the settings of the Tracer.
+func newExporter(ctx context.Context, t *Tracer, writer spansWriter) (*Exporter, error) {
 	return &Exporter{
-		logger:      logger,
-		context:     ctx,
-		lumigoSpans: []telemetry.Span{},
+		writer:            writer,
+		maxEntrySize:      t.cfg.MaxEntrySize,
+		maxSizeForRequest: t.cfg.MaxSizeForRequest,
+		masker:            t.masker,
+		logger:            t.logger,
+		context:           ctx,
+		lumigoSpans:       []telemetry.Span{},
+		maxSpans:          maxBufferedSpans,
 	}, nil
 }
 
-// ExportSpans writes spans in json format to file.
+// startInvocation drops the spans of the previous invocation,
+// the spans exported from now on belong to the given context
+func (e *Exporter) startInvocation(ctx context.Context) {
+	e.encoderMu.Lock()
+	defer e.encoderMu.Unlock()
+	e.context = ctx
+	e.lumigoStartSpan = telemetry.Span{}
+	e.lumigoSpans = []telemetry.Span{}
+	e.droppedSpans = 0
+}
+
+// ExportSpans writes spans in json format with the writer of the exporter.
 func (e *Exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
 	if e == nil {
 		return nil
@@ -58,34 +114,207 @@ func (e *Exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpa
 	e.encoderMu.Lock()
 	defer e.encoderMu.Unlock()
 	for _, span := range spans {
-		mapper := transform.NewMapper(e.context, span, logger, cfg.MaxEntrySize)
+		mapper := transform.NewMapper(e.context, span, e.logger, e.maxEntrySize, e.masker)
 		lumigoSpan := mapper.Transform(e.lumigoStartSpan.StartedTimestamp)
 
 		if telemetry.IsEndSpan(span) {
-			e.lumigoSpans = append(e.lumigoSpans, lumigoSpan)
 			e.logger.Info("writing end span and http spans")
-			if err := writeSpan(e.lumigoSpans, false); err != nil {
+			if e.droppedSpans > 0 {
+				lumigoSpan.DroppedSpansReasons = map[string]telemetry.DroppedSpansReason{
+					spansCountLimitReason: {Drops: e.droppedSpans},
+				}
+			}
+			if err := e.writer.writeSpans(e.context, e.trimSpans(lumigoSpan, e.lumigoSpans), false); err != nil {
 				return errors.Wrap(err, "failed to store end span and http spans")
 			}
 			return nil
 		} else if telemetry.IsStartSpan(span) {
 			e.logger.Info("writing start span")
 			e.lumigoStartSpan = lumigoSpan
-			if err := writeSpan([]telemetry.Span{lumigoSpan}, true); err != nil {
+			if err := e.writer.writeSpans(e.context, []telemetry.Span{lumigoSpan}, true); err != nil {
 				return errors.Wrap(err, "failed to store startSpan")
 			}
 			continue
 		}
+		e.bufferSpan(lumigoSpan)
+	}
+	return nil
+}
 
-		spanSize := int(reflect.TypeOf(lumigoSpan).Size())
-		if e.spansTotalSizeBytes+spanSize > cfg.MaxSizeForRequest {
-			e.logger.Warn("spans total size is bigger than max size")
+// bufferSpan keeps the span until the end of the invocation. Once
+// maxSpans spans are buffered, a failed span replaces the first
+// successful one and the other spans are dropped.
+func (e *Exporter) bufferSpan(span telemetry.Span) {
+	if len(e.lumigoSpans) < e.maxSpans {
+		e.lumigoSpans = append(e.lumigoSpans, span)
+		return
+	}
+	e.droppedSpans++
+	if !isFailedSpan(span) {
+		return
+	}
+	for i, buffered := range e.lumigoSpans {
+		if !isFailedSpan(buffered) {
+			e.lumigoSpans = append(append(e.lumigoSpans[:i:i], e.lumigoSpans[i+1:]...), span)
+			return
+		}
+	}
+}
+
+// trimSpans returns the spans and the end span which fit in
+// MaxSizeForRequest once encoded. The payloads of the spans are
+// truncated first, then the successful spans are dropped before
+// the failed ones. The end span is always kept and counts the
+// dropped spans.
+func (e *Exporter) trimSpans(endSpan telemetry.Span, childSpans []telemetry.Span) []telemetry.Span {
+	spans := append(append([]telemetry.Span{}, childSpans...), endSpan)
+	if e.spansSize(spans) <= e.maxSizeForRequest {
+		return spans
+	}
+	e.logger.Warn("spans total size is bigger than max size, truncating payloads")
+	for i := range childSpans {
+		spans[i] = truncateEntries(spans[i], trimmedEntrySize)
+	}
+	if e.spansSize(spans) <= e.maxSizeForRequest {
+		return spans
+	}
+
+	e.logger.Warn("spans total size is bigger than max size, dropping spans")
+	// the end span is measured with the highest drops count it may report
+	reasons := map[string]telemetry.DroppedSpansReason{}
+	for reason, dropped := range endSpan.DroppedSpansReasons {
+		reasons[reason] = dropped
+	}
+	reasons[spansSizeLimitReason] = telemetry.DroppedSpansReason{Drops: len(childSpans)}
+	endSpan.DroppedSpansReasons = reasons
+	size := e.spansSize([]telemetry.Span{endSpan})
+
+	order := make([]int, len(childSpans))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return isFailedSpan(spans[order[i]]) && !isFailedSpan(spans[order[j]])
+	})
+	kept := make([]bool, len(childSpans))
+	dropped := 0
+	for _, i := range order {
+		spanSize := e.spanSize(spans[i]) + 1
+		if size+spanSize > e.maxSizeForRequest {
+			dropped++
 			continue
 		}
-		e.spansTotalSizeBytes += spanSize
-		e.lumigoSpans = append(e.lumigoSpans, lumigoSpan)
+		size += spanSize
+		kept[i] = true
 	}
-	return nil
+
+	endSpan.DroppedSpansReasons[spansSizeLimitReason] = telemetry.DroppedSpansReason{Drops: dropped}
+	var trimmed []telemetry.Span
+	for i, span := range spans[:len(childSpans)] {
+		if kept[i] {
+			trimmed = append(trimmed, span)
+		}
+	}
+	return append(trimmed, endSpan)
+}
+
+// spansSize returns the size of the spans encoded as a json array
+func (e *Exporter) spansSize(spans []telemetry.Span) int {
+	size := 2 + len(spans) - 1
+	for _, span := range spans {
+		size += e.spanSize(span)
+	}
+	return size
+}
+
+// spanSize returns the size of the span encoded in json
+func (e *Exporter) spanSize(span telemetry.Span) int {
+	encoded, err := json.Marshal(span)
+	if err != nil {
+		e.logger.WithError(err).Error("failed to encode span")
+		return 0
+	}
+	return len(encoded)
+}
+
+// truncateEntries truncates the payloads of a span: the bodies and
+// headers of http, the statements of db, the messages of grpc, the
+// arguments of redis and the string attributes of manual spans
+func truncateEntries(span telemetry.Span, size int) telemetry.Span {
+	if span.SpanInfo.HttpInfo != nil {
+		info := *span.SpanInfo.HttpInfo
+		info.Request.Body = truncate(info.Request.Body, size)
+		info.Request.Headers = truncate(info.Request.Headers, size)
+		info.Response.Body = truncate(info.Response.Body, size)
+		info.Response.Headers = truncate(info.Response.Headers, size)
+		span.SpanInfo.HttpInfo = &info
+	}
+	if span.SpanInfo.DBInfo != nil {
+		info := *span.SpanInfo.DBInfo
+		info.Statement = truncate(info.Statement, size)
+		info.Parameters = truncate(info.Parameters, size)
+		span.SpanInfo.DBInfo = &info
+	}
+	if span.SpanInfo.GRPCInfo != nil {
+		info := *span.SpanInfo.GRPCInfo
+		info.Request = truncate(info.Request, size)
+		info.Response = truncate(info.Response, size)
+		span.SpanInfo.GRPCInfo = &info
+	}
+	if span.SpanInfo.RedisInfo != nil {
+		info := *span.SpanInfo.RedisInfo
+		info.Commands = make([]telemetry.RedisCommand, len(span.SpanInfo.RedisInfo.Commands))
+		for i, command := range span.SpanInfo.RedisInfo.Commands {
+			command.Args = truncate(command.Args, size)
+			info.Commands[i] = command
+		}
+		span.SpanInfo.RedisInfo = &info
+	}
+	if span.SpanInfo.ManualInfo != nil && span.SpanInfo.ManualInfo.Attributes != nil {
+		info := *span.SpanInfo.ManualInfo
+		info.Attributes = make(map[string]interface{}, len(span.SpanInfo.ManualInfo.Attributes))
+		for key, value := range span.SpanInfo.ManualInfo.Attributes {
+			if str, ok := value.(string); ok {
+				value = truncate(str, size)
+			}
+			info.Attributes[key] = value
+		}
+		span.SpanInfo.ManualInfo = &info
+	}
+	if span.SpanInfo.LambdaInvoke != nil {
+		info := *span.SpanInfo.LambdaInvoke
+		info.LogResult = truncate(info.LogResult, size)
+		span.SpanInfo.LambdaInvoke = &info
+	}
+	return span
+}
+
+// isFailedSpan returns true if the span has an error, the request
+// of an http span got no response or an error status code, a grpc
+// call ended with a non OK code or an invoked lambda failed
+func isFailedSpan(span telemetry.Span) bool {
+	if span.SpanError != nil {
+		return true
+	}
+	info := span.SpanInfo
+	if info.GRPCInfo != nil && info.GRPCInfo.StatusCode != 0 {
+		return true
+	}
+	if info.LambdaInvoke != nil && info.LambdaInvoke.FunctionError != "" {
+		return true
+	}
+	if info.HttpInfo == nil {
+		return false
+	}
+	statusCode := info.HttpInfo.Response.StatusCode
+	return statusCode == nil || *statusCode >= 400
+}
+
+func truncate(value string, size int) string {
+	if len(value) <= size {
+		return value
+	}
+	return value[:size]
 }
 
 // Shutdown is called to stop the exporter, it preforms no action.
@@ -103,21 +332,92 @@ func (e *Exporter) Shutdown(ctx context.Context) error {
 	return nil
 }
 
-func writeSpan(spans []telemetry.Span, isStart bool) error {
+// multiExporter fans the spans out to several exporters,
+// a failing exporter doesn't keep the spans from the others
+type multiExporter []sdktrace.SpanExporter
+
+func (m multiExporter) startInvocation(ctx context.Context) {
+	for _, exporter := range m {
+		if e, ok := exporter.(invocationExporter); ok {
+			e.startInvocation(ctx)
+		}
+	}
+}
+
+// ExportSpans exports the spans with every exporter
+func (m multiExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	var errs []string
+	for _, exporter := range m {
+		if err := exporter.ExportSpans(ctx, spans); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to export spans: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Shutdown stops every exporter
+func (m multiExporter) Shutdown(ctx context.Context) error {
+	var errs []string
+	for _, exporter := range m {
+		if err := exporter.Shutdown(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to shutdown exporters: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// writeSpan writes the spans to a new file of the given dir, the
+// file is visible under its final name only once it is complete
+func writeSpan(dir string, spans []telemetry.Span, isStart bool) error {
 	var file string
 	if isStart {
 		file = fmt.Sprintf("%s_span", ksuid.New())
 	} else {
 		file = fmt.Sprintf("%s_end", ksuid.New())
 	}
-	file = filepath.Join(SPANS_DIR, file)
-	writer, err := os.Create(file)
+	return writeFileAtomic(filepath.Join(dir, file), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(spans)
+	})
+}
+
+// writeFileAtomic writes a temporary file and renames it to the given
+// file once it is synced, so a reader never sees a partial file. The
+// temporary file is kept in a sibling of the dir of the file, on the
+// same filesystem, as the extension reads every file of the spans dir.
+// The temporary file is removed when the write fails.
+func writeFileAtomic(file string, write func(w io.Writer) error) (err error) {
+	tmpDir := filepath.Dir(file) + ".tmp"
+	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "failed to create dir: %s", tmpDir)
+	}
+	tmp, err := os.CreateTemp(tmpDir, "lumigo-*.tmp")
 	if err != nil {
 		return errors.Wrapf(err, "failed to create span data store: %s", file)
 	}
-	enc := json.NewEncoder(writer)
-	if err := enc.Encode(spans); err != nil {
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := write(tmp); err != nil {
 		return errors.Wrapf(err, "failed to write span in data store: %s", file)
 	}
+	if err := tmp.Sync(); err != nil {
+		return errors.Wrapf(err, "failed to sync span data store: %s", file)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close span data store: %s", file)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return errors.Wrapf(err, "failed to rename span data store: %s", file)
+	}
 	return nil
 }
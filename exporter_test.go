@@ -3,7 +3,9 @@ package lumigotracer
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -50,7 +52,7 @@ func (e *exporterTestSuite) TestNilExporter() {
 }
 
 func (e *exporterTestSuite) TestExportSpans() {
-	logger.Out = ioutil.Discard
+	logger := newLogger(false)
 	os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "test")
 	os.Setenv("AWS_REGION", "us-east-1")
 	spanID, _ := oteltrace.SpanIDFromHex("83887e5d7da921ba")
@@ -114,7 +116,9 @@ func (e *exporterTestSuite) TestExportSpans() {
 	}
 
 	testContext := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
-	exp, err := createExporter(false, testContext, logger)
+	lt, err := New(WithToken("test"), WithLogger(logger))
+	assert.NoError(e.T(), err)
+	exp, err := lt.createExporter(testContext)
 	assert.NoError(e.T(), err)
 
 	err = exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{
@@ -143,9 +147,7 @@ func (e *exporterTestSuite) TestExportSpans() {
 }
 
 func (e *exporterTestSuite) TestExportSpansReachLimit() {
-	oldConfig := cfg.MaxSizeForRequest
-	cfg.MaxSizeForRequest = 1200
-	logger.Out = ioutil.Discard
+	logger := newLogger(false)
 	os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "test")
 	os.Setenv("AWS_REGION", "us-east-1")
 	spanID, _ := oteltrace.SpanIDFromHex("83887e5d7da921ba")
@@ -160,7 +162,9 @@ func (e *exporterTestSuite) TestExportSpansReachLimit() {
 	endSpan := &tracetest.SpanStub{Name: "LumigoParentSpan", SpanContext: spanCtx}
 
 	testContext := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
-	exp, err := createExporter(false, testContext, logger)
+	lt, err := New(WithToken("test"), WithLogger(logger), WithMaxSizeForRequest(1200))
+	assert.NoError(e.T(), err)
+	exp, err := lt.createExporter(testContext)
 	assert.NoError(e.T(), err)
 
 	err = exp.ExportSpans(context.Background(), []trace.ReadOnlySpan{
@@ -179,9 +183,201 @@ func (e *exporterTestSuite) TestExportSpansReachLimit() {
 	assert.NoError(e.T(), err)
 	spans, err := readSpansFromFile()
 	assert.NoError(e.T(), err)
-	assert.Equal(e.T(), 4, len(spans.endFileSpans))
+	assert.Equal(e.T(), 1, len(spans.endFileSpans))
+	assert.Equal(e.T(), mockLambdaContext.AwsRequestID, spans.endFileSpans[0].ID)
+	assert.Equal(e.T(), 11, spans.endFileSpans[0].DroppedSpansReasons[spansSizeLimitReason].Drops)
 	assert.NoError(e.T(), deleteAllFiles())
-	cfg.MaxSizeForRequest = oldConfig
+}
+
+func newTrimTestSpan(id string, statusCode int64) telemetry.Span {
+	return telemetry.Span{
+		ID:       id,
+		SpanType: "http",
+		SpanInfo: telemetry.SpanInfo{
+			HttpInfo: &telemetry.SpanHttpInfo{
+				Host: "example.com",
+				Request: telemetry.SpanHttpCommon{
+					Body:    strings.Repeat("a", 1000),
+					Headers: strings.Repeat("b", 500),
+				},
+				Response: telemetry.SpanHttpCommon{
+					StatusCode: aws.Int64(statusCode),
+					Body:       strings.Repeat("c", 1000),
+				},
+			},
+		},
+	}
+}
+
+func (e *exporterTestSuite) TestTrimSpansUnderLimit() {
+	exp := &Exporter{maxSizeForRequest: 1024 * 500, logger: newLogger(false)}
+	httpSpans := []telemetry.Span{newTrimTestSpan("1", 200), newTrimTestSpan("2", 200)}
+	endSpan := telemetry.Span{ID: "end"}
+
+	spans := exp.trimSpans(endSpan, httpSpans)
+	assert.Equal(e.T(), append(httpSpans, endSpan), spans)
+	assert.Nil(e.T(), spans[2].DroppedSpansReasons)
+}
+
+func (e *exporterTestSuite) TestTrimSpansTruncatesEntries() {
+	exp := &Exporter{logger: newLogger(false)}
+	httpSpans := []telemetry.Span{newTrimTestSpan("1", 200), newTrimTestSpan("2", 200)}
+	endSpan := telemetry.Span{ID: "end"}
+	expected := []telemetry.Span{
+		truncateEntries(httpSpans[0], trimmedEntrySize),
+		truncateEntries(httpSpans[1], trimmedEntrySize),
+		endSpan,
+	}
+	exp.maxSizeForRequest = exp.spansSize(expected)
+
+	spans := exp.trimSpans(endSpan, httpSpans)
+	assert.Equal(e.T(), expected, spans)
+	assert.Equal(e.T(), trimmedEntrySize, len(spans[0].SpanInfo.HttpInfo.Request.Body))
+	assert.Equal(e.T(), trimmedEntrySize, len(spans[1].SpanInfo.HttpInfo.Response.Body))
+	assert.Equal(e.T(), 1000, len(httpSpans[0].SpanInfo.HttpInfo.Request.Body))
+}
+
+func (e *exporterTestSuite) TestTrimSpansDropsSuccessfulSpansFirst() {
+	exp := &Exporter{logger: newLogger(false)}
+	httpSpans := []telemetry.Span{newTrimTestSpan("1", 200), newTrimTestSpan("2", 500), newTrimTestSpan("3", 200)}
+	endSpan := telemetry.Span{ID: "end"}
+	expectedEnd := endSpan
+	expectedEnd.DroppedSpansReasons = map[string]telemetry.DroppedSpansReason{
+		spansSizeLimitReason: {Drops: 2},
+	}
+	expected := []telemetry.Span{truncateEntries(httpSpans[1], trimmedEntrySize), expectedEnd}
+	exp.maxSizeForRequest = exp.spansSize(expected)
+
+	spans := exp.trimSpans(endSpan, httpSpans)
+	assert.Equal(e.T(), expected, spans)
+}
+
+func (e *exporterTestSuite) TestTrimSpansKeepsEndSpan() {
+	exp := &Exporter{maxSizeForRequest: 10, logger: newLogger(false)}
+	httpSpans := []telemetry.Span{newTrimTestSpan("1", 200), newTrimTestSpan("2", 500)}
+	endSpan := telemetry.Span{ID: "end"}
+
+	spans := exp.trimSpans(endSpan, httpSpans)
+	assert.Equal(e.T(), 1, len(spans))
+	assert.Equal(e.T(), "end", spans[0].ID)
+	assert.Equal(e.T(), 2, spans[0].DroppedSpansReasons[spansSizeLimitReason].Drops)
+}
+
+func (e *exporterTestSuite) TestTrimSpansTruncatesEveryType() {
+	payload := strings.Repeat("a", 1000)
+	span := telemetry.Span{SpanInfo: telemetry.SpanInfo{
+		DBInfo:     &telemetry.DBInfo{Statement: payload, Parameters: payload},
+		GRPCInfo:   &telemetry.GRPCInfo{Request: payload, Response: payload},
+		RedisInfo:  &telemetry.RedisInfo{Commands: []telemetry.RedisCommand{{Name: "SET", Args: payload}}},
+		ManualInfo: &telemetry.ManualInfo{Attributes: map[string]interface{}{"text": payload, "count": 1}},
+	}}
+
+	truncated := truncateEntries(span, trimmedEntrySize).SpanInfo
+	assert.Equal(e.T(), trimmedEntrySize, len(truncated.DBInfo.Statement))
+	assert.Equal(e.T(), trimmedEntrySize, len(truncated.DBInfo.Parameters))
+	assert.Equal(e.T(), trimmedEntrySize, len(truncated.GRPCInfo.Request))
+	assert.Equal(e.T(), trimmedEntrySize, len(truncated.GRPCInfo.Response))
+	assert.Equal(e.T(), trimmedEntrySize, len(truncated.RedisInfo.Commands[0].Args))
+	assert.Equal(e.T(), trimmedEntrySize, len(truncated.ManualInfo.Attributes["text"].(string)))
+	assert.Equal(e.T(), 1, truncated.ManualInfo.Attributes["count"])
+	assert.Equal(e.T(), payload, span.SpanInfo.RedisInfo.Commands[0].Args)
+	assert.Equal(e.T(), payload, span.SpanInfo.ManualInfo.Attributes["text"])
+}
+
+func (e *exporterTestSuite) TestIsFailedSpan() {
+	assert.True(e.T(), isFailedSpan(telemetry.Span{SpanError: &telemetry.SpanError{}}))
+	assert.True(e.T(), isFailedSpan(newTrimTestSpan("1", 500)))
+	assert.False(e.T(), isFailedSpan(newTrimTestSpan("1", 200)))
+	assert.True(e.T(), isFailedSpan(telemetry.Span{SpanInfo: telemetry.SpanInfo{GRPCInfo: &telemetry.GRPCInfo{StatusCode: 14}}}))
+	assert.False(e.T(), isFailedSpan(telemetry.Span{SpanInfo: telemetry.SpanInfo{GRPCInfo: &telemetry.GRPCInfo{}}}))
+	assert.False(e.T(), isFailedSpan(telemetry.Span{SpanInfo: telemetry.SpanInfo{DBInfo: &telemetry.DBInfo{}}}))
+}
+
+func (e *exporterTestSuite) TestBufferSpanCapped() {
+	exp := &Exporter{maxSpans: 2, logger: newLogger(false)}
+	exp.bufferSpan(newTrimTestSpan("1", 200))
+	exp.bufferSpan(newTrimTestSpan("2", 500))
+	exp.bufferSpan(newTrimTestSpan("3", 200))
+	exp.bufferSpan(newTrimTestSpan("4", 500))
+	exp.bufferSpan(newTrimTestSpan("5", 500))
+
+	var ids []string
+	for _, span := range exp.lumigoSpans {
+		ids = append(ids, span.ID)
+	}
+	assert.Equal(e.T(), []string{"2", "4"}, ids)
+	assert.Equal(e.T(), 3, exp.droppedSpans)
+
+	exp.startInvocation(context.Background())
+	assert.Empty(e.T(), exp.lumigoSpans)
+	assert.Zero(e.T(), exp.droppedSpans)
+}
+
+func (e *exporterTestSuite) TestTrimSpansKeepsCountLimitDrops() {
+	exp := &Exporter{maxSizeForRequest: 10, logger: newLogger(false)}
+	endSpan := telemetry.Span{ID: "end", DroppedSpansReasons: map[string]telemetry.DroppedSpansReason{
+		spansCountLimitReason: {Drops: 3},
+	}}
+
+	spans := exp.trimSpans(endSpan, []telemetry.Span{newTrimTestSpan("1", 200)})
+	assert.Equal(e.T(), 1, len(spans))
+	assert.Equal(e.T(), 3, spans[0].DroppedSpansReasons[spansCountLimitReason].Drops)
+	assert.Equal(e.T(), 1, spans[0].DroppedSpansReasons[spansSizeLimitReason].Drops)
+}
+
+func (e *exporterTestSuite) TestWriteSpanLeavesOnlyCompleteFiles() {
+	dir := e.T().TempDir()
+	spans := []telemetry.Span{{ID: "start"}}
+
+	assert.NoError(e.T(), writeSpan(dir, spans, true))
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(e.T(), err)
+	assert.Equal(e.T(), 1, len(files))
+	assert.True(e.T(), strings.HasSuffix(files[0].Name(), "_span"))
+
+	container, err := readSpansFromDir(dir)
+	assert.NoError(e.T(), err)
+	assert.Equal(e.T(), spans, container.startFileSpans)
+}
+
+func (e *exporterTestSuite) TestWriteFileAtomicFailureMidWrite() {
+	dir := e.T().TempDir()
+	file := filepath.Join(dir, "spans_end")
+
+	err := writeFileAtomic(file, func(w io.Writer) error {
+		if _, err := w.Write([]byte(`[{"id":"partial`)); err != nil {
+			return err
+		}
+		return errors.New("disk full")
+	})
+	assert.Error(e.T(), err)
+	assert.Contains(e.T(), err.Error(), "disk full")
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(e.T(), err)
+	assert.Equal(e.T(), 0, len(files))
+	files, err = ioutil.ReadDir(dir + ".tmp")
+	assert.NoError(e.T(), err)
+	assert.Equal(e.T(), 0, len(files))
+}
+
+func (e *exporterTestSuite) TestWriteFileAtomicTempOutsideDir() {
+	dir := e.T().TempDir()
+
+	err := writeFileAtomic(filepath.Join(dir, "spans_end"), func(w io.Writer) error {
+		// the spans dir has no file until the write completes
+		files, err := ioutil.ReadDir(dir)
+		assert.NoError(e.T(), err)
+		assert.Equal(e.T(), 0, len(files))
+		_, err = w.Write([]byte("[]"))
+		return err
+	})
+	assert.NoError(e.T(), err)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(e.T(), err)
+	assert.Equal(e.T(), 1, len(files))
+	assert.Equal(e.T(), "spans_end", files[0].Name())
 }
 
 type spanContainer struct {
@@ -190,7 +386,11 @@ type spanContainer struct {
 }
 
 func readSpansFromFile() (spanContainer, error) {
-	files, err := ioutil.ReadDir(SPANS_DIR)
+	return readSpansFromDir(SPANS_DIR)
+}
+
+func readSpansFromDir(dir string) (spanContainer, error) {
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return spanContainer{}, err
 	}
@@ -198,7 +398,7 @@ func readSpansFromFile() (spanContainer, error) {
 	var container spanContainer
 	for _, file := range files {
 		var spans []telemetry.Span
-		filename := filepath.Join(SPANS_DIR, file.Name())
+		filename := filepath.Join(dir, file.Name())
 		content, err := ioutil.ReadFile(filename)
 		if err != nil {
 			return spanContainer{}, err
@@ -217,13 +417,17 @@ func readSpansFromFile() (spanContainer, error) {
 }
 
 func deleteAllFiles() error {
-	files, err := ioutil.ReadDir(SPANS_DIR)
+	return deleteAllFilesInDir(SPANS_DIR)
+}
+
+func deleteAllFilesInDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return err
 	}
 
 	for _, file := range files {
-		filepath := filepath.Join(SPANS_DIR, file.Name())
+		filepath := filepath.Join(dir, file.Name())
 		if err := os.Remove(filepath); err != nil {
 			return err
 		}
@@ -0,0 +1,268 @@
+// Package grpc traces the gRPC calls of the clients created with its
+// interceptors, the calls of traced invocations are sent as grpc spans.
+// Its server interceptors continue the trace context of the calls.
+//
+// The package name shadows google.golang.org/grpc, import it with an
+// alias if both are used in a file, e.g. lumigogrpc.
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// UnaryClientInterceptor returns an interceptor tracing the
+// unary calls of a client, e.g. with grpc.WithUnaryInterceptor
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !instrumentation.IsTraced(ctx) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		ctx, span := startSpan(ctx, method, cc)
+		defer span.End()
+		span.SetAttributes(attribute.String(telemetry.GRPCRequestKey, encodeMessage(ctx, req)))
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			span.SetAttributes(attribute.String(telemetry.GRPCResponseKey, encodeMessage(ctx, reply)))
+		}
+		endSpan(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns an interceptor tracing the streams
+// of a client, e.g. with grpc.WithStreamInterceptor. The span of a
+// stream ends when its last message is received, or when the context
+// of the stream is done if it isn't drained.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !instrumentation.IsTraced(ctx) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, span := startSpan(ctx, method, cc)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			endSpan(span, err)
+			span.End()
+			return nil, err
+		}
+		cs := &clientStream{ClientStream: stream, span: span, serverStreams: desc.ServerStreams, done: make(chan struct{})}
+		go func() {
+			select {
+			case <-ctx.Done():
+				cs.end(status.FromContextError(ctx.Err()).Err())
+			case <-cs.done:
+			}
+		}()
+		return cs, nil
+	}
+}
+
+// Option configures the server interceptors
+type Option func(*config)
+
+type config struct {
+	propagator propagation.TextMapPropagator
+}
+
+// WithPropagator sets the propagator used to extract the trace
+// context of the calls, the W3C Trace Context by default
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{propagator: propagation.TraceContext{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// extract returns ctx with the trace context
+// received in the metadata of the call
+func (c *config) extract(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return c.propagator.Extract(ctx, metadataCarrier(md))
+}
+
+// UnaryServerInterceptor returns an interceptor continuing the trace
+// context sent by the clients in the metadata of the unary calls, e.g.
+// with grpc.UnaryInterceptor. The handlers get it in their context.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	c := newConfig(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(c.extract(ctx), req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor continuing the trace
+// context sent by the clients in the metadata of the streams, e.g. with
+// grpc.StreamInterceptor. The handlers get it in the stream context.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	c := newConfig(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: c.extract(ss.Context())})
+	}
+}
+
+// serverStream replaces the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// startSpan starts the span of a call to method, the trace
+// context is sent to the server in the metadata of the call
+func startSpan(ctx context.Context, method string, cc *grpc.ClientConn) (context.Context, trace.Span) {
+	service, name := splitMethod(method)
+	ctx, span := instrumentation.Start(ctx, method, telemetry.GRPCSpanType, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		semconv.RPCSystemKey.String("grpc"),
+		semconv.RPCServiceKey.String(service),
+		semconv.RPCMethodKey.String(name),
+	)
+	if cc != nil {
+		span.SetAttributes(attribute.String(telemetry.GRPCTargetKey, cc.Target()))
+	}
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	instrumentation.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// endSpan sets the status of the call ended with err
+func endSpan(span trace.Span, err error) {
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(status.Code(err))))
+	instrumentation.RecordError(span, err)
+}
+
+// clientStream traces a stream, its span has the
+// first message sent and the first message received
+type clientStream struct {
+	grpc.ClientStream
+	span          trace.Span
+	serverStreams bool
+
+	sentOnce     sync.Once
+	receivedOnce sync.Once
+	endOnce      sync.Once
+	// done is closed when the span ends
+	done chan struct{}
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.sentOnce.Do(func() {
+			s.span.SetAttributes(attribute.String(telemetry.GRPCRequestKey, encodeMessage(s.Context(), m)))
+		})
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.end(nil)
+	case err != nil:
+		s.end(err)
+	default:
+		s.receivedOnce.Do(func() {
+			s.span.SetAttributes(attribute.String(telemetry.GRPCResponseKey, encodeMessage(s.Context(), m)))
+		})
+		if !s.serverStreams {
+			// the server sends a single message
+			s.end(nil)
+		}
+	}
+	return err
+}
+
+func (s *clientStream) end(err error) {
+	s.endOnce.Do(func() {
+		endSpan(s.span, err)
+		s.span.End()
+		close(s.done)
+	})
+}
+
+// splitMethod returns the service and the method
+// of a full method name, e.g. /package.Service/Method
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "", fullMethod
+}
+
+// encodeMessage returns a message encoded as JSON, masked and
+// truncated as configured for the traced invocation of ctx
+func encodeMessage(ctx context.Context, m interface{}) string {
+	if pm, ok := m.(proto.Message); ok {
+		if encoded, err := protojson.Marshal(pm); err == nil {
+			return instrumentation.MaskAndLimit(ctx, string(encoded))
+		}
+	}
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return instrumentation.MaskAndLimit(ctx, string(encoded))
+}
+
+// metadataCarrier adapts the metadata of
+// a call to a propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,284 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// listDesc is a server streaming service sending
+// the status of the requested service twice
+var listDesc = grpc.ServiceDesc{
+	ServiceName: "test.Health",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "List",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			var req healthpb.HealthCheckRequest
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			for i := 0; i < 2; i++ {
+				if err := stream.SendMsg(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}},
+}
+
+type grpcTestSuite struct {
+	suite.Suite
+	recorder *tracetest.SpanRecorder
+	ctx      context.Context
+	conn     *grpc.ClientConn
+	// traceparents are the traceparent metadata received by the server
+	traceparents []string
+	// serverSpanContexts are the span contexts of the server handlers
+	serverSpanContexts []trace.SpanContext
+}
+
+func TestSetupGRPCSuite(t *testing.T) {
+	suite.Run(t, &grpcTestSuite{})
+}
+
+func (s *grpcTestSuite) SetupTest() {
+	s.traceparents = nil
+	s.serverSpanContexts = nil
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(), func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			s.traceparents = append(s.traceparents, md.Get("traceparent")...)
+			s.serverSpanContexts = append(s.serverSpanContexts, trace.SpanContextFromContext(ctx))
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(), func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			s.traceparents = append(s.traceparents, md.Get("traceparent")...)
+			s.serverSpanContexts = append(s.serverSpanContexts, trace.SpanContextFromContext(ss.Context()))
+			return handler(srv, ss)
+		}),
+	)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	server.RegisterService(&listDesc, struct{}{})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	s.T().Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(StreamClientInterceptor()),
+	)
+	assert.NoError(s.T(), err)
+	s.T().Cleanup(func() { conn.Close() })
+	s.conn = conn
+
+	s.recorder = tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.recorder))
+	ctx := lumigoctx.NewContext(context.Background(), &lumigoctx.LumigoContext{Propagator: propagation.TraceContext{}})
+	ctx, span := provider.Tracer("test").Start(ctx, "invocation")
+	s.T().Cleanup(func() { span.End() })
+	s.ctx = ctx
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func (s *grpcTestSuite) TestUnary() {
+	res, err := healthpb.NewHealthClient(s.conn).Check(s.ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	span := spans[0]
+	assert.Equal(s.T(), "/grpc.health.v1.Health/Check", span.Name())
+	assert.Equal(s.T(), s.recorder.Started()[0].SpanContext().SpanID(), span.Parent().SpanID())
+	attrs := attributes(span)
+	assert.Equal(s.T(), telemetry.GRPCSpanType, attrs[telemetry.SpanTypeKey].AsString())
+	assert.Equal(s.T(), "bufnet", attrs[telemetry.GRPCTargetKey].AsString())
+	assert.Equal(s.T(), "grpc.health.v1.Health", attrs["rpc.service"].AsString())
+	assert.Equal(s.T(), "Check", attrs["rpc.method"].AsString())
+	assert.Equal(s.T(), int64(0), attrs["rpc.grpc.status_code"].AsInt64())
+	assert.JSONEq(s.T(), `{"service":"orders"}`, attrs[telemetry.GRPCRequestKey].AsString())
+	assert.JSONEq(s.T(), `{"status":"SERVING"}`, attrs[telemetry.GRPCResponseKey].AsString())
+	assert.Equal(s.T(), codes.Unset, span.Status().Code)
+
+	assert.Equal(s.T(), 1, len(s.traceparents))
+	traceparent := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	assert.Equal(s.T(), traceparent, s.traceparents[0])
+
+	// the server continues the trace of the call
+	assert.Equal(s.T(), 1, len(s.serverSpanContexts))
+	assert.True(s.T(), s.serverSpanContexts[0].IsRemote())
+	assert.Equal(s.T(), span.SpanContext().TraceID(), s.serverSpanContexts[0].TraceID())
+	assert.Equal(s.T(), span.SpanContext().SpanID(), s.serverSpanContexts[0].SpanID())
+}
+
+func (s *grpcTestSuite) TestMessagesMaskedAtCapture() {
+	masker, err := masking.New([]string{"service"})
+	assert.NoError(s.T(), err)
+	lc, _ := lumigoctx.FromContext(s.ctx)
+	lc.Masker = masker
+	lc.MaxEntrySize = 12
+
+	_, err = healthpb.NewHealthClient(s.conn).Check(s.ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.NoError(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	attrs := attributes(spans[0])
+	assert.Equal(s.T(), `{"service":"`, attrs[telemetry.GRPCRequestKey].AsString())
+	// protojson may add spaces between the keys and the values
+	assert.Equal(s.T(), 12, len(attrs[telemetry.GRPCResponseKey].AsString()))
+	assert.True(s.T(), strings.HasPrefix(attrs[telemetry.GRPCResponseKey].AsString(), `{"status":`))
+
+	lc.MaxEntrySize = 1024
+	_, err = healthpb.NewHealthClient(s.conn).Check(s.ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.NoError(s.T(), err)
+	spans = s.recorder.Ended()
+	assert.Equal(s.T(), 2, len(spans))
+	assert.JSONEq(s.T(), `{"service":"****"}`, attributes(spans[1])[telemetry.GRPCRequestKey].AsString())
+}
+
+func (s *grpcTestSuite) TestUnaryError() {
+	_, err := healthpb.NewHealthClient(s.conn).Check(s.ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Error(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	attrs := attributes(spans[0])
+	assert.Equal(s.T(), int64(5), attrs["rpc.grpc.status_code"].AsInt64())
+	assert.Equal(s.T(), codes.Error, spans[0].Status().Code)
+	assert.True(s.T(), attrs["has_error"].AsBool())
+	assert.Equal(s.T(), "rpc error: code = NotFound desc = unknown service", attrs["error_message"].AsString())
+	_, hasResponse := attrs[telemetry.GRPCResponseKey]
+	assert.False(s.T(), hasResponse)
+}
+
+func (s *grpcTestSuite) TestStream() {
+	stream, err := s.conn.NewStream(s.ctx, &listDesc.Streams[0], "/test.Health/List")
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), stream.SendMsg(&healthpb.HealthCheckRequest{Service: "orders"}))
+	assert.NoError(s.T(), stream.CloseSend())
+
+	received := 0
+	for {
+		var res healthpb.HealthCheckResponse
+		if err := stream.RecvMsg(&res); err == io.EOF {
+			break
+		} else {
+			assert.NoError(s.T(), err)
+		}
+		received++
+		assert.Empty(s.T(), s.recorder.Ended())
+	}
+	assert.Equal(s.T(), 2, received)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	attrs := attributes(spans[0])
+	assert.Equal(s.T(), "test.Health", attrs["rpc.service"].AsString())
+	assert.Equal(s.T(), "List", attrs["rpc.method"].AsString())
+	assert.Equal(s.T(), int64(0), attrs["rpc.grpc.status_code"].AsInt64())
+	assert.JSONEq(s.T(), `{"service":"orders"}`, attrs[telemetry.GRPCRequestKey].AsString())
+	assert.JSONEq(s.T(), `{"status":"SERVING"}`, attrs[telemetry.GRPCResponseKey].AsString())
+	assert.Equal(s.T(), 1, len(s.traceparents))
+	assert.Equal(s.T(), 1, len(s.serverSpanContexts))
+	assert.True(s.T(), s.serverSpanContexts[0].IsRemote())
+	assert.Equal(s.T(), spans[0].SpanContext().SpanID(), s.serverSpanContexts[0].SpanID())
+}
+
+func (s *grpcTestSuite) TestStreamNotDrained() {
+	ctx, cancel := context.WithCancel(s.ctx)
+	stream, err := s.conn.NewStream(ctx, &listDesc.Streams[0], "/test.Health/List")
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), stream.SendMsg(&healthpb.HealthCheckRequest{Service: "orders"}))
+	assert.NoError(s.T(), stream.CloseSend())
+	var res healthpb.HealthCheckResponse
+	assert.NoError(s.T(), stream.RecvMsg(&res))
+	assert.Empty(s.T(), s.recorder.Ended())
+
+	// the caller stops reading the stream
+	cancel()
+	assert.Eventually(s.T(), func() bool {
+		return len(s.recorder.Ended()) == 1
+	}, time.Second, 10*time.Millisecond)
+	attrs := attributes(s.recorder.Ended()[0])
+	assert.Equal(s.T(), int64(1), attrs["rpc.grpc.status_code"].AsInt64())
+	assert.True(s.T(), attrs["has_error"].AsBool())
+	assert.JSONEq(s.T(), `{"status":"SERVING"}`, attrs[telemetry.GRPCResponseKey].AsString())
+}
+
+func (s *grpcTestSuite) TestNotTraced() {
+	_, err := healthpb.NewHealthClient(s.conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.NoError(s.T(), err)
+
+	assert.Empty(s.T(), s.recorder.Ended())
+	assert.Empty(s.T(), s.traceparents)
+	assert.Equal(s.T(), 1, len(s.serverSpanContexts))
+	assert.False(s.T(), s.serverSpanContexts[0].IsValid())
+}
+
+func TestUnaryServerInterceptorPropagator(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", traceparent))
+	var handlerCtx context.Context
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCtx = ctx
+		return nil, nil
+	}
+
+	_, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.SpanContextFromContext(handlerCtx).TraceID().String())
+
+	_, err = UnaryServerInterceptor(WithPropagator(propagation.Baggage{}))(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+	assert.False(t, trace.SpanContextFromContext(handlerCtx).IsValid())
+}
+
+func TestSplitMethod(t *testing.T) {
+	service, method := splitMethod("/package.Service/Method")
+	assert.Equal(t, "package.Service", service)
+	assert.Equal(t, "Method", method)
+
+	service, method = splitMethod("Method")
+	assert.Equal(t, "", service)
+	assert.Equal(t, "Method", method)
+}
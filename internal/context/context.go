@@ -1,6 +1,12 @@
 package context
 
-import "context"
+import (
+	"context"
+	"sync"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
+	"go.opentelemetry.io/otel/propagation"
+)
 
 // An unexported type to be used as the key for types in this package.
 // This prevents collisions with keys defined in other packages.
@@ -9,9 +15,54 @@ type key struct{}
 // The key for a LumigoContext in Contexts
 var lumigoKey = &key{}
 
+// spanIDKey is the key for the ID of the current
+// manual span in Contexts
+type spanIDKey struct{}
+
 // LumigoContext is the set of metadata that is passed for every Invoke.
 type LumigoContext struct {
-	TracerVersion string
+	TracerVersion    string
+	InvocationNumber int64
+
+	// Propagator injects the trace context in
+	// the requests sent during the invocation
+	Propagator propagation.TextMapPropagator
+
+	// Masker and MaxEntrySize mask and truncate the
+	// values captured by the spans of the invocation
+	Masker       *masking.Masker
+	MaxEntrySize int
+
+	tagsMu        sync.Mutex
+	executionTags []ExecutionTag
+
+	issuesMu       sync.Mutex
+	reportedIssues []ReportedIssue
+}
+
+// ExecutionTag is a tag of an invocation, searchable in Lumigo
+type ExecutionTag struct {
+	Key   string
+	Value string
+}
+
+// AddExecutionTag adds a tag to the invocation, it returns false
+// without adding it if the invocation has maxTags tags already
+func (lc *LumigoContext) AddExecutionTag(key string, value string, maxTags int) bool {
+	lc.tagsMu.Lock()
+	defer lc.tagsMu.Unlock()
+	if len(lc.executionTags) >= maxTags {
+		return false
+	}
+	lc.executionTags = append(lc.executionTags, ExecutionTag{Key: key, Value: value})
+	return true
+}
+
+// ExecutionTags returns the tags of the invocation
+func (lc *LumigoContext) ExecutionTags() []ExecutionTag {
+	lc.tagsMu.Lock()
+	defer lc.tagsMu.Unlock()
+	return append([]ExecutionTag(nil), lc.executionTags...)
 }
 
 // NewContext returns a new Context that carries value lumigo context.
@@ -24,3 +75,43 @@ func FromContext(ctx context.Context) (*LumigoContext, bool) {
 	lc, ok := ctx.Value(lumigoKey).(*LumigoContext)
 	return lc, ok
 }
+
+// WithSpanID returns a new Context that carries the ID of a manual span
+func WithSpanID(parent context.Context, spanID string) context.Context {
+	return context.WithValue(parent, spanIDKey{}, spanID)
+}
+
+// SpanIDFromContext returns the ID of the manual span stored in ctx, if any.
+func SpanIDFromContext(ctx context.Context) (string, bool) {
+	spanID, ok := ctx.Value(spanIDKey{}).(string)
+	return spanID, ok
+}
+
+// ReportedIssue is a non fatal error or warning reported
+// during the invocation
+type ReportedIssue struct {
+	Level      string
+	Type       string
+	Message    string
+	Stacktrace string
+	Timestamp  int64
+}
+
+// AddReportedIssue adds an issue to the invocation, it returns false
+// without adding it if the invocation has maxIssues issues already
+func (lc *LumigoContext) AddReportedIssue(issue ReportedIssue, maxIssues int) bool {
+	lc.issuesMu.Lock()
+	defer lc.issuesMu.Unlock()
+	if len(lc.reportedIssues) >= maxIssues {
+		return false
+	}
+	lc.reportedIssues = append(lc.reportedIssues, issue)
+	return true
+}
+
+// ReportedIssues returns the issues reported during the invocation
+func (lc *LumigoContext) ReportedIssues() []ReportedIssue {
+	lc.issuesMu.Lock()
+	defer lc.issuesMu.Unlock()
+	return append([]ReportedIssue(nil), lc.reportedIssues...)
+}
@@ -0,0 +1,167 @@
+package instrumentation
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxErrorChainLength limits the causes unwrapped from an error
+const maxErrorChainLength = 32
+
+// wrapperErrorTypes are the types of the errors which carry only
+// a message, a stack or a cause, their type tells nothing
+var wrapperErrorTypes = map[string]bool{
+	"*errors.errorString": true,
+	"*errors.fundamental": true,
+	"*errors.withStack":   true,
+	"*errors.withMessage": true,
+	"*fmt.wrapError":      true,
+	"*fmt.wrapErrors":     true,
+}
+
+// stackTracer is implemented by the errors of github.com/pkg/errors
+type stackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+// callersTracer is implemented by the errors recording
+// their callers, e.g. the ones of github.com/go-errors/errors
+type callersTracer interface {
+	Callers() []uintptr
+}
+
+// ErrorDescription describes an error reported by the tracer
+type ErrorDescription struct {
+	Type       string
+	Message    string
+	Stacktrace string
+	Causes     []telemetry.ErrorCause
+}
+
+// DescribeError describes err with the innermost meaningful type of
+// its chain and the stack trace recorded closest to its origin, the
+// stack trace is empty if no error of the chain recorded one
+func DescribeError(err error) ErrorDescription {
+	chain := errorChain(err)
+	described := ErrorDescription{
+		Type:    fmt.Sprintf("%T", chain[len(chain)-1]),
+		Message: err.Error(),
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if errType := fmt.Sprintf("%T", chain[i]); !wrapperErrorTypes[errType] {
+			described.Type = errType
+			break
+		}
+	}
+	for i := len(chain) - 1; i >= 0 && described.Stacktrace == ""; i-- {
+		switch traced := chain[i].(type) {
+		case stackTracer:
+			described.Stacktrace = FormatStacktrace(CallersFrames(stackPCs(traced.StackTrace())))
+		case callersTracer:
+			described.Stacktrace = FormatStacktrace(CallersFrames(traced.Callers()))
+		}
+	}
+	if len(chain) > 1 {
+		for _, cause := range chain {
+			described.Causes = append(described.Causes, telemetry.ErrorCause{
+				Type:    fmt.Sprintf("%T", cause),
+				Message: cause.Error(),
+			})
+		}
+	}
+	return described
+}
+
+// RecordError marks the span as failed with err, described
+// as the errors of the invocations
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	SetErrorAttributes(span, DescribeError(err))
+}
+
+// SetErrorAttributes sets the attributes of a failed span
+func SetErrorAttributes(span trace.Span, described ErrorDescription) {
+	span.SetAttributes(
+		attribute.Bool("has_error", true),
+		attribute.String("error_type", described.Type),
+		attribute.String("error_message", described.Message),
+		attribute.String("error_stacktrace", described.Stacktrace),
+	)
+	if len(described.Causes) > 0 {
+		span.SetAttributes(attribute.String("error_causes", causesJSON(described.Causes)))
+	}
+}
+
+// errorChain returns err and the errors it wraps, down to the root cause
+func errorChain(err error) []error {
+	var chain []error
+	for err != nil && len(chain) < maxErrorChainLength {
+		chain = append(chain, err)
+		err = errors.Unwrap(err)
+	}
+	return chain
+}
+
+// stackPCs returns the program counters of a github.com/pkg/errors stack
+func stackPCs(stack errors.StackTrace) []uintptr {
+	pcs := make([]uintptr, len(stack))
+	for i, frame := range stack {
+		pcs[i] = uintptr(frame)
+	}
+	return pcs
+}
+
+// causesJSON encodes the causes of an error for the span attributes
+func causesJSON(causes []telemetry.ErrorCause) string {
+	encoded, err := json.Marshal(causes)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// CallersFrames returns the frames of the program counters
+func CallersFrames(pcs []uintptr) []runtime.Frame {
+	var frames []runtime.Frame
+	if len(pcs) == 0 {
+		return frames
+	}
+	callers := runtime.CallersFrames(pcs)
+	for {
+		frame, more := callers.Next()
+		frames = append(frames, frame)
+		if !more {
+			return frames
+		}
+	}
+}
+
+// FormatStacktrace formats the frames as the stack traces of Go
+func FormatStacktrace(frames []runtime.Frame) string {
+	var builder strings.Builder
+	for i, frame := range frames {
+		if i != 0 {
+			builder.WriteByte('\n')
+		}
+		builder.WriteString(frame.Function)
+		builder.WriteByte('\n')
+		builder.WriteByte('\t')
+		builder.WriteString(frame.File)
+		builder.WriteByte(':')
+		builder.WriteString(strconv.Itoa(frame.Line))
+	}
+	return builder.String()
+}
@@ -0,0 +1,97 @@
+package instrumentation
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type notFoundError struct {
+	key string
+}
+
+func (e *notFoundError) Error() string {
+	return e.key + " not found"
+}
+
+func TestDescribeError(t *testing.T) {
+	notFound := &notFoundError{key: "user"}
+	origin := errors.WithStack(notFound)
+
+	testCases := []struct {
+		name       string
+		err        error
+		errType    string
+		message    string
+		causes     []telemetry.ErrorCause
+		stacktrace string
+	}{
+		{
+			name:    "plain error",
+			err:     stderrors.New("failed"),
+			errType: "*errors.errorString",
+			message: "failed",
+		},
+		{
+			name:    "wrapped plain error",
+			err:     fmt.Errorf("handler: %w", stderrors.New("failed")),
+			errType: "*errors.errorString",
+			message: "handler: failed",
+			causes: []telemetry.ErrorCause{
+				{Type: "*fmt.wrapError", Message: "handler: failed"},
+				{Type: "*errors.errorString", Message: "failed"},
+			},
+		},
+		{
+			name:    "wrapped custom error",
+			err:     fmt.Errorf("handler: %w", notFound),
+			errType: "*instrumentation.notFoundError",
+			message: "handler: user not found",
+			causes: []telemetry.ErrorCause{
+				{Type: "*fmt.wrapError", Message: "handler: user not found"},
+				{Type: "*instrumentation.notFoundError", Message: "user not found"},
+			},
+		},
+		{
+			name:    "pkg errors chain",
+			err:     errors.Wrap(origin, "handler"),
+			errType: "*instrumentation.notFoundError",
+			message: "handler: user not found",
+			causes: []telemetry.ErrorCause{
+				{Type: "*errors.withStack", Message: "handler: user not found"},
+				{Type: "*errors.withMessage", Message: "handler: user not found"},
+				{Type: "*errors.withStack", Message: "user not found"},
+				{Type: "*instrumentation.notFoundError", Message: "user not found"},
+			},
+			stacktrace: "github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation.TestDescribeError\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			described := DescribeError(testCase.err)
+			assert.Equal(t, testCase.errType, described.Type)
+			assert.Equal(t, testCase.message, described.Message)
+			assert.Equal(t, testCase.causes, described.Causes)
+			if testCase.stacktrace == "" {
+				assert.Empty(t, described.Stacktrace)
+			} else {
+				assert.True(t, strings.HasPrefix(described.Stacktrace, testCase.stacktrace))
+			}
+		})
+	}
+}
+
+func TestDescribeErrorOriginStack(t *testing.T) {
+	origin := errors.New("failed")
+	err := errors.Wrap(origin, "handler")
+
+	described := DescribeError(err)
+	assert.Equal(t, FormatStacktrace(CallersFrames(stackPCs(origin.(stackTracer).StackTrace()))), described.Stacktrace)
+	assert.NotEqual(t, FormatStacktrace(CallersFrames(stackPCs(err.(stackTracer).StackTrace()))), described.Stacktrace)
+}
@@ -0,0 +1,95 @@
+// Package instrumentation starts the spans of the tracer, the manual
+// ones and the ones of the libraries traced by its packages, e.g. the
+// database/sql drivers, and describes their errors.
+package instrumentation
+
+import (
+	"context"
+
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultMasker masks the values captured in the invocations
+// whose context doesn't carry a masker
+var defaultMasker, _ = masking.New(nil)
+
+// IsTraced returns true if ctx is the context of a traced invocation
+func IsTraced(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	if _, ok := lumigoctx.FromContext(ctx); !ok {
+		return false
+	}
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// Start starts a span of spanType in the traced invocation of ctx,
+// a child of the span of ctx. The returned context carries the span.
+func Start(ctx context.Context, name string, spanType string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String(telemetry.SpanTypeKey, spanType)}
+	if parentID, ok := lumigoctx.SpanIDFromContext(ctx); ok {
+		attrs = append(attrs, attribute.String(telemetry.ParentIDKey, parentID))
+	}
+	opts = append(opts, trace.WithAttributes(attrs...))
+	provider := trace.SpanFromContext(ctx).TracerProvider()
+	ctx, span := provider.Tracer("lumigo").Start(ctx, name, opts...)
+	return lumigoctx.WithSpanID(ctx, span.SpanContext().SpanID().String()), span
+}
+
+// Masker returns the masker of the traced invocation of ctx
+func Masker(ctx context.Context) *masking.Masker {
+	if lc, ok := lumigoctx.FromContext(ctx); ok && lc.Masker != nil {
+		return lc.Masker
+	}
+	return defaultMasker
+}
+
+// MaxEntrySize returns the max size of the values captured in
+// the traced invocation of ctx, 0 if they aren't truncated
+func MaxEntrySize(ctx context.Context) int {
+	if lc, ok := lumigoctx.FromContext(ctx); ok {
+		return lc.MaxEntrySize
+	}
+	return 0
+}
+
+// Limit truncates the value to the max entry
+// size of the traced invocation of ctx
+func Limit(ctx context.Context, value string) string {
+	if maxEntrySize := MaxEntrySize(ctx); maxEntrySize > 0 && len(value) > maxEntrySize {
+		return value[:maxEntrySize]
+	}
+	return value
+}
+
+// MaskAndLimit masks the secrets of the JSON value and truncates it
+// to the max entry size of the traced invocation of ctx. The values are
+// masked when they are captured, as the spans may be exported to other
+// collectors than Lumigo's.
+func MaskAndLimit(ctx context.Context, value string) string {
+	return Limit(ctx, Masker(ctx).MaskJSON(value))
+}
+
+// Inject injects the trace context of ctx in carrier, with
+// the propagator of the traced invocation
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	if lc, ok := lumigoctx.FromContext(ctx); ok && lc.Propagator != nil {
+		lc.Propagator.Inject(ctx, carrier)
+	}
+}
+
+// Extract returns the trace context propagated in carrier, with
+// the propagator of the traced invocation of ctx
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) trace.SpanContext {
+	lc, ok := lumigoctx.FromContext(ctx)
+	if !ok || lc.Propagator == nil {
+		return trace.SpanContext{}
+	}
+	return trace.SpanContextFromContext(lc.Propagator.Extract(context.Background(), carrier))
+}
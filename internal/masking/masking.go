@@ -0,0 +1,154 @@
+package masking
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaskedValue replaces the values of the secret keys
+const MaskedValue = "****"
+
+// DefaultRegexes match the names of the keys holding secrets
+var DefaultRegexes = []string{
+	".*pass.*",
+	".*key.*",
+	".*secret.*",
+	".*token.*",
+	".*credential.*",
+	".*authorization.*",
+	"x-amz-security-token",
+	"signature",
+}
+
+// jsonPairRegex matches the "key": value pairs of a JSON
+// document which can't be parsed, e.g. a truncated body
+var jsonPairRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,{}\[\]\s"]+)`)
+
+// Masker masks the values of the keys matching its regexes
+type Masker struct {
+	regexes []*regexp.Regexp
+}
+
+// New creates a Masker for the given regexes, the DefaultRegexes
+// are used when none is given. A key is a secret when one of the
+// regexes matches it entirely, ignoring case.
+func New(regexes []string) (*Masker, error) {
+	if len(regexes) == 0 {
+		regexes = DefaultRegexes
+	}
+	m := &Masker{}
+	for _, expr := range regexes {
+		regex, err := regexp.Compile("(?i)^(?:" + expr + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid secret masking regex: %s", expr)
+		}
+		m.regexes = append(m.regexes, regex)
+	}
+	return m, nil
+}
+
+// IsSecret returns true if the value of the key should be masked
+func (m *Masker) IsSecret(key string) bool {
+	if m == nil {
+		return false
+	}
+	for _, regex := range m.regexes {
+		if regex.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskMap returns a copy of the map with the secret values masked
+func (m *Masker) MaskMap(values map[string]string) map[string]string {
+	masked := make(map[string]string, len(values))
+	for key, value := range values {
+		if m.IsSecret(key) {
+			value = MaskedValue
+		}
+		masked[key] = value
+	}
+	return masked
+}
+
+// MaskJSON masks the secret values of a JSON document, in nested
+// objects and in strings holding JSON documents too. A document
+// which can't be parsed, e.g. a truncated one, is masked pair by pair.
+func (m *Masker) MaskJSON(data string) string {
+	if m == nil {
+		return data
+	}
+	if masked, ok := m.maskJSONDocument(data); ok {
+		return masked
+	}
+	return jsonPairRegex.ReplaceAllStringFunc(data, func(pair string) string {
+		groups := jsonPairRegex.FindStringSubmatch(pair)
+		if !m.IsSecret(groups[1]) {
+			return pair
+		}
+		return `"` + groups[1] + `"` + groups[2] + `"` + MaskedValue + `"`
+	})
+}
+
+// maskJSONDocument masks a valid JSON object or array, it
+// returns false if the data isn't one
+func (m *Masker) maskJSONDocument(data string) (string, bool) {
+	trimmed := strings.TrimSpace(data)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return "", false
+	}
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return "", false
+	}
+	value, changed := m.maskValue(value)
+	if !changed {
+		return data, true
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
+
+// maskValue masks a decoded JSON value, it returns
+// true if a secret was masked
+func (m *Masker) maskValue(value interface{}) (interface{}, bool) {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if m.IsSecret(key) {
+				v[key] = MaskedValue
+				changed = true
+				continue
+			}
+			if masked, ok := m.maskValue(item); ok {
+				v[key] = masked
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if masked, ok := m.maskValue(item); ok {
+				v[i] = masked
+				changed = true
+			}
+		}
+	case string:
+		if masked, ok := m.maskJSONDocument(v); ok && masked != v {
+			return masked, true
+		}
+	}
+	return value, changed
+}
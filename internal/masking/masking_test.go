@@ -0,0 +1,92 @@
+package masking
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSecret(t *testing.T) {
+	m, err := New(nil)
+	assert.NoError(t, err)
+
+	for _, key := range []string{"password", "DB_PASSWORD", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "Authorization", "X-Amz-Security-Token", "api_key"} {
+		assert.True(t, m.IsSecret(key), key)
+	}
+	for _, key := range []string{"name", "AWS_REGION", "Content-Type"} {
+		assert.False(t, m.IsSecret(key), key)
+	}
+}
+
+func TestCustomRegexes(t *testing.T) {
+	m, err := New([]string{"user.*"})
+	assert.NoError(t, err)
+	assert.True(t, m.IsSecret("username"))
+	assert.False(t, m.IsSecret("password"))
+
+	_, err = New([]string{"("})
+	assert.Error(t, err)
+}
+
+func TestMaskMap(t *testing.T) {
+	m, err := New(nil)
+	assert.NoError(t, err)
+
+	values := map[string]string{"Authorization": "Bearer abc", "Accept": "*/*"}
+	assert.Equal(t, map[string]string{"Authorization": MaskedValue, "Accept": "*/*"}, m.MaskMap(values))
+	assert.Equal(t, "Bearer abc", values["Authorization"])
+}
+
+func TestMaskJSON(t *testing.T) {
+	m, err := New(nil)
+	assert.NoError(t, err)
+
+	testcases := []struct {
+		testname string
+		input    string
+		expected string
+	}{
+		{
+			testname: "no secrets",
+			input:    `{"name": "lumigo", "count": 12345678901234567890}`,
+			expected: `{"name": "lumigo", "count": 12345678901234567890}`,
+		},
+		{
+			testname: "top level key",
+			input:    `{"name":"lumigo","password":"1234"}`,
+			expected: `{"name":"lumigo","password":"****"}`,
+		},
+		{
+			testname: "nested keys",
+			input:    `{"user":{"name":"lumigo","tokens":[{"api_key":42}]},"items":[{"secret":{"a":1}}]}`,
+			expected: `{"items":[{"secret":"****"}],"user":{"name":"lumigo","tokens":"****"}}`,
+		},
+		{
+			testname: "embedded json",
+			input:    `{"body":"{\"password\":\"1234\",\"url\":\"a&b\"}"}`,
+			expected: `{"body":"{\"password\":\"****\",\"url\":\"a&b\"}"}`,
+		},
+		{
+			testname: "truncated json",
+			input:    `{"name":"lumigo","password": "1234","nested":{"token":12,"secret":"abc`,
+			expected: `{"name":"lumigo","password": "****","nested":{"token":"****","secret":"****"`,
+		},
+		{
+			testname: "not json",
+			input:    `password=1234`,
+			expected: `password=1234`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			assert.Equal(t, tc.expected, m.MaskJSON(tc.input))
+		})
+	}
+}
+
+func TestNilMasker(t *testing.T) {
+	var m *Masker
+	assert.False(t, m.IsSecret("password"))
+	assert.Equal(t, `{"password":"1234"}`, m.MaskJSON(`{"password":"1234"}`))
+	assert.Equal(t, map[string]string{"password": "1234"}, m.MaskMap(map[string]string{"password": "1234"}))
+}
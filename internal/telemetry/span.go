@@ -2,6 +2,7 @@ package telemetry
 
 import (
 	"os"
+	"strings"
 
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
@@ -24,6 +25,100 @@ type SpanInfo struct {
 	TraceID       SpanTraceRoot `json:"traceId"`
 	TracerVersion TracerVersion `json:"tracer"`
 	HttpInfo      *SpanHttpInfo `json:"httpInfo,omitempty"`
+	ManualInfo    *ManualInfo   `json:"manualInfo,omitempty"`
+	DBInfo        *DBInfo       `json:"dbInfo,omitempty"`
+	GRPCInfo      *GRPCInfo     `json:"grpcInfo,omitempty"`
+	RedisInfo     *RedisInfo    `json:"redisInfo,omitempty"`
+	KafkaInfo     *KafkaInfo    `json:"kafkaInfo,omitempty"`
+	LambdaInvoke  *LambdaInvoke `json:"lambdaInvokeInfo,omitempty"`
+	TriggerInfo
+	AwsServiceInfo
+	MessageID  string   `json:"messageId,omitempty"`
+	MessageIDs []string `json:"messageIds,omitempty"`
+}
+
+// TriggerInfo the info about the trigger of the lambda,
+// parsed from the event
+type TriggerInfo struct {
+	TriggeredBy             string `json:"triggeredBy,omitempty"`
+	Api                     string `json:"api,omitempty"`
+	Resource                string `json:"resource,omitempty"`
+	HttpMethod              string `json:"httpMethod,omitempty"`
+	Stage                   string `json:"stage,omitempty"`
+	Arn                     string `json:"arn,omitempty"`
+	RecordsNum              int    `json:"recordsNum,omitempty"`
+	ApproxEventCreationTime int64  `json:"approxEventCreationTime,omitempty"`
+}
+
+// AwsServiceInfo the info about a call to an AWS service
+type AwsServiceInfo struct {
+	AwsServiceName string `json:"awsServiceName,omitempty"`
+	AwsOperation   string `json:"awsOperation,omitempty"`
+	ResourceName   string `json:"resourceName,omitempty"`
+}
+
+// ManualInfo the info about a block of code
+// traced with the manual span API
+type ManualInfo struct {
+	Name       string                 `json:"name"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// DBInfo the info about a database/sql statement
+type DBInfo struct {
+	System       string `json:"system"`
+	Statement    string `json:"statement"`
+	Parameters   string `json:"parameters,omitempty"`
+	RowsAffected *int64 `json:"rowsAffected,omitempty"`
+}
+
+// GRPCInfo the info about a gRPC call, the messages
+// of a stream are its first sent and received ones
+type GRPCInfo struct {
+	Target     string `json:"target"`
+	Service    string `json:"service"`
+	Method     string `json:"method"`
+	StatusCode int64  `json:"statusCode"`
+	Request    string `json:"request,omitempty"`
+	Response   string `json:"response,omitempty"`
+}
+
+// RedisInfo the info about a redis command or pipeline,
+// its operation is the name of the command or pipeline
+type RedisInfo struct {
+	Operation    string         `json:"operation"`
+	Commands     []RedisCommand `json:"commands"`
+	PipelineSize int64          `json:"pipelineSize,omitempty"`
+	ResultSize   int64          `json:"resultSize"`
+}
+
+// RedisCommand a redis command, its arguments
+// other than its keys are JSON encoded
+type RedisCommand struct {
+	Name string   `json:"name"`
+	Keys []string `json:"keys,omitempty"`
+	Args string   `json:"args,omitempty"`
+}
+
+// KafkaInfo the info about the messages sent to or received
+// from Kafka, its operation is send or receive
+type KafkaInfo struct {
+	Operation    string `json:"operation"`
+	Topic        string `json:"topic"`
+	Partition    *int64 `json:"partition,omitempty"`
+	Offset       *int64 `json:"offset,omitempty"`
+	MessageCount int64  `json:"messageCount"`
+}
+
+// LambdaInvoke the info about the invocation of
+// a lambda through the Invoke API of Lambda
+type LambdaInvoke struct {
+	FunctionName   string `json:"functionName"`
+	Qualifier      string `json:"qualifier,omitempty"`
+	InvocationType string `json:"invocationType"`
+	RequestID      string `json:"requestId,omitempty"`
+	FunctionError  string `json:"functionError,omitempty"`
+	LogResult      string `json:"logResult,omitempty"`
 }
 
 // SpanHttpInfo extra info for HTTP reuquests
@@ -49,6 +144,16 @@ type SpanError struct {
 	Type       string `json:"type"`
 	Message    string `json:"message"`
 	Stacktrace string `json:"stacktrace"`
+
+	// Causes is the chain of the wrapped errors,
+	// from the returned error to the root cause
+	Causes []ErrorCause `json:"causes,omitempty"`
+}
+
+// ErrorCause an error of the chain of causes
+type ErrorCause struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
 }
 
 func (s SpanError) IsEmpty() bool {
@@ -109,6 +214,9 @@ type Span struct {
 	// LambdaContainerID the id of the lambda container
 	LambdaContainerID string `json:"lambda_container_id"`
 
+	// InvocationNumber the number of the invocation in the lambda container
+	InvocationNumber int64 `json:"invocationNumber,omitempty"`
+
 	// SpanInfo extra info for span
 	SpanInfo SpanInfo `json:"info"`
 
@@ -123,8 +231,121 @@ type Span struct {
 
 	// SpanError error details
 	SpanError *SpanError `json:"error"`
+
+	// ExecutionTags the tags of the invocation
+	ExecutionTags []ExecutionTag `json:"executionTags,omitempty"`
+
+	// ReportedIssues the non fatal errors and warnings
+	// reported during the invocation
+	ReportedIssues []ReportedIssue `json:"reportedIssues,omitempty"`
+
+	// DroppedSpansReasons counts the spans of the invocation
+	// which weren't sent, by the reason they were dropped
+	DroppedSpansReasons map[string]DroppedSpansReason `json:"droppedSpansReasons,omitempty"`
+}
+
+// ExecutionTag a tag of the invocation
+type ExecutionTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ReportedIssue a non fatal error or warning of the invocation
+type ReportedIssue struct {
+	Level      string `json:"level"`
+	Type       string `json:"type,omitempty"`
+	Message    string `json:"message"`
+	Stacktrace string `json:"stacktrace,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// DroppedSpansReason the number of spans dropped for a reason
+type DroppedSpansReason struct {
+	Drops int `json:"drops"`
 }
 
+const (
+	// SpanTypeKey is the attribute marking the spans started
+	// by the manual span API with ManualSpanType, and the spans
+	// of the instrumented libraries with their type
+	SpanTypeKey    = "lumigo.span_type"
+	ManualSpanType = "manual"
+	DBSpanType     = "db"
+	GRPCSpanType   = "grpc"
+	RedisSpanType  = "redis"
+	KafkaSpanType  = "kafka"
+
+	// DBParametersKey is the attribute of the JSON encoded
+	// parameters of a statement, by name or by ordinal
+	DBParametersKey = "db.parameters"
+	// DBRowsAffectedKey is the attribute of the
+	// number of rows affected by a statement
+	DBRowsAffectedKey = "db.rows_affected"
+
+	// GRPCTargetKey, GRPCRequestKey and GRPCResponseKey are
+	// the attributes of the target and of the JSON encoded
+	// messages of a gRPC call
+	GRPCTargetKey   = "rpc.grpc.target"
+	GRPCRequestKey  = "rpc.grpc.request"
+	GRPCResponseKey = "rpc.grpc.response"
+
+	// RedisCommandsKey is the attribute of the JSON encoded commands
+	// of a redis span, RedisPipelineSizeKey and RedisResultSizeKey
+	// the attributes of the number of commands and of their results
+	RedisCommandsKey     = "db.redis.commands"
+	RedisPipelineSizeKey = "db.redis.pipeline_size"
+	RedisResultSizeKey   = "db.redis.result_size"
+
+	// KafkaOffsetKey, KafkaMessageCountKey and KafkaMessageIDsKey
+	// are the attributes of the offset of a received message, the
+	// number of messages of a kafka span and their Lumigo IDs
+	KafkaOffsetKey       = "messaging.kafka.offset"
+	KafkaMessageCountKey = "messaging.kafka.message_count"
+	KafkaMessageIDsKey   = "messaging.kafka.message_ids"
+
+	// KafkaMessageIDHeader is the header of the Lumigo ID
+	// of a message sent to Kafka, it links the invocations
+	// triggered by the message to the span which sent it
+	KafkaMessageIDHeader = "lumigoMessageId"
+
+	// the attributes of an http span invoking a lambda, its
+	// name is the semconv faas.invoked_name attribute
+	LambdaQualifierKey      = "aws.lambda.qualifier"
+	LambdaInvocationTypeKey = "aws.lambda.invocation_type"
+	LambdaRequestIDKey      = "aws.lambda.request_id"
+	LambdaFunctionErrorKey  = "aws.lambda.function_error"
+	LambdaLogResultKey      = "aws.lambda.log_result"
+
+	// TriggerKey and TriggerMessageIDsKey are the attributes of the
+	// JSON encoded trigger of a function span and of the IDs of the
+	// messages which triggered it, parsed from the unmasked event
+	TriggerKey           = "lumigo.trigger"
+	TriggerMessageIDsKey = "lumigo.trigger.message_ids"
+
+	// AwsMessageIDsKey is the attribute of the IDs of the messages
+	// written by a call to an AWS service, read from the request
+	// before its secrets are masked
+	AwsMessageIDsKey = "aws.message_ids"
+
+	// AwsResourceNameKey is the attribute of the name of the resource
+	// of a call to an AWS service, read from the request before its
+	// secrets are masked
+	AwsResourceNameKey = "aws.resource_name"
+
+	// SpanIDKey is the attribute of the ID of an http span,
+	// generated when the span starts
+	SpanIDKey = "lumigo.span_id"
+
+	// ParentIDKey is the attribute of the ID of the manual
+	// span a span was started in, or of the remote span
+	// which propagated its trace context to the invocation
+	ParentIDKey = "lumigo.parent_id"
+
+	// AttributePrefix prefixes the attributes
+	// set by the user on a manual span
+	AttributePrefix = "lumigo.attribute."
+)
+
 func IsStartSpan(span sdktrace.ReadOnlySpan) bool {
 	return span.Name() == os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
 }
@@ -132,3 +353,46 @@ func IsStartSpan(span sdktrace.ReadOnlySpan) bool {
 func IsEndSpan(span sdktrace.ReadOnlySpan) bool {
 	return span.Name() == "LumigoParentSpan"
 }
+
+func IsManualSpan(span sdktrace.ReadOnlySpan) bool {
+	return spanType(span) == ManualSpanType
+}
+
+func IsDBSpan(span sdktrace.ReadOnlySpan) bool {
+	return spanType(span) == DBSpanType
+}
+
+func IsGRPCSpan(span sdktrace.ReadOnlySpan) bool {
+	return spanType(span) == GRPCSpanType
+}
+
+// RedisHashCommands are the redis commands whose arguments,
+// after the key, are pairs of a hash field and its value
+var RedisHashCommands = map[string]bool{
+	"hmset": true, "hset": true, "hsetnx": true,
+}
+
+func IsRedisSpan(span sdktrace.ReadOnlySpan) bool {
+	return spanType(span) == RedisSpanType
+}
+
+func IsKafkaSpan(span sdktrace.ReadOnlySpan) bool {
+	return spanType(span) == KafkaSpanType
+}
+
+// IsKafkaEventSource returns true for the event sources of
+// the events of MSK and of self managed Kafka clusters
+func IsKafkaEventSource(eventSource string) bool {
+	eventSource = strings.ToLower(eventSource)
+	return eventSource == "aws:kafka" || eventSource == "selfmanagedkafka"
+}
+
+// spanType returns the SpanTypeKey attribute of the span
+func spanType(span sdktrace.ReadOnlySpan) string {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == SpanTypeKey {
+			return kv.Value.AsString()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+// Package testenv sets the environment of a lambda in the tests
+// of the tracer packages.
+package testenv
+
+import (
+	"os"
+	"testing"
+)
+
+// AmznTraceID is the X-Ray trace header of the test invocations
+const AmznTraceID = "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+
+// lambdaEnv is the environment of the test lambda
+var lambdaEnv = map[string]string{
+	"AWS_LAMBDA_FUNCTION_NAME": "testFunction",
+	"AWS_REGION":               "us-east-1",
+	"_X_AMZN_TRACE_ID":         AmznTraceID,
+}
+
+// SetLambdaEnv sets the environment of the test lambda, the
+// variables are unset when the test and its subtests end
+func SetLambdaEnv(t testing.TB) {
+	for key, value := range lambdaEnv {
+		_ = os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		for key := range lambdaEnv {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
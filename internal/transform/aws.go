@@ -0,0 +1,291 @@
+package transform
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// awsService describes where the resource name and the
+// message IDs of the calls to an AWS service are found
+type awsService struct {
+	name          string
+	resourceKeys  []string
+	messageIDKeys []string
+}
+
+// awsServices are the AWS services recognised by
+// the prefix of their endpoint host
+var awsServices = map[string]awsService{
+	"dynamodb":       {name: "dynamodb", resourceKeys: []string{"TableName"}},
+	"sqs":            {name: "sqs", resourceKeys: []string{"QueueUrl"}, messageIDKeys: []string{"MessageId"}},
+	"sns":            {name: "sns", resourceKeys: []string{"TopicArn", "TargetArn"}, messageIDKeys: []string{"MessageId"}},
+	"kinesis":        {name: "kinesis", resourceKeys: []string{"StreamName", "StreamARN"}, messageIDKeys: []string{"SequenceNumber"}},
+	"lambda":         {name: "lambda"},
+	"s3":             {name: "s3"},
+	"ssm":            {name: "ssm", resourceKeys: []string{"Name"}},
+	"secretsmanager": {name: "secretsmanager", resourceKeys: []string{"SecretId"}},
+	"events":         {name: "eventbridge", resourceKeys: []string{"EventBusName"}, messageIDKeys: []string{"EventId"}},
+	"states":         {name: "stepfunctions", resourceKeys: []string{"stateMachineArn"}, messageIDKeys: []string{"executionArn"}},
+}
+
+// s3Operations are the operations of the S3 methods,
+// on a bucket and on an object
+var s3Operations = map[string][2]string{
+	"GET":    {"ListObjects", "GetObject"},
+	"PUT":    {"CreateBucket", "PutObject"},
+	"DELETE": {"DeleteBucket", "DeleteObject"},
+	"HEAD":   {"HeadBucket", "HeadObject"},
+	"POST":   {"DeleteObjects", "PostObject"},
+}
+
+// lambdaInvokePathRegex matches the path of the Invoke API of Lambda
+var lambdaInvokePathRegex = regexp.MustCompile(`^/[^/]+/functions/([^/]+)/invocations$`)
+
+// LambdaInvokeFunction returns the function name, or ARN, of
+// an escaped path of the Invoke API of Lambda
+func LambdaInvokeFunction(path string) (string, bool) {
+	match := lambdaInvokePathRegex.FindStringSubmatch(path)
+	if match == nil {
+		return "", false
+	}
+	functionName, err := url.PathUnescape(match[1])
+	if err != nil {
+		return "", false
+	}
+	return functionName, true
+}
+
+// valueRegex matches the values of a key in JSON and XML bodies
+type valueRegex struct {
+	json *regexp.Regexp
+	xml  *regexp.Regexp
+}
+
+// valueRegexes are the regexes of the resource and message
+// ID keys of awsServices, compiled once
+var valueRegexes = compileValueRegexes()
+
+func compileValueRegexes() map[string]valueRegex {
+	regexes := make(map[string]valueRegex)
+	for _, service := range awsServices {
+		for _, key := range append(append([]string{}, service.resourceKeys...), service.messageIDKeys...) {
+			quoted := regexp.QuoteMeta(key)
+			regexes[key] = valueRegex{
+				json: regexp.MustCompile(`"` + quoted + `"\s*:\s*("(?:[^"\\]|\\.)*")`),
+				xml:  regexp.MustCompile(`<` + quoted + `>([^<]*)</` + quoted + `>`),
+			}
+		}
+	}
+	return regexes
+}
+
+// AwsRequestAttributes returns the attributes of a call to an AWS
+// service read from its request body before it is masked: the name of
+// the resource and the hashes of the items written to DynamoDB, the
+// masking may hide their keys
+func AwsRequestAttributes(host string, target string, body string) []attribute.KeyValue {
+	prefix, _, ok := awsHostPrefix(host)
+	if !ok {
+		return nil
+	}
+	var attrs []attribute.KeyValue
+	for _, key := range awsServices[prefix].resourceKeys {
+		if values := findValues(body, key); len(values) > 0 {
+			attrs = append(attrs, attribute.String(telemetry.AwsResourceNameKey, values[0]))
+			break
+		}
+	}
+	if prefix != "dynamodb" {
+		return attrs
+	}
+	operation := awsOperation(map[string]string{"X-Amz-Target": target}, body)
+	if messageIDs := dynamoDBMessageIDs(operation, body); len(messageIDs) > 0 {
+		attrs = append(attrs, attribute.StringSlice(telemetry.AwsMessageIDsKey, messageIDs))
+	}
+	return attrs
+}
+
+// parseAwsService returns the info about the call to an AWS service
+// made by the http span and the IDs of the messages it sent, a call
+// to another endpoint has no info
+func parseAwsService(httpInfo *telemetry.SpanHttpInfo) (telemetry.AwsServiceInfo, []string) {
+	if httpInfo == nil {
+		return telemetry.AwsServiceInfo{}, nil
+	}
+	prefix, bucket, ok := awsHostPrefix(httpInfo.Host)
+	if !ok {
+		return telemetry.AwsServiceInfo{}, nil
+	}
+	service, ok := awsServices[prefix]
+	if !ok {
+		return telemetry.AwsServiceInfo{}, nil
+	}
+	path := ""
+	if httpInfo.Request.URI != nil {
+		path = strings.TrimPrefix(*httpInfo.Request.URI, httpInfo.Host)
+	}
+	method := ""
+	if httpInfo.Request.Method != nil {
+		method = *httpInfo.Request.Method
+	}
+	requestHeaders := parseHeaders(httpInfo.Request.Headers)
+	responseHeaders := parseHeaders(httpInfo.Response.Headers)
+
+	info := telemetry.AwsServiceInfo{AwsServiceName: service.name}
+	switch service.name {
+	case "s3":
+		info.AwsOperation, info.ResourceName = s3Operation(method, path, bucket)
+		return info, nil
+	case "lambda":
+		if functionName, ok := LambdaInvokeFunction(path); ok {
+			info.AwsOperation = "Invoke"
+			info.ResourceName = functionName
+		}
+		return info, nonEmpty(headerValue(responseHeaders, "X-Amzn-Requestid"))
+	}
+
+	info.AwsOperation = awsOperation(requestHeaders, httpInfo.Request.Body)
+	// the name read by AwsRequestAttributes takes precedence,
+	// the masked body may hide it
+	for _, key := range service.resourceKeys {
+		if values := findValues(httpInfo.Request.Body, key); len(values) > 0 {
+			info.ResourceName = values[0]
+			break
+		}
+	}
+	if service.name == "dynamodb" {
+		// the IDs are read by AwsRequestAttributes
+		return info, nil
+	}
+	var messageIDs []string
+	for _, key := range service.messageIDKeys {
+		messageIDs = append(messageIDs, findValues(httpInfo.Response.Body, key)...)
+	}
+	return info, messageIDs
+}
+
+// awsHostPrefix returns the service prefix of an AWS endpoint host,
+// and the bucket of a virtual hosted S3 endpoint
+func awsHostPrefix(host string) (string, string, bool) {
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, ".amazonaws.com") && !strings.HasSuffix(host, ".amazonaws.com.cn") {
+		return "", "", false
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if label == "s3" || strings.HasPrefix(label, "s3-") {
+			return "s3", strings.Join(labels[:i], "."), true
+		}
+	}
+	return labels[0], "", true
+}
+
+// awsOperation returns the operation of a call to an AWS service,
+// from the X-Amz-Target header of the JSON APIs or the Action of
+// the query APIs
+func awsOperation(headers map[string]string, body string) string {
+	if target := headerValue(headers, "X-Amz-Target"); target != "" {
+		return target[strings.LastIndex(target, ".")+1:]
+	}
+	if values, err := url.ParseQuery(body); err == nil {
+		return values.Get("Action")
+	}
+	return ""
+}
+
+// s3Operation returns the operation and the bucket of a call to S3,
+// from the method and the path of a virtual hosted or a path style URL
+func s3Operation(method string, path string, bucket string) (string, string) {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	key := path
+	if bucket == "" {
+		parts := strings.SplitN(path, "/", 2)
+		bucket = parts[0]
+		key = ""
+		if len(parts) > 1 {
+			key = parts[1]
+		}
+	}
+	operation, ok := s3Operations[strings.ToUpper(method)]
+	if !ok {
+		return "", bucket
+	}
+	if key == "" {
+		return operation[0], bucket
+	}
+	return operation[1], bucket
+}
+
+// dynamoDBMessageIDs returns the hashes of the items written by a call
+// to DynamoDB, they match the message IDs of the stream records
+func dynamoDBMessageIDs(operation string, body string) []string {
+	var request struct {
+		Item map[string]interface{} `json:"Item"`
+		Key  map[string]interface{} `json:"Key"`
+	}
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		return nil
+	}
+	item := request.Key
+	if operation == "PutItem" {
+		item = request.Item
+	}
+	if len(item) == 0 {
+		return nil
+	}
+	messageID, err := itemHash(item)
+	if err != nil {
+		return nil
+	}
+	return []string{messageID}
+}
+
+// findValues returns the values of the key in a JSON, XML or form
+// body, the body may be truncated. The key is one of valueRegexes.
+func findValues(body string, key string) []string {
+	var values []string
+	regex := valueRegexes[key]
+	if regex.json == nil {
+		return nil
+	}
+	for _, match := range regex.json.FindAllStringSubmatch(body, -1) {
+		var value string
+		if err := json.Unmarshal([]byte(match[1]), &value); err == nil {
+			values = append(values, value)
+		}
+	}
+	for _, match := range regex.xml.FindAllStringSubmatch(body, -1) {
+		values = append(values, match[1])
+	}
+	if len(values) == 0 && !strings.ContainsAny(body, "{<") {
+		if form, err := url.ParseQuery(body); err == nil && form.Get(key) != "" {
+			values = append(values, form.Get(key))
+		}
+	}
+	return values
+}
+
+// parseHeaders parses the headers captured as a JSON map
+func parseHeaders(headers string) map[string]string {
+	parsed := make(map[string]string)
+	_ = json.Unmarshal([]byte(headers), &parsed)
+	return parsed
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
@@ -0,0 +1,223 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func newAwsHTTPInfo(host string, method string, path string, headers string, body string, respHeaders string, respBody string) *telemetry.SpanHttpInfo {
+	return &telemetry.SpanHttpInfo{
+		Host: host,
+		Request: telemetry.SpanHttpCommon{
+			URI:     aws.String(host + path),
+			Method:  aws.String(method),
+			Headers: headers,
+			Body:    body,
+		},
+		Response: telemetry.SpanHttpCommon{
+			Headers: respHeaders,
+			Body:    respBody,
+		},
+	}
+}
+
+func TestParseAwsService(t *testing.T) {
+	testcases := []struct {
+		testname   string
+		httpInfo   *telemetry.SpanHttpInfo
+		expect     telemetry.AwsServiceInfo
+		messageIDs []string
+	}{
+		{
+			testname: "not aws",
+			httpInfo: newAwsHTTPInfo("example.com", "GET", "/", "{}", "", "{}", ""),
+			expect:   telemetry.AwsServiceInfo{},
+		},
+		{
+			testname: "dynamodb put item",
+			httpInfo: newAwsHTTPInfo("dynamodb.us-east-1.amazonaws.com", "POST", "/",
+				`{"X-Amz-Target":"DynamoDB_20120810.PutItem"}`,
+				`{"TableName":"users","Item":{"id":{"S":"1"}}}`, "{}", "{}"),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "dynamodb",
+				AwsOperation:   "PutItem",
+				ResourceName:   "users",
+			},
+		},
+		{
+			testname: "dynamodb truncated query",
+			httpInfo: newAwsHTTPInfo("dynamodb.us-east-1.amazonaws.com", "POST", "/",
+				`{"X-Amz-Target":"DynamoDB_20120810.Query"}`,
+				`{"TableName":"users","KeyConditionExpression":"id = :i`, "{}", ""),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "dynamodb",
+				AwsOperation:   "Query",
+				ResourceName:   "users",
+			},
+		},
+		{
+			testname: "sqs query protocol",
+			httpInfo: newAwsHTTPInfo("sqs.us-east-1.amazonaws.com", "POST", "/", "{}",
+				"Action=SendMessage&MessageBody=hello&QueueUrl=https%3A%2F%2Fsqs.us-east-1.amazonaws.com%2F123%2Fqueue&Version=2012-11-05",
+				"{}", "<SendMessageResponse><SendMessageResult><MessageId>message-1</MessageId></SendMessageResult></SendMessageResponse>"),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "sqs",
+				AwsOperation:   "SendMessage",
+				ResourceName:   "https://sqs.us-east-1.amazonaws.com/123/queue",
+			},
+			messageIDs: []string{"message-1"},
+		},
+		{
+			testname: "sqs json protocol batch",
+			httpInfo: newAwsHTTPInfo("sqs.us-east-1.amazonaws.com", "POST", "/",
+				`{"X-Amz-Target":"AmazonSQS.SendMessageBatch"}`,
+				`{"QueueUrl":"https://sqs.us-east-1.amazonaws.com/123/queue","Entries":[]}`,
+				"{}", `{"Successful":[{"Id":"1","MessageId":"message-1"},{"Id":"2","MessageId":"message-2"}]}`),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "sqs",
+				AwsOperation:   "SendMessageBatch",
+				ResourceName:   "https://sqs.us-east-1.amazonaws.com/123/queue",
+			},
+			messageIDs: []string{"message-1", "message-2"},
+		},
+		{
+			testname: "sns publish",
+			httpInfo: newAwsHTTPInfo("sns.us-east-1.amazonaws.com", "POST", "/", "{}",
+				"Action=Publish&Message=hello&TopicArn=arn%3Aaws%3Asns%3Aus-east-1%3A123%3Atopic",
+				"{}", "<PublishResponse><PublishResult><MessageId>message-1</MessageId></PublishResult></PublishResponse>"),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "sns",
+				AwsOperation:   "Publish",
+				ResourceName:   "arn:aws:sns:us-east-1:123:topic",
+			},
+			messageIDs: []string{"message-1"},
+		},
+		{
+			testname: "kinesis put record",
+			httpInfo: newAwsHTTPInfo("kinesis.us-east-1.amazonaws.com", "POST", "/",
+				`{"X-Amz-Target":"Kinesis_20131202.PutRecord"}`,
+				`{"StreamName":"stream","Data":"aGVsbG8=","PartitionKey":"1"}`,
+				"{}", `{"SequenceNumber":"4950","ShardId":"shardId-0"}`),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "kinesis",
+				AwsOperation:   "PutRecord",
+				ResourceName:   "stream",
+			},
+			messageIDs: []string{"4950"},
+		},
+		{
+			testname: "lambda invoke",
+			httpInfo: newAwsHTTPInfo("lambda.us-east-1.amazonaws.com", "POST", "/2015-03-31/functions/my-function/invocations",
+				"{}", "{}", `{"X-Amzn-Requestid":"request-id"}`, "{}"),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "lambda",
+				AwsOperation:   "Invoke",
+				ResourceName:   "my-function",
+			},
+			messageIDs: []string{"request-id"},
+		},
+		{
+			testname: "s3 virtual hosted put object",
+			httpInfo: newAwsHTTPInfo("bucket.s3.us-east-1.amazonaws.com", "PUT", "/dir/key.json", "{}", "{}", "{}", ""),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "s3",
+				AwsOperation:   "PutObject",
+				ResourceName:   "bucket",
+			},
+		},
+		{
+			testname: "s3 path style list objects",
+			httpInfo: newAwsHTTPInfo("s3.us-east-1.amazonaws.com", "GET", "/bucket", "{}", "", "{}", ""),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "s3",
+				AwsOperation:   "ListObjects",
+				ResourceName:   "bucket",
+			},
+		},
+		{
+			testname: "ssm get parameter",
+			httpInfo: newAwsHTTPInfo("ssm.us-east-1.amazonaws.com", "POST", "/",
+				`{"X-Amz-Target":"AmazonSSM.GetParameter"}`, `{"Name":"/app/db","WithDecryption":true}`, "{}", "{}"),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "ssm",
+				AwsOperation:   "GetParameter",
+				ResourceName:   "/app/db",
+			},
+		},
+		{
+			testname: "secrets manager get secret value",
+			httpInfo: newAwsHTTPInfo("secretsmanager.us-east-1.amazonaws.com", "POST", "/",
+				`{"X-Amz-Target":"secretsmanager.GetSecretValue"}`, `{"SecretId":"db-password"}`, "{}", `{"SecretString":"****"}`),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "secretsmanager",
+				AwsOperation:   "GetSecretValue",
+				ResourceName:   "db-password",
+			},
+		},
+		{
+			testname: "eventbridge put events",
+			httpInfo: newAwsHTTPInfo("events.us-east-1.amazonaws.com", "POST", "/",
+				`{"X-Amz-Target":"AWSEvents.PutEvents"}`, `{"Entries":[{"EventBusName":"orders","Detail":"{}"}]}`,
+				"{}", `{"Entries":[{"EventId":"event-1"}],"FailedEntryCount":0}`),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "eventbridge",
+				AwsOperation:   "PutEvents",
+				ResourceName:   "orders",
+			},
+			messageIDs: []string{"event-1"},
+		},
+		{
+			testname: "step functions start execution",
+			httpInfo: newAwsHTTPInfo("states.us-east-1.amazonaws.com:443", "POST", "/",
+				`{"X-Amz-Target":"AWSStepFunctions.StartExecution"}`,
+				`{"stateMachineArn":"arn:aws:states:us-east-1:123:stateMachine:flow","input":"{}"}`,
+				"{}", `{"executionArn":"arn:aws:states:us-east-1:123:execution:flow:1","startDate":1.6E9}`),
+			expect: telemetry.AwsServiceInfo{
+				AwsServiceName: "stepfunctions",
+				AwsOperation:   "StartExecution",
+				ResourceName:   "arn:aws:states:us-east-1:123:stateMachine:flow",
+			},
+			messageIDs: []string{"arn:aws:states:us-east-1:123:execution:flow:1"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			info, messageIDs := parseAwsService(tc.httpInfo)
+			assert.Equal(t, tc.expect, info)
+			assert.Equal(t, tc.messageIDs, messageIDs)
+		})
+	}
+}
+
+func TestAwsRequestAttributes(t *testing.T) {
+	attrs := AwsRequestAttributes("dynamodb.us-east-1.amazonaws.com", "DynamoDB_20120810.PutItem", `{"TableName":"users","Item":{"id":{"S":"1"}}}`)
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String(telemetry.AwsResourceNameKey, "users"),
+		attribute.StringSlice(telemetry.AwsMessageIDsKey, []string{"63fdc94765b4cce96704e7f2a10ccf31"}),
+	}, attrs)
+
+	attrs = AwsRequestAttributes("dynamodb.us-east-1.amazonaws.com", "DynamoDB_20120810.DeleteItem", `{"TableName":"users","Key":{"id":{"S":"1"}}}`)
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String(telemetry.AwsResourceNameKey, "users"),
+		attribute.StringSlice(telemetry.AwsMessageIDsKey, []string{"63fdc94765b4cce96704e7f2a10ccf31"}),
+	}, attrs)
+
+	attrs = AwsRequestAttributes("dynamodb.us-east-1.amazonaws.com", "DynamoDB_20120810.Query", `{"TableName":"users"}`)
+	assert.Equal(t, []attribute.KeyValue{attribute.String(telemetry.AwsResourceNameKey, "users")}, attrs)
+
+	attrs = AwsRequestAttributes("secretsmanager.us-east-1.amazonaws.com", "secretsmanager.GetSecretValue", `{"SecretId":"db-password"}`)
+	assert.Equal(t, []attribute.KeyValue{attribute.String(telemetry.AwsResourceNameKey, "db-password")}, attrs)
+
+	assert.Empty(t, AwsRequestAttributes("sqs.us-east-1.amazonaws.com", "", `{"Key":{"id":{"S":"1"}}}`))
+	assert.Empty(t, AwsRequestAttributes("example.com", "", `{"TableName":"users"}`))
+}
+
+func TestFindValuesUnknownKey(t *testing.T) {
+	assert.Equal(t, []string{"users"}, findValues(`{"TableName":"users"}`, "TableName"))
+	assert.Empty(t, findValues(`{"Unknown":"users"}`, "Unknown"))
+}
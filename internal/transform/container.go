@@ -0,0 +1,20 @@
+package transform
+
+import (
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// containerID identifies the lambda container, a
+// container runs a single process of the tracer
+var containerID = uuid.New().String()
+
+// invocationsCount counts the invocations of the container
+var invocationsCount int64
+
+// NextInvocationNumber counts a new invocation of the
+// container and returns its number, starting from 1
+func NextInvocationNumber() int64 {
+	return atomic.AddInt64(&invocationsCount, 1)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/google/uuid"
 	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
 	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -26,14 +27,16 @@ type mapper struct {
 	span         sdktrace.ReadOnlySpan
 	logger       logrus.FieldLogger
 	maxEntrySize int
+	masker       *masking.Masker
 }
 
-func NewMapper(ctx context.Context, span sdktrace.ReadOnlySpan, logger logrus.FieldLogger, maxEntrySize int) *mapper {
+func NewMapper(ctx context.Context, span sdktrace.ReadOnlySpan, logger logrus.FieldLogger, maxEntrySize int, masker *masking.Masker) *mapper {
 	return &mapper{
 		ctx:          ctx,
 		span:         span,
 		logger:       logger,
 		maxEntrySize: maxEntrySize,
+		masker:       masker,
 	}
 }
 
@@ -62,7 +65,8 @@ func (m *mapper) Transform(invocationStartedTimestamp int64) telemetry.Span {
 	}
 
 	startTime := unixMilli(m.span.StartTime())
-	if telemetry.IsEndSpan(m.span) {
+	if telemetry.IsEndSpan(m.span) && invocationStartedTimestamp != 0 {
+		// the start span is missing when the invocation timed out
 		startTime = invocationStartedTimestamp
 	}
 	lumigoSpan := telemetry.Span{
@@ -87,9 +91,48 @@ func (m *mapper) Transform(invocationStartedTimestamp int64) telemetry.Span {
 	}
 	lambdaName := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
 	spanType := "function"
-	if m.span.Name() != lambdaName && m.span.Name() != "LumigoParentSpan" {
+	if telemetry.IsManualSpan(m.span) {
+		spanType = "manual"
+		lumigoSpan.SpanInfo.ManualInfo = m.getManualInfo(attrs)
+		lumigoSpan.SpanError = m.getSpanError(attrs)
+	} else if telemetry.IsDBSpan(m.span) {
+		spanType = "db"
+		lumigoSpan.SpanInfo.DBInfo = m.getDBInfo(attrs)
+		lumigoSpan.SpanError = m.getSpanError(attrs)
+	} else if telemetry.IsGRPCSpan(m.span) {
+		spanType = "grpc"
+		lumigoSpan.SpanInfo.GRPCInfo = m.getGRPCInfo(attrs)
+		lumigoSpan.SpanError = m.getSpanError(attrs)
+	} else if telemetry.IsRedisSpan(m.span) {
+		spanType = "redis"
+		lumigoSpan.SpanInfo.RedisInfo = m.getRedisInfo(attrs)
+		lumigoSpan.SpanError = m.getSpanError(attrs)
+	} else if telemetry.IsKafkaSpan(m.span) {
+		spanType = "kafka"
+		lumigoSpan.SpanInfo.KafkaInfo = m.getKafkaInfo(attrs)
+		lumigoSpan.SpanError = m.getSpanError(attrs)
+		if messageIDs, ok := attrs[telemetry.KafkaMessageIDsKey].([]string); ok {
+			setMessageIDs(&lumigoSpan.SpanInfo, messageIDs)
+		}
+	} else if m.span.Name() != lambdaName && m.span.Name() != "LumigoParentSpan" {
 		spanType = "http"
 		lumigoSpan.SpanInfo.HttpInfo = m.getHTTPInfo(attrs)
+		awsServiceInfo, messageIDs := parseAwsService(lumigoSpan.SpanInfo.HttpInfo)
+		if name, ok := attrs[telemetry.AwsResourceNameKey].(string); ok && awsServiceInfo.AwsServiceName != "" {
+			awsServiceInfo.ResourceName = name
+		}
+		if ids, ok := attrs[telemetry.AwsMessageIDsKey].([]string); ok {
+			messageIDs = ids
+		}
+		if invoke := m.getLambdaInvoke(attrs); invoke != nil {
+			// the invocations are detected by the transport,
+			// whichever the endpoint of Lambda
+			lumigoSpan.SpanInfo.LambdaInvoke = invoke
+			awsServiceInfo = telemetry.AwsServiceInfo{AwsServiceName: "lambda", AwsOperation: "Invoke", ResourceName: invoke.FunctionName}
+			messageIDs = nonEmpty(invoke.RequestID)
+		}
+		lumigoSpan.SpanInfo.AwsServiceInfo = awsServiceInfo
+		setMessageIDs(&lumigoSpan.SpanInfo, messageIDs)
 	} else {
 		lumigoSpan.LambdaName = lambdaName
 		lumigoSpan.MemoryAllocated = os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE")
@@ -100,6 +143,15 @@ func (m *mapper) Transform(invocationStartedTimestamp int64) telemetry.Span {
 			m.logger.Error("unable to fetch event")
 		}
 
+		if trigger, ok := attrs[telemetry.TriggerKey]; ok {
+			if err := json.Unmarshal([]byte(fmt.Sprint(trigger)), &lumigoSpan.SpanInfo.TriggerInfo); err != nil {
+				m.logger.WithError(err).Error("unable to decode lambda trigger")
+			}
+		}
+		if messageIDs, ok := attrs[telemetry.TriggerMessageIDsKey].([]string); ok {
+			setMessageIDs(&lumigoSpan.SpanInfo, messageIDs)
+		}
+
 		isWarmStart := os.Getenv("IS_WARM_START")
 		if isWarmStart == "" && !isProvisionConcurrencyInitialization() {
 			lumigoSpan.LambdaReadiness = "cold"
@@ -113,15 +165,25 @@ func (m *mapper) Transform(invocationStartedTimestamp int64) telemetry.Span {
 	}
 	lambdaCtx, lambdaOk := lambdacontext.FromContext(m.ctx)
 	if lambdaOk {
-		containerID, _ := uuid.NewUUID()
-		lumigoSpan.LambdaContainerID = containerID.String()
-
-		if spanType == "http" {
-			spanID, _ := uuid.NewUUID()
-			lumigoSpan.ID = spanID.String()
-			lumigoSpan.ParentID = lambdaCtx.AwsRequestID
-		} else {
+		lumigoSpan.LambdaContainerID = containerID
+
+		switch spanType {
+		case "http":
+			if spanID, ok := attrs[telemetry.SpanIDKey]; ok {
+				lumigoSpan.ID = fmt.Sprint(spanID)
+			} else {
+				spanID, _ := uuid.NewUUID()
+				lumigoSpan.ID = spanID.String()
+			}
+			lumigoSpan.ParentID = m.getParentID(attrs, lambdaCtx)
+		case "manual", "db", "grpc", "redis", "kafka":
+			lumigoSpan.ID = m.span.SpanContext().SpanID().String()
+			lumigoSpan.ParentID = m.getParentID(attrs, lambdaCtx)
+		default:
 			lumigoSpan.ID = lambdaCtx.AwsRequestID
+			if parentID, ok := attrs[telemetry.ParentIDKey]; ok {
+				lumigoSpan.ParentID = fmt.Sprint(parentID)
+			}
 		}
 
 		if isStartSpan {
@@ -164,12 +226,196 @@ func (m *mapper) Transform(invocationStartedTimestamp int64) telemetry.Span {
 		lumigoSpan.SpanInfo.TracerVersion = telemetry.TracerVersion{
 			Version: lumigoCtx.TracerVersion,
 		}
+		if spanType == "function" {
+			lumigoSpan.InvocationNumber = lumigoCtx.InvocationNumber
+		}
+		if isEndSpan {
+			for _, tag := range lumigoCtx.ExecutionTags() {
+				lumigoSpan.ExecutionTags = append(lumigoSpan.ExecutionTags, telemetry.ExecutionTag{Key: tag.Key, Value: tag.Value})
+			}
+			for _, issue := range lumigoCtx.ReportedIssues() {
+				lumigoSpan.ReportedIssues = append(lumigoSpan.ReportedIssues, telemetry.ReportedIssue(issue))
+			}
+		}
 	} else {
 		m.logger.Error("unable to fetch from LumigoContext")
 	}
 	return lumigoSpan
 }
 
+// getParentID returns the ID of the manual span the span was
+// started in, or the ID of the function span
+func (m *mapper) getParentID(attrs map[string]interface{}, lambdaCtx *lambdacontext.LambdaContext) string {
+	if parentID, ok := attrs[telemetry.ParentIDKey]; ok {
+		return fmt.Sprint(parentID)
+	}
+	return lambdaCtx.AwsRequestID
+}
+
+// getManualInfo returns the name of a manual span and the
+// attributes set on it, the secret ones are masked
+func (m *mapper) getManualInfo(attrs map[string]interface{}) *telemetry.ManualInfo {
+	info := telemetry.ManualInfo{Name: m.span.Name()}
+	for key, value := range attrs {
+		if !strings.HasPrefix(key, telemetry.AttributePrefix) {
+			continue
+		}
+		if info.Attributes == nil {
+			info.Attributes = make(map[string]interface{})
+		}
+		key = strings.TrimPrefix(key, telemetry.AttributePrefix)
+		if m.masker.IsSecret(key) {
+			value = masking.MaskedValue
+		}
+		info.Attributes[key] = value
+	}
+	return &info
+}
+
+// getDBInfo returns the statement of a db span and its
+// parameters, the parameters with secret names are masked
+func (m *mapper) getDBInfo(attrs map[string]interface{}) *telemetry.DBInfo {
+	var info telemetry.DBInfo
+	if system, ok := attrs["db.system"]; ok {
+		info.System = fmt.Sprint(system)
+	}
+	info.Statement = m.getAttrAndLimit(attrs, "db.statement")
+	info.Parameters = m.getMaskedAttrAndLimit(attrs, telemetry.DBParametersKey)
+	if rowsAffected, ok := attrs[telemetry.DBRowsAffectedKey].(int64); ok {
+		info.RowsAffected = aws.Int64(rowsAffected)
+	}
+	return &info
+}
+
+// getGRPCInfo returns the call of a grpc span and
+// its messages, with their secret fields masked
+func (m *mapper) getGRPCInfo(attrs map[string]interface{}) *telemetry.GRPCInfo {
+	var info telemetry.GRPCInfo
+	if target, ok := attrs[telemetry.GRPCTargetKey]; ok {
+		info.Target = fmt.Sprint(target)
+	}
+	if service, ok := attrs["rpc.service"]; ok {
+		info.Service = fmt.Sprint(service)
+	}
+	if method, ok := attrs["rpc.method"]; ok {
+		info.Method = fmt.Sprint(method)
+	}
+	if code, ok := attrs["rpc.grpc.status_code"].(int64); ok {
+		info.StatusCode = code
+	}
+	info.Request = m.getMaskedAttrAndLimit(attrs, telemetry.GRPCRequestKey)
+	info.Response = m.getMaskedAttrAndLimit(attrs, telemetry.GRPCResponseKey)
+	return &info
+}
+
+// getRedisInfo returns the commands of a redis span, the arguments
+// of the commands with a secret key, and the values of the secret
+// fields of the hashes, are masked
+func (m *mapper) getRedisInfo(attrs map[string]interface{}) *telemetry.RedisInfo {
+	var info telemetry.RedisInfo
+	if operation, ok := attrs["db.operation"]; ok {
+		info.Operation = fmt.Sprint(operation)
+	}
+	if pipelineSize, ok := attrs[telemetry.RedisPipelineSizeKey].(int64); ok {
+		info.PipelineSize = pipelineSize
+	}
+	if resultSize, ok := attrs[telemetry.RedisResultSizeKey].(int64); ok {
+		info.ResultSize = resultSize
+	}
+	var commands []struct {
+		Name string            `json:"name"`
+		Keys []string          `json:"keys"`
+		Args []json.RawMessage `json:"args"`
+	}
+	if err := json.Unmarshal([]byte(fmt.Sprint(attrs[telemetry.RedisCommandsKey])), &commands); err != nil {
+		m.logger.WithError(err).Error("unable to parse redis commands")
+	}
+	for _, command := range commands {
+		redisCommand := telemetry.RedisCommand{Name: command.Name, Keys: command.Keys}
+		if len(command.Args) > 0 {
+			if telemetry.RedisHashCommands[command.Name] {
+				for i := 0; i+1 < len(command.Args); i += 2 {
+					var field string
+					if err := json.Unmarshal(command.Args[i], &field); err == nil && m.masker.IsSecret(field) {
+						command.Args[i+1], _ = json.Marshal(masking.MaskedValue)
+					}
+				}
+			}
+			for i, arg := range command.Args {
+				// mask the secrets of the JSON documents stored
+				var value string
+				if err := json.Unmarshal(arg, &value); err == nil {
+					command.Args[i], _ = json.Marshal(m.masker.MaskJSON(value))
+				}
+			}
+			args, _ := json.Marshal(command.Args)
+			redisCommand.Args = string(args)
+			for _, key := range command.Keys {
+				if m.masker.IsSecret(key) {
+					redisCommand.Args = masking.MaskedValue
+					break
+				}
+			}
+			if len(redisCommand.Args) > m.maxEntrySize {
+				redisCommand.Args = redisCommand.Args[:m.maxEntrySize]
+			}
+		}
+		info.Commands = append(info.Commands, redisCommand)
+	}
+	return &info
+}
+
+// getKafkaInfo returns the topic of a kafka span and the
+// number of its messages, with the offset of a received one
+func (m *mapper) getKafkaInfo(attrs map[string]interface{}) *telemetry.KafkaInfo {
+	var info telemetry.KafkaInfo
+	if operation, ok := attrs["messaging.operation"]; ok {
+		info.Operation = fmt.Sprint(operation)
+	}
+	if topic, ok := attrs["messaging.destination"]; ok {
+		info.Topic = fmt.Sprint(topic)
+	}
+	if partition, ok := attrs["messaging.kafka.partition"].(int64); ok {
+		info.Partition = aws.Int64(partition)
+	}
+	if offset, ok := attrs[telemetry.KafkaOffsetKey].(int64); ok {
+		info.Offset = aws.Int64(offset)
+	}
+	if count, ok := attrs[telemetry.KafkaMessageCountKey].(int64); ok {
+		info.MessageCount = count
+	}
+	return &info
+}
+
+// getLambdaInvoke returns the invocation of a lambda
+// made by an http span, if it invoked one
+func (m *mapper) getLambdaInvoke(attrs map[string]interface{}) *telemetry.LambdaInvoke {
+	functionName, ok := attrs["faas.invoked_name"]
+	if !ok {
+		return nil
+	}
+	invoke := telemetry.LambdaInvoke{FunctionName: fmt.Sprint(functionName)}
+	if qualifier, ok := attrs[telemetry.LambdaQualifierKey]; ok {
+		invoke.Qualifier = fmt.Sprint(qualifier)
+	}
+	if invocationType, ok := attrs[telemetry.LambdaInvocationTypeKey]; ok {
+		invoke.InvocationType = fmt.Sprint(invocationType)
+	}
+	if requestID, ok := attrs[telemetry.LambdaRequestIDKey]; ok {
+		invoke.RequestID = fmt.Sprint(requestID)
+	}
+	if functionError, ok := attrs[telemetry.LambdaFunctionErrorKey]; ok {
+		invoke.FunctionError = fmt.Sprint(functionError)
+	}
+	if logResult, ok := attrs[telemetry.LambdaLogResultKey]; ok {
+		invoke.LogResult = fmt.Sprint(logResult)
+		if len(invoke.LogResult) > m.maxEntrySize {
+			invoke.LogResult = invoke.LogResult[:m.maxEntrySize]
+		}
+	}
+	return &invoke
+}
+
 func (m *mapper) getSpanError(attrs map[string]interface{}) *telemetry.SpanError {
 	if _, ok := attrs["has_error"]; !ok {
 		return nil
@@ -192,6 +438,12 @@ func (m *mapper) getSpanError(attrs map[string]interface{}) *telemetry.SpanError
 	} else {
 		m.logger.Error("unable to fetch lambda error stacktrace from span")
 	}
+
+	if errCauses, ok := attrs["error_causes"]; ok {
+		if err := json.Unmarshal([]byte(fmt.Sprint(errCauses)), &spanError.Causes); err != nil {
+			m.logger.WithError(err).Error("unable to parse lambda error causes")
+		}
+	}
 	if spanError.IsEmpty() {
 		return nil
 	}
@@ -204,7 +456,7 @@ func (m *mapper) getEnvVars() string {
 		pair := strings.SplitN(e, "=", 2)
 		envs[pair[0]] = pair[1]
 	}
-	envsBytes, err := json.Marshal(envs)
+	envsBytes, err := json.Marshal(m.masker.MaskMap(envs))
 	if err != nil {
 		m.logger.Error("unable to fetch lambda environment vars")
 	}
@@ -288,6 +540,12 @@ func getAmazonTraceID() string {
 	return ""
 }
 
+// TransactionID returns the Lumigo transaction ID of the
+// current invocation, from its Amazon trace ID
+func TransactionID() string {
+	return getTransactionID(getAmazonTraceID())
+}
+
 func getTransactionID(root string) string {
 	items := strings.SplitN(root, "-", 3)
 	if len(items) > 1 {
@@ -309,6 +567,20 @@ func (m *mapper) getAttrAndLimit(attrs map[string]interface{}, key string) strin
 	return ""
 }
 
+// getMaskedAttrAndLimit returns the JSON attribute of key with
+// its secret values masked, the attribute is optional
+func (m *mapper) getMaskedAttrAndLimit(attrs map[string]interface{}, key string) string {
+	value, ok := attrs[key]
+	if !ok {
+		return ""
+	}
+	valueStr := m.masker.MaskJSON(fmt.Sprint(value))
+	if len(valueStr) > m.maxEntrySize {
+		valueStr = valueStr[:m.maxEntrySize]
+	}
+	return valueStr
+}
+
 // backward compatability for time.Now().UnixMilli() in go 1.16 and earlier
 func unixMilli(t time.Time) int64 {
 	return t.UnixNano() / int64(time.Millisecond)
@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/google/go-cmp/cmp"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
 	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -264,6 +265,7 @@ func TestTransform(t *testing.T) {
 					attribute.String("error_type", "TestError"),
 					attribute.String("error_message", "failed error"),
 					attribute.String("error_stacktrace", "failed error"),
+					attribute.String("error_causes", `[{"type":"*fmt.wrapError","message":"failed error"},{"type":"TestError","message":"error"}]`),
 				},
 			},
 			expect: telemetry.Span{
@@ -280,6 +282,10 @@ func TestTransform(t *testing.T) {
 					Type:       "TestError",
 					Message:    "failed error",
 					Stacktrace: "failed error",
+					Causes: []telemetry.ErrorCause{
+						{Type: "*fmt.wrapError", Message: "failed error"},
+						{Type: "TestError", Message: "error"},
+					},
 				},
 			},
 			checkEnv: true,
@@ -348,6 +354,136 @@ func TestTransform(t *testing.T) {
 				os.Unsetenv("IS_WARM_START")
 			},
 		},
+		{
+			testname: "manual span",
+			input: &tracetest.SpanStub{
+				SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+					TraceID: traceID,
+					SpanID:  spanID,
+				}),
+				StartTime: now,
+				EndTime:   now.Add(1 * time.Second),
+				Name:      "compute",
+				Attributes: []attribute.KeyValue{
+					attribute.String(telemetry.SpanTypeKey, telemetry.ManualSpanType),
+					attribute.String(telemetry.ParentIDKey, "parent-id"),
+					attribute.Int64(telemetry.AttributePrefix+"items", 3),
+					attribute.String(telemetry.AttributePrefix+"cache", "users"),
+				},
+			},
+			expect: telemetry.Span{
+				SpanType:         "manual",
+				Account:          "account-id",
+				ID:               spanID.String(),
+				ParentID:         "parent-id",
+				StartedTimestamp: unixMilli(now),
+				EndedTimestamp:   unixMilli(now.Add(1 * time.Second)),
+				SpanInfo: telemetry.SpanInfo{
+					ManualInfo: &telemetry.ManualInfo{
+						Name:       "compute",
+						Attributes: map[string]interface{}{"items": int64(3), "cache": "users"},
+					},
+				},
+			},
+			before: func() {
+				os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "test")
+			},
+			after: func() {
+				os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+			},
+		},
+		{
+			testname: "db span",
+			input: &tracetest.SpanStub{
+				SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+					TraceID: traceID,
+					SpanID:  spanID,
+				}),
+				StartTime: now,
+				EndTime:   now.Add(1 * time.Second),
+				Name:      "INSERT",
+				Attributes: []attribute.KeyValue{
+					attribute.String(telemetry.SpanTypeKey, telemetry.DBSpanType),
+					attribute.String("db.system", "postgresql"),
+					attribute.String("db.statement", "INSERT INTO users VALUES ($1)"),
+					attribute.String(telemetry.DBParametersKey, `{"1":"John"}`),
+					attribute.Bool("has_error", true),
+					attribute.String("error_type", "*pq.Error"),
+					attribute.String("error_message", "duplicate key"),
+					attribute.String("error_stacktrace", ""),
+				},
+			},
+			expect: telemetry.Span{
+				SpanType:         "db",
+				Account:          "account-id",
+				ID:               spanID.String(),
+				ParentID:         mockLambdaContext.AwsRequestID,
+				StartedTimestamp: unixMilli(now),
+				EndedTimestamp:   unixMilli(now.Add(1 * time.Second)),
+				SpanInfo: telemetry.SpanInfo{
+					DBInfo: &telemetry.DBInfo{
+						System:     "postgresql",
+						Statement:  "INSERT INTO users VALUES ($1)",
+						Parameters: `{"1":"John"}`,
+					},
+				},
+				SpanError: &telemetry.SpanError{
+					Type:    "*pq.Error",
+					Message: "duplicate key",
+				},
+			},
+			before: func() {
+				os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "test")
+			},
+			after: func() {
+				os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+			},
+		},
+		{
+			testname: "grpc span",
+			input: &tracetest.SpanStub{
+				SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+					TraceID: traceID,
+					SpanID:  spanID,
+				}),
+				StartTime: now,
+				EndTime:   now.Add(1 * time.Second),
+				Name:      "/orders.Orders/Get",
+				Attributes: []attribute.KeyValue{
+					attribute.String(telemetry.SpanTypeKey, telemetry.GRPCSpanType),
+					attribute.String(telemetry.ParentIDKey, "parent-id"),
+					attribute.String(telemetry.GRPCTargetKey, "orders:443"),
+					attribute.String("rpc.service", "orders.Orders"),
+					attribute.String("rpc.method", "Get"),
+					attribute.Int64("rpc.grpc.status_code", 0),
+					attribute.String(telemetry.GRPCRequestKey, `{"id":"o-1"}`),
+					attribute.String(telemetry.GRPCResponseKey, `{"id":"o-1","total":42}`),
+				},
+			},
+			expect: telemetry.Span{
+				SpanType:         "grpc",
+				Account:          "account-id",
+				ID:               spanID.String(),
+				ParentID:         "parent-id",
+				StartedTimestamp: unixMilli(now),
+				EndedTimestamp:   unixMilli(now.Add(1 * time.Second)),
+				SpanInfo: telemetry.SpanInfo{
+					GRPCInfo: &telemetry.GRPCInfo{
+						Target:   "orders:443",
+						Service:  "orders.Orders",
+						Method:   "Get",
+						Request:  `{"id":"o-1"}`,
+						Response: `{"id":"o-1","total":42}`,
+					},
+				},
+			},
+			before: func() {
+				os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "test")
+			},
+			after: func() {
+				os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+			},
+		},
 		{
 			testname: "end span check limits",
 			input: &tracetest.SpanStub{
@@ -388,7 +524,7 @@ func TestTransform(t *testing.T) {
 
 	for _, tc := range testcases {
 		tc.before()
-		mapper := NewMapper(ctx, tc.input.Snapshot(), logrus.New(), 2048)
+		mapper := NewMapper(ctx, tc.input.Snapshot(), logrus.New(), 2048, nil)
 		invocationStartedTimestamp := unixMilli(now)
 		if tc.expect.SpanType == "function" && strings.HasSuffix(tc.expect.ID, "_started") {
 			invocationStartedTimestamp = 0
@@ -419,10 +555,157 @@ func TestTransformCheckEnvsCut(t *testing.T) {
 	span := &tracetest.SpanStub{}
 	os.Setenv("REALLY_LONG_ENV", strings.Repeat("envs", 512))
 	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
-	mapper := NewMapper(ctx, span.Snapshot(), logrus.New(), 2048)
+	mapper := NewMapper(ctx, span.Snapshot(), logrus.New(), 2048, nil)
 	lumigoSpan := mapper.Transform(0)
 	if len(lumigoSpan.LambdaEnvVars) != 2048 {
 		t.Errorf("LambdaEnvVars should be of size 2048, got %d", len(lumigoSpan.LambdaEnvVars))
 	}
 	os.Unsetenv("REALLY_LONG_ENV")
 }
+
+func TestTransformMasksEnvs(t *testing.T) {
+	span := &tracetest.SpanStub{}
+	os.Setenv("DB_PASSWORD", "1234")
+	defer os.Unsetenv("DB_PASSWORD")
+	masker, err := masking.New(nil)
+	assert.NoError(t, err)
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	mapper := NewMapper(ctx, span.Snapshot(), logrus.New(), 1024*1024, masker)
+	lumigoSpan := mapper.Transform(0)
+	assert.Contains(t, lumigoSpan.LambdaEnvVars, `"DB_PASSWORD":"****"`)
+}
+
+func TestTransformParsesTrigger(t *testing.T) {
+	os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "test")
+	defer os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+	event := `{"Records":[{"eventSource":"aws:sqs","messageId":"message-1","eventSourceARN":"arn:aws:sqs:us-east-1:123:queue"}]}`
+	triggerAttrs, err := TriggerAttributes(event)
+	assert.NoError(t, err)
+	span := &tracetest.SpanStub{
+		Name:       "test",
+		Attributes: append([]attribute.KeyValue{attribute.String("event", event)}, triggerAttrs...),
+	}
+	masker, err := masking.New(nil)
+	assert.NoError(t, err)
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	mapper := NewMapper(ctx, span.Snapshot(), logrus.New(), 2048, masker)
+	lumigoSpan := mapper.Transform(0)
+	assert.Equal(t, "sqs", lumigoSpan.SpanInfo.TriggeredBy)
+	assert.Equal(t, "arn:aws:sqs:us-east-1:123:queue", lumigoSpan.SpanInfo.Arn)
+	assert.Equal(t, "message-1", lumigoSpan.SpanInfo.MessageID)
+}
+
+func TestTransformRedisSpan(t *testing.T) {
+	span := &tracetest.SpanStub{
+		Name: "pipeline",
+		Attributes: []attribute.KeyValue{
+			attribute.String(telemetry.SpanTypeKey, telemetry.RedisSpanType),
+			attribute.String("db.operation", "pipeline"),
+			attribute.Int64(telemetry.RedisPipelineSizeKey, 3),
+			attribute.Int64(telemetry.RedisResultSizeKey, 7),
+			attribute.String(telemetry.RedisCommandsKey, `[
+				{"name":"set","keys":["user:1"],"args":["{\"name\":\"John\",\"password\":\"1234\"}"]},
+				{"name":"set","keys":["api_token"],"args":["abcd",60]},
+				{"name":"set","keys":["big"],"args":["`+strings.Repeat("v", 64)+`"]},
+				{"name":"get","keys":["user:1"]}
+			]`),
+		},
+	}
+	masker, err := masking.New(nil)
+	assert.NoError(t, err)
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	mapper := NewMapper(ctx, span.Snapshot(), logrus.New(), 32, masker)
+	lumigoSpan := mapper.Transform(0)
+	assert.Equal(t, "redis", lumigoSpan.SpanType)
+	assert.Equal(t, &telemetry.RedisInfo{
+		Operation:    "pipeline",
+		PipelineSize: 3,
+		ResultSize:   7,
+		Commands: []telemetry.RedisCommand{
+			{Name: "set", Keys: []string{"user:1"}, Args: `["{\"name\":\"John\",\"password\`},
+			{Name: "set", Keys: []string{"api_token"}, Args: "****"},
+			{Name: "set", Keys: []string{"big"}, Args: `["` + strings.Repeat("v", 30)},
+			{Name: "get", Keys: []string{"user:1"}},
+		},
+	}, lumigoSpan.SpanInfo.RedisInfo)
+
+	mapper = NewMapper(ctx, span.Snapshot(), logrus.New(), 1024, masker)
+	lumigoSpan = mapper.Transform(0)
+	assert.Equal(t, `["{\"name\":\"John\",\"password\":\"****\"}"]`, lumigoSpan.SpanInfo.RedisInfo.Commands[0].Args)
+}
+
+func TestTransformRedisSpanMasksHashFields(t *testing.T) {
+	span := &tracetest.SpanStub{
+		Name: "pipeline",
+		Attributes: []attribute.KeyValue{
+			attribute.String(telemetry.SpanTypeKey, telemetry.RedisSpanType),
+			attribute.String(telemetry.RedisCommandsKey, `[
+				{"name":"hset","keys":["user:1"],"args":["name","John","password","1234"]},
+				{"name":"hmset","keys":["user:2"],"args":["api_token","abcd","age",30]},
+				{"name":"set","keys":["user:3"],"args":["password"]}
+			]`),
+		},
+	}
+	masker, err := masking.New(nil)
+	assert.NoError(t, err)
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	mapper := NewMapper(ctx, span.Snapshot(), logrus.New(), 1024, masker)
+	lumigoSpan := mapper.Transform(0)
+	assert.Equal(t, []telemetry.RedisCommand{
+		{Name: "hset", Keys: []string{"user:1"}, Args: `["name","John","password","****"]`},
+		{Name: "hmset", Keys: []string{"user:2"}, Args: `["api_token","****","age",30]`},
+		{Name: "set", Keys: []string{"user:3"}, Args: `["password"]`},
+	}, lumigoSpan.SpanInfo.RedisInfo.Commands)
+}
+
+func TestTransformKafkaSpan(t *testing.T) {
+	testcases := []struct {
+		testname   string
+		attrs      []attribute.KeyValue
+		expect     *telemetry.KafkaInfo
+		messageID  string
+		messageIDs []string
+	}{
+		{
+			testname: "send",
+			attrs: []attribute.KeyValue{
+				attribute.String("messaging.operation", "send"),
+				attribute.String("messaging.destination", "orders"),
+				attribute.Int64(telemetry.KafkaMessageCountKey, 2),
+				attribute.StringSlice(telemetry.KafkaMessageIDsKey, []string{"m1", "m2"}),
+			},
+			expect:     &telemetry.KafkaInfo{Operation: "send", Topic: "orders", MessageCount: 2},
+			messageIDs: []string{"m1", "m2"},
+		},
+		{
+			testname: "receive",
+			attrs: []attribute.KeyValue{
+				attribute.String("messaging.operation", "receive"),
+				attribute.String("messaging.destination", "orders"),
+				attribute.Int64("messaging.kafka.partition", 0),
+				attribute.Int64(telemetry.KafkaOffsetKey, 15),
+				attribute.Int64(telemetry.KafkaMessageCountKey, 1),
+				attribute.StringSlice(telemetry.KafkaMessageIDsKey, []string{"m1"}),
+			},
+			expect:    &telemetry.KafkaInfo{Operation: "receive", Topic: "orders", Partition: aws.Int64(0), Offset: aws.Int64(15), MessageCount: 1},
+			messageID: "m1",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			span := &tracetest.SpanStub{
+				Name:       "orders " + tc.testname,
+				Attributes: append(tc.attrs, attribute.String(telemetry.SpanTypeKey, telemetry.KafkaSpanType)),
+			}
+			ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+			mapper := NewMapper(ctx, span.Snapshot(), logrus.New(), 1024, nil)
+			lumigoSpan := mapper.Transform(0)
+			assert.Equal(t, "kafka", lumigoSpan.SpanType)
+			assert.Equal(t, tc.expect, lumigoSpan.SpanInfo.KafkaInfo)
+			assert.Equal(t, tc.messageID, lumigoSpan.SpanInfo.MessageID)
+			assert.Equal(t, tc.messageIDs, lumigoSpan.SpanInfo.MessageIDs)
+			assert.Equal(t, mockLambdaContext.AwsRequestID, lumigoSpan.ParentID)
+		})
+	}
+}
@@ -0,0 +1,453 @@
+package transform
+
+import (
+	"crypto/md5" // nolint:gosec
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// eventShape holds the fields telling the
+// well-known AWS events apart
+type eventShape struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+	} `json:"Records"`
+	Version        string `json:"version"`
+	HTTPMethod     string `json:"httpMethod"`
+	RequestContext *struct {
+		ELB  *struct{} `json:"elb"`
+		HTTP *struct{} `json:"http"`
+	} `json:"requestContext"`
+	DetailType string    `json:"detail-type"`
+	AWSLogs    *struct{} `json:"awslogs"`
+	Lumigo     *struct {
+		StepFunctionUID string `json:"step_function_uid"`
+	} `json:"_lumigo"`
+}
+
+// TriggerAttributes returns the attributes of the trigger of the
+// lambda parsed from its event, which is parsed before its secrets
+// are masked as they may be the keys of the triggering messages
+func TriggerAttributes(event string) ([]attribute.KeyValue, error) {
+	info, messageIDs, err := parseTrigger(event)
+	if err != nil {
+		return nil, err
+	}
+	if info.TriggeredBy == "" {
+		return nil, nil
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []attribute.KeyValue{attribute.String(telemetry.TriggerKey, string(data))}
+	if len(messageIDs) > 0 {
+		attrs = append(attrs, attribute.StringSlice(telemetry.TriggerMessageIDsKey, messageIDs))
+	}
+	return attrs, nil
+}
+
+// parseTrigger returns the info about the trigger of the lambda and the
+// IDs of the messages which triggered it, an unknown event has no info
+func parseTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var source struct {
+		EventSource string `json:"eventSource"`
+	}
+	if err := json.Unmarshal([]byte(event), &source); err != nil {
+		return telemetry.TriggerInfo{}, nil, nil
+	}
+	if telemetry.IsKafkaEventSource(source.EventSource) {
+		// the records of Kafka events are
+		// grouped by topic and partition
+		return parseKafkaTrigger(event)
+	}
+	var shape eventShape
+	if err := json.Unmarshal([]byte(event), &shape); err != nil {
+		return telemetry.TriggerInfo{}, nil, nil
+	}
+	switch {
+	case len(shape.Records) > 0:
+		switch strings.ToLower(shape.Records[0].EventSource) {
+		case "aws:sqs":
+			return parseSQSTrigger(event)
+		case "aws:sns":
+			return parseSNSTrigger(event)
+		case "aws:s3":
+			return parseS3Trigger(event)
+		case "aws:dynamodb":
+			return parseDynamoDBTrigger(event)
+		case "aws:kinesis":
+			return parseKinesisTrigger(event)
+		}
+	case shape.RequestContext != nil && shape.RequestContext.ELB != nil:
+		return parseALBTrigger(event)
+	case shape.RequestContext != nil && shape.RequestContext.HTTP != nil && shape.Version == "2.0":
+		return parseAPIGatewayV2Trigger(event)
+	case shape.RequestContext != nil && shape.HTTPMethod != "":
+		return parseAPIGatewayTrigger(event)
+	case shape.DetailType != "":
+		return parseEventBridgeTrigger(event)
+	case shape.AWSLogs != nil:
+		return parseCloudWatchLogsTrigger(event)
+	case shape.Lumigo != nil && shape.Lumigo.StepFunctionUID != "":
+		return telemetry.TriggerInfo{TriggeredBy: "stepFunction"}, []string{shape.Lumigo.StepFunctionUID}, nil
+	}
+	return telemetry.TriggerInfo{}, nil, nil
+}
+
+func parseAPIGatewayTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var req events.APIGatewayProxyRequest
+	if err := json.Unmarshal([]byte(event), &req); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	api := req.RequestContext.DomainName
+	if api == "" {
+		api = headerValue(req.Headers, "Host")
+	}
+	return telemetry.TriggerInfo{
+		TriggeredBy:             "apigw",
+		Api:                     api,
+		Resource:                req.Resource,
+		HttpMethod:              req.HTTPMethod,
+		Stage:                   req.RequestContext.Stage,
+		ApproxEventCreationTime: req.RequestContext.RequestTimeEpoch,
+	}, []string{req.RequestContext.RequestID}, nil
+}
+
+func parseAPIGatewayV2Trigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var req events.APIGatewayV2HTTPRequest
+	if err := json.Unmarshal([]byte(event), &req); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	return telemetry.TriggerInfo{
+		TriggeredBy:             "apigw",
+		Api:                     req.RequestContext.DomainName,
+		Resource:                req.RequestContext.HTTP.Path,
+		HttpMethod:              req.RequestContext.HTTP.Method,
+		Stage:                   req.RequestContext.Stage,
+		ApproxEventCreationTime: req.RequestContext.TimeEpoch,
+	}, []string{req.RequestContext.RequestID}, nil
+}
+
+func parseALBTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var req events.ALBTargetGroupRequest
+	if err := json.Unmarshal([]byte(event), &req); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	return telemetry.TriggerInfo{
+		TriggeredBy: "load_balancer",
+		Api:         headerValue(req.Headers, "Host"),
+		Resource:    req.Path,
+		HttpMethod:  req.HTTPMethod,
+		Arn:         req.RequestContext.ELB.TargetGroupArn,
+	}, nil, nil
+}
+
+func parseSQSTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var sqsEvent events.SQSEvent
+	if err := json.Unmarshal([]byte(event), &sqsEvent); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	info := telemetry.TriggerInfo{
+		TriggeredBy: "sqs",
+		Arn:         sqsEvent.Records[0].EventSourceARN,
+		RecordsNum:  len(sqsEvent.Records),
+	}
+	var messageIDs []string
+	for _, record := range sqsEvent.Records {
+		messageIDs = append(messageIDs, record.MessageId)
+	}
+	if sentTimestamp, err := strconv.ParseInt(sqsEvent.Records[0].Attributes["SentTimestamp"], 10, 64); err == nil {
+		info.ApproxEventCreationTime = sentTimestamp
+	}
+	return info, messageIDs, nil
+}
+
+func parseSNSTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var snsEvent events.SNSEvent
+	if err := json.Unmarshal([]byte(event), &snsEvent); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	info := telemetry.TriggerInfo{
+		TriggeredBy:             "sns",
+		Arn:                     snsEvent.Records[0].SNS.TopicArn,
+		RecordsNum:              len(snsEvent.Records),
+		ApproxEventCreationTime: unixMilliOrZero(snsEvent.Records[0].SNS.Timestamp),
+	}
+	var messageIDs []string
+	for _, record := range snsEvent.Records {
+		messageIDs = append(messageIDs, record.SNS.MessageID)
+	}
+	return info, messageIDs, nil
+}
+
+func parseS3Trigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var s3Event events.S3Event
+	if err := json.Unmarshal([]byte(event), &s3Event); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	record := s3Event.Records[0]
+	info := telemetry.TriggerInfo{
+		TriggeredBy:             "s3",
+		Arn:                     record.S3.Bucket.Arn,
+		Resource:                record.EventName,
+		RecordsNum:              len(s3Event.Records),
+		ApproxEventCreationTime: unixMilliOrZero(record.EventTime),
+	}
+	var messageIDs []string
+	for _, record := range s3Event.Records {
+		if requestID := record.ResponseElements["x-amz-request-id"]; requestID != "" {
+			messageIDs = append(messageIDs, requestID)
+		}
+	}
+	return info, messageIDs, nil
+}
+
+func parseDynamoDBTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var dynamoEvent events.DynamoDBEvent
+	if err := json.Unmarshal([]byte(event), &dynamoEvent); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	info := telemetry.TriggerInfo{
+		TriggeredBy:             "dynamodb",
+		Arn:                     dynamoEvent.Records[0].EventSourceArn,
+		RecordsNum:              len(dynamoEvent.Records),
+		ApproxEventCreationTime: unixMilliOrZero(dynamoEvent.Records[0].Change.ApproximateCreationDateTime.Time),
+	}
+	var messageIDs []string
+	for _, record := range dynamoEvent.Records {
+		var item map[string]events.DynamoDBAttributeValue
+		switch record.EventName {
+		case "MODIFY", "REMOVE":
+			item = record.Change.Keys
+		case "INSERT":
+			item = record.Change.NewImage
+		}
+		if len(item) == 0 {
+			continue
+		}
+		if messageID, err := itemHash(item); err == nil {
+			messageIDs = append(messageIDs, messageID)
+		}
+	}
+	return info, messageIDs, nil
+}
+
+func parseKinesisTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var kinesisEvent events.KinesisEvent
+	if err := json.Unmarshal([]byte(event), &kinesisEvent); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	info := telemetry.TriggerInfo{
+		TriggeredBy:             "kinesis",
+		Arn:                     kinesisEvent.Records[0].EventSourceArn,
+		RecordsNum:              len(kinesisEvent.Records),
+		ApproxEventCreationTime: unixMilliOrZero(kinesisEvent.Records[0].Kinesis.ApproximateArrivalTimestamp.Time),
+	}
+	var messageIDs []string
+	for _, record := range kinesisEvent.Records {
+		messageIDs = append(messageIDs, record.Kinesis.SequenceNumber)
+	}
+	return info, messageIDs, nil
+}
+
+func parseKafkaTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var kafkaEvent events.KafkaEvent
+	if err := json.Unmarshal([]byte(event), &kafkaEvent); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	info := telemetry.TriggerInfo{
+		TriggeredBy: "kafka",
+		Arn:         kafkaEvent.EventSourceARN,
+	}
+	var messageIDs []string
+	for _, record := range kafkaRecords(kafkaEvent) {
+		if info.RecordsNum == 0 {
+			info.Resource = record.Topic
+			info.ApproxEventCreationTime = unixMilliOrZero(record.Timestamp.Time)
+		}
+		info.RecordsNum++
+		for _, header := range record.Headers {
+			if messageID, ok := header[telemetry.KafkaMessageIDHeader]; ok {
+				messageIDs = append(messageIDs, string(messageID))
+			}
+		}
+	}
+	return info, messageIDs, nil
+}
+
+// kafkaRecords returns the records of a Kafka event,
+// ordered by topic and partition
+func kafkaRecords(kafkaEvent events.KafkaEvent) []events.KafkaRecord {
+	keys := make([]string, 0, len(kafkaEvent.Records))
+	for key := range kafkaEvent.Records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var records []events.KafkaRecord
+	for _, key := range keys {
+		records = append(records, kafkaEvent.Records[key]...)
+	}
+	return records
+}
+
+func parseEventBridgeTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var bridgeEvent events.CloudWatchEvent
+	if err := json.Unmarshal([]byte(event), &bridgeEvent); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	info := telemetry.TriggerInfo{
+		TriggeredBy:             "eventBridge",
+		Resource:                bridgeEvent.DetailType,
+		ApproxEventCreationTime: unixMilliOrZero(bridgeEvent.Time),
+	}
+	if len(bridgeEvent.Resources) > 0 {
+		info.Arn = bridgeEvent.Resources[0]
+	}
+	return info, []string{bridgeEvent.ID}, nil
+}
+
+func parseCloudWatchLogsTrigger(event string) (telemetry.TriggerInfo, []string, error) {
+	var logsEvent events.CloudwatchLogsEvent
+	if err := json.Unmarshal([]byte(event), &logsEvent); err != nil {
+		return telemetry.TriggerInfo{}, nil, err
+	}
+	data, err := logsEvent.AWSLogs.Parse()
+	if err != nil {
+		return telemetry.TriggerInfo{TriggeredBy: "cloudwatch"}, nil, err
+	}
+	info := telemetry.TriggerInfo{
+		TriggeredBy: "cloudwatch",
+		Resource:    data.LogGroup,
+		RecordsNum:  len(data.LogEvents),
+	}
+	if len(data.LogEvents) > 0 {
+		info.ApproxEventCreationTime = data.LogEvents[0].Timestamp
+	}
+	return info, nil, nil
+}
+
+// setMessageIDs sets the message ID of a single
+// message or the message IDs of several ones
+func setMessageIDs(info *telemetry.SpanInfo, messageIDs []string) {
+	if len(messageIDs) == 1 {
+		info.MessageID = messageIDs[0]
+	} else if len(messageIDs) > 1 {
+		info.MessageIDs = messageIDs
+	}
+}
+
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func unixMilliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return unixMilli(t)
+}
+
+// itemHash returns the md5 of a DynamoDB item, encoded the way the
+// other Lumigo tracers encode it so the UI can match the writes of
+// the item with the invocations it triggers
+func itemHash(item interface{}) (string, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	var encoded strings.Builder
+	writeSortedJSON(&encoded, value)
+	hash := md5.Sum([]byte(encoded.String())) // nolint:gosec
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// writeSortedJSON writes the value as JSON with sorted keys,
+// ", " and ": " separators and ASCII only strings
+func writeSortedJSON(b *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteString("{")
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeASCIIString(b, key)
+			b.WriteString(": ")
+			writeSortedJSON(b, v[key])
+		}
+		b.WriteString("}")
+	case []interface{}:
+		b.WriteString("[")
+		for i, item := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeSortedJSON(b, item)
+		}
+		b.WriteString("]")
+	case string:
+		writeASCIIString(b, v)
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case nil:
+		b.WriteString("null")
+	default:
+		b.WriteString(fmt.Sprint(v))
+	}
+}
+
+func writeASCIIString(b *strings.Builder, s string) {
+	b.WriteString(`"`)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r < 0x20 || (r > 0x7e && r <= 0xffff):
+			fmt.Fprintf(b, `\u%04x`, r)
+		case r > 0xffff:
+			r -= 0x10000
+			fmt.Fprintf(b, `\u%04x\u%04x`, 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(`"`)
+}
@@ -0,0 +1,339 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func cloudWatchLogsData(t *testing.T, data events.CloudwatchLogsData) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	assert.NoError(t, json.NewEncoder(zw).Encode(data))
+	assert.NoError(t, zw.Close())
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestParseTrigger(t *testing.T) {
+	eventTime := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	eventMillis := unixMilli(eventTime)
+
+	testcases := []struct {
+		testname   string
+		event      string
+		expect     telemetry.TriggerInfo
+		messageIDs []string
+	}{
+		{
+			testname: "not an object",
+			event:    `"hello"`,
+			expect:   telemetry.TriggerInfo{},
+		},
+		{
+			testname: "unknown object",
+			event:    `{"key1":"value1"}`,
+			expect:   telemetry.TriggerInfo{},
+		},
+		{
+			testname: "api gateway rest",
+			event: mustMarshal(t, events.APIGatewayProxyRequest{
+				Resource:   "/users/{id}",
+				Path:       "/users/1",
+				HTTPMethod: "GET",
+				Headers:    map[string]string{"host": "api.example.com"},
+				RequestContext: events.APIGatewayProxyRequestContext{
+					APIID:            "abc123",
+					Stage:            "prod",
+					RequestID:        "request-id",
+					RequestTimeEpoch: eventMillis,
+				},
+			}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "apigw",
+				Api:                     "api.example.com",
+				Resource:                "/users/{id}",
+				HttpMethod:              "GET",
+				Stage:                   "prod",
+				ApproxEventCreationTime: eventMillis,
+			},
+			messageIDs: []string{"request-id"},
+		},
+		{
+			testname: "api gateway http",
+			event: mustMarshal(t, events.APIGatewayV2HTTPRequest{
+				Version: "2.0",
+				RawPath: "/users/1",
+				RequestContext: events.APIGatewayV2HTTPRequestContext{
+					DomainName: "api.example.com",
+					Stage:      "$default",
+					RequestID:  "request-id",
+					TimeEpoch:  eventMillis,
+					HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+						Method: "POST",
+						Path:   "/users/1",
+					},
+				},
+			}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "apigw",
+				Api:                     "api.example.com",
+				Resource:                "/users/1",
+				HttpMethod:              "POST",
+				Stage:                   "$default",
+				ApproxEventCreationTime: eventMillis,
+			},
+			messageIDs: []string{"request-id"},
+		},
+		{
+			testname: "alb",
+			event: mustMarshal(t, events.ALBTargetGroupRequest{
+				HTTPMethod: "GET",
+				Path:       "/health",
+				Headers:    map[string]string{"Host": "alb.example.com"},
+				RequestContext: events.ALBTargetGroupRequestContext{
+					ELB: events.ELBContext{TargetGroupArn: "arn:aws:elasticloadbalancing:us-east-1:123:targetgroup/tg/1"},
+				},
+			}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy: "load_balancer",
+				Api:         "alb.example.com",
+				Resource:    "/health",
+				HttpMethod:  "GET",
+				Arn:         "arn:aws:elasticloadbalancing:us-east-1:123:targetgroup/tg/1",
+			},
+		},
+		{
+			testname: "sqs single message",
+			event: mustMarshal(t, events.SQSEvent{Records: []events.SQSMessage{{
+				MessageId:      "message-1",
+				EventSource:    "aws:sqs",
+				EventSourceARN: "arn:aws:sqs:us-east-1:123:queue",
+				Attributes:     map[string]string{"SentTimestamp": "1646128800000"},
+			}}}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "sqs",
+				Arn:                     "arn:aws:sqs:us-east-1:123:queue",
+				RecordsNum:              1,
+				ApproxEventCreationTime: 1646128800000,
+			},
+			messageIDs: []string{"message-1"},
+		},
+		{
+			testname: "sqs batch",
+			event: mustMarshal(t, events.SQSEvent{Records: []events.SQSMessage{
+				{MessageId: "message-1", EventSource: "aws:sqs", EventSourceARN: "arn:aws:sqs:us-east-1:123:queue"},
+				{MessageId: "message-2", EventSource: "aws:sqs", EventSourceARN: "arn:aws:sqs:us-east-1:123:queue"},
+			}}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy: "sqs",
+				Arn:         "arn:aws:sqs:us-east-1:123:queue",
+				RecordsNum:  2,
+			},
+			messageIDs: []string{"message-1", "message-2"},
+		},
+		{
+			testname: "sns",
+			event: mustMarshal(t, events.SNSEvent{Records: []events.SNSEventRecord{{
+				EventSource: "aws:sns",
+				SNS: events.SNSEntity{
+					MessageID: "message-1",
+					TopicArn:  "arn:aws:sns:us-east-1:123:topic",
+					Timestamp: eventTime,
+				},
+			}}}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "sns",
+				Arn:                     "arn:aws:sns:us-east-1:123:topic",
+				RecordsNum:              1,
+				ApproxEventCreationTime: eventMillis,
+			},
+			messageIDs: []string{"message-1"},
+		},
+		{
+			testname: "s3",
+			event: mustMarshal(t, events.S3Event{Records: []events.S3EventRecord{{
+				EventSource:      "aws:s3",
+				EventName:        "ObjectCreated:Put",
+				EventTime:        eventTime,
+				ResponseElements: map[string]string{"x-amz-request-id": "request-id"},
+				S3: events.S3Entity{
+					Bucket: events.S3Bucket{Arn: "arn:aws:s3:::bucket"},
+				},
+			}}}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "s3",
+				Arn:                     "arn:aws:s3:::bucket",
+				Resource:                "ObjectCreated:Put",
+				RecordsNum:              1,
+				ApproxEventCreationTime: eventMillis,
+			},
+			messageIDs: []string{"request-id"},
+		},
+		{
+			testname: "dynamodb",
+			event: mustMarshal(t, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+				{
+					EventSource:    "aws:dynamodb",
+					EventName:      "MODIFY",
+					EventSourceArn: "arn:aws:dynamodb:us-east-1:123:table/users/stream/1",
+					Change: events.DynamoDBStreamRecord{
+						ApproximateCreationDateTime: events.SecondsEpochTime{Time: eventTime},
+						Keys: map[string]events.DynamoDBAttributeValue{
+							"id": events.NewStringAttribute("1"),
+						},
+					},
+				},
+				{
+					EventSource:    "aws:dynamodb",
+					EventName:      "INSERT",
+					EventSourceArn: "arn:aws:dynamodb:us-east-1:123:table/users/stream/1",
+					Change: events.DynamoDBStreamRecord{
+						NewImage: map[string]events.DynamoDBAttributeValue{
+							"id":   events.NewStringAttribute("1"),
+							"name": events.NewStringAttribute("héllo"),
+							"n":    events.NewNumberAttribute("12"),
+						},
+					},
+				},
+			}}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "dynamodb",
+				Arn:                     "arn:aws:dynamodb:us-east-1:123:table/users/stream/1",
+				RecordsNum:              2,
+				ApproxEventCreationTime: eventMillis,
+			},
+			messageIDs: []string{"63fdc94765b4cce96704e7f2a10ccf31", "6383b057fa9555211bbd611dcedae207"},
+		},
+		{
+			testname: "kinesis",
+			event: mustMarshal(t, events.KinesisEvent{Records: []events.KinesisEventRecord{{
+				EventSource:    "aws:kinesis",
+				EventSourceArn: "arn:aws:kinesis:us-east-1:123:stream/stream",
+				Kinesis: events.KinesisRecord{
+					SequenceNumber:              "4950",
+					ApproximateArrivalTimestamp: events.SecondsEpochTime{Time: eventTime},
+				},
+			}}}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "kinesis",
+				Arn:                     "arn:aws:kinesis:us-east-1:123:stream/stream",
+				RecordsNum:              1,
+				ApproxEventCreationTime: eventMillis,
+			},
+			messageIDs: []string{"4950"},
+		},
+		{
+			testname: "msk",
+			event: `{"eventSource": "aws:kafka", "eventSourceArn": "arn:aws:kafka:us-east-1:123:cluster/orders/abc",
+				"records": {
+					"orders-1": [{"topic": "orders", "partition": 1, "offset": 7, "timestamp": ` + fmt.Sprint(eventMillis+1) + `,
+						"headers": [{"lumigoMessageId": [109, 50]}]}],
+					"orders-0": [{"topic": "orders", "partition": 0, "offset": 15, "timestamp": ` + fmt.Sprint(eventMillis) + `,
+						"headers": [{"traceparent": [48, 48]}, {"lumigoMessageId": [109, 49]}]},
+						{"topic": "orders", "partition": 0, "offset": 16, "timestamp": ` + fmt.Sprint(eventMillis) + `}]}}`,
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "kafka",
+				Resource:                "orders",
+				Arn:                     "arn:aws:kafka:us-east-1:123:cluster/orders/abc",
+				RecordsNum:              3,
+				ApproxEventCreationTime: eventMillis,
+			},
+			messageIDs: []string{"m1", "m2"},
+		},
+		{
+			testname: "self managed kafka",
+			event: `{"eventSource": "SelfManagedKafka", "bootstrapServers": "broker:9092",
+				"records": {"orders-0": [{"topic": "orders", "partition": 0, "offset": 15, "timestamp": ` + fmt.Sprint(eventMillis) + `}]}}`,
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "kafka",
+				Resource:                "orders",
+				RecordsNum:              1,
+				ApproxEventCreationTime: eventMillis,
+			},
+		},
+		{
+			testname: "eventbridge",
+			event: mustMarshal(t, events.CloudWatchEvent{
+				ID:         "event-id",
+				DetailType: "OrderCreated",
+				Source:     "orders",
+				Time:       eventTime,
+				Resources:  []string{"arn:aws:events:us-east-1:123:rule/orders"},
+				Detail:     json.RawMessage(`{}`),
+			}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "eventBridge",
+				Resource:                "OrderCreated",
+				Arn:                     "arn:aws:events:us-east-1:123:rule/orders",
+				ApproxEventCreationTime: eventMillis,
+			},
+			messageIDs: []string{"event-id"},
+		},
+		{
+			testname: "cloudwatch logs",
+			event: mustMarshal(t, events.CloudwatchLogsEvent{AWSLogs: events.CloudwatchLogsRawData{
+				Data: cloudWatchLogsData(t, events.CloudwatchLogsData{
+					LogGroup:  "/aws/lambda/other",
+					LogEvents: []events.CloudwatchLogsLogEvent{{ID: "1", Timestamp: eventMillis}},
+				}),
+			}}),
+			expect: telemetry.TriggerInfo{
+				TriggeredBy:             "cloudwatch",
+				Resource:                "/aws/lambda/other",
+				RecordsNum:              1,
+				ApproxEventCreationTime: eventMillis,
+			},
+		},
+		{
+			testname: "step function",
+			event:    `{"input":1,"_lumigo":{"step_function_uid":"uid-1"}}`,
+			expect: telemetry.TriggerInfo{
+				TriggeredBy: "stepFunction",
+			},
+			messageIDs: []string{"uid-1"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			info, messageIDs, err := parseTrigger(tc.event)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expect, info)
+			assert.Equal(t, tc.messageIDs, messageIDs)
+		})
+	}
+}
+
+func TestParseTriggerInvalidCloudWatchLogs(t *testing.T) {
+	info, _, err := parseTrigger(`{"awslogs":{"data":"not base64"}}`)
+	assert.Error(t, err)
+	assert.Equal(t, "cloudwatch", info.TriggeredBy)
+}
+
+func TestTriggerAttributes(t *testing.T) {
+	attrs, err := TriggerAttributes(`{"key":"value"}`)
+	assert.NoError(t, err)
+	assert.Empty(t, attrs)
+
+	attrs, err = TriggerAttributes(`{"Records":[{"eventSource":"aws:sqs","messageId":"message-1","eventSourceARN":"arn:aws:sqs:us-east-1:123:queue"}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(attrs))
+	assert.Equal(t, telemetry.TriggerKey, string(attrs[0].Key))
+	assert.JSONEq(t, `{"triggeredBy":"sqs","arn":"arn:aws:sqs:us-east-1:123:queue","recordsNum":1}`, attrs[0].Value.AsString())
+	assert.Equal(t, telemetry.TriggerMessageIDsKey, string(attrs[1].Key))
+	assert.Equal(t, []string{"message-1"}, attrs[1].Value.AsStringSlice())
+}
@@ -0,0 +1,165 @@
+package lumigotracer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/google/uuid"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/transform"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+const (
+	// clientContextHeader is the header of the base64 encoded
+	// client context passed to the invoked lambda
+	clientContextHeader = "X-Amz-Client-Context"
+	// maxClientContextSize is the max size of the
+	// encoded client context accepted by Lambda
+	maxClientContextSize = 3583
+	// clientContextParentIDKey is the custom key of the client context
+	// with the ID of the span which invoked the lambda, it is also read
+	// as the Lumigo header of the events carrying a trace context
+	clientContextParentIDKey = "lumigo_parent_span_id"
+)
+
+// lambdaInvokeAttributes returns the function name, the qualifier and
+// the invocation type of a request to the Invoke API of Lambda
+func lambdaInvokeAttributes(req *http.Request) ([]attribute.KeyValue, bool) {
+	if req.Method != http.MethodPost {
+		return nil, false
+	}
+	functionName, ok := transform.LambdaInvokeFunction(req.URL.EscapedPath())
+	if !ok {
+		return nil, false
+	}
+	invocationType := req.Header.Get("X-Amz-Invocation-Type")
+	if invocationType == "" {
+		invocationType = "RequestResponse"
+	}
+	attrs := []attribute.KeyValue{
+		semconv.FaaSInvokedNameKey.String(functionName),
+		attribute.String(telemetry.LambdaInvocationTypeKey, invocationType),
+	}
+	if qualifier := req.URL.Query().Get("Qualifier"); qualifier != "" {
+		attrs = append(attrs, attribute.String(telemetry.LambdaQualifierKey, qualifier))
+	}
+	return attrs, true
+}
+
+// lambdaInvokeResponseAttributes returns the request ID of the invoked
+// lambda, its error if it failed and the tail of its logs if requested
+func lambdaInvokeResponseAttributes(resp *http.Response) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if requestID := resp.Header.Get("X-Amzn-Requestid"); requestID != "" {
+		attrs = append(attrs, attribute.String(telemetry.LambdaRequestIDKey, requestID))
+	}
+	if functionError := resp.Header.Get("X-Amz-Function-Error"); functionError != "" {
+		attrs = append(attrs, attribute.String(telemetry.LambdaFunctionErrorKey, functionError))
+	}
+	if logResult := resp.Header.Get("X-Amz-Log-Result"); logResult != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(logResult); err == nil {
+			logResult = string(decoded)
+		}
+		attrs = append(attrs, attribute.String(telemetry.LambdaLogResultKey, logResult))
+	}
+	return attrs
+}
+
+// LambdaClientContext adds to the stack of an AWS SDK client the
+// middleware passing the trace context and the ID of the span of the
+// invocations of the Invoke API of Lambda to the invoked lambdas, in
+// their client context. It is set before the request is signed, add
+// it to the APIOptions of the config of the client:
+//
+//	cfg.APIOptions = append(cfg.APIOptions, lumigotracer.LambdaClientContext)
+func LambdaClientContext(stack *middleware.Stack) error {
+	if _, ok := stack.Finalize.Get(signingMiddlewareID); !ok {
+		return stack.Finalize.Add(clientContextMiddleware{}, middleware.After)
+	}
+	return stack.Finalize.Insert(clientContextMiddleware{}, signingMiddlewareID, middleware.Before)
+}
+
+// signingMiddlewareID is the ID of the SigV4 signing
+// middleware of the AWS SDK clients
+const signingMiddlewareID = "Signing"
+
+// clientContextMiddleware sets the client context of the invocations
+// of the Invoke API of Lambda made within traced invocations
+type clientContextMiddleware struct{}
+
+func (clientContextMiddleware) ID() string {
+	return "LumigoClientContext"
+}
+
+func (clientContextMiddleware) HandleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	lt, ok := tracerFromContext(ctx)
+	if !ok || awsmiddleware.GetServiceID(ctx) != "Lambda" || awsmiddleware.GetOperationName(ctx) != "Invoke" {
+		return next.HandleFinalize(ctx, in)
+	}
+	req, ok := in.Request.(*smithyhttp.Request)
+	if !ok {
+		return next.HandleFinalize(ctx, in)
+	}
+	// the span of the request, started by the transport, takes the ID
+	// passed to the invoked lambda. The middleware runs for each retry.
+	spanID := uuid.New().String()
+	if lt.injectClientContext(ctx, req.Header, spanID) {
+		ctx = context.WithValue(ctx, invokeSpanIDKey{}, spanID)
+	}
+	return next.HandleFinalize(ctx, in)
+}
+
+// invokeSpanIDKey is the context key of the ID of the
+// span of an invocation of the Invoke API of Lambda
+type invokeSpanIDKey struct{}
+
+// invokeSpanID returns the ID of the span of the request of ctx,
+// set when the ID was passed in the client context of an invocation
+func invokeSpanID(ctx context.Context) (string, bool) {
+	spanID, ok := ctx.Value(invokeSpanIDKey{}).(string)
+	return spanID, ok
+}
+
+// injectClientContext passes the trace context and the ID of the span
+// to the invoked lambda in its client context, it returns true if it
+// was set. The client context set by the caller is left untouched.
+func (lt *Tracer) injectClientContext(ctx context.Context, header http.Header, spanID string) bool {
+	if header.Get(clientContextHeader) != "" {
+		return false
+	}
+	custom := propagation.MapCarrier{clientContextParentIDKey: spanID}
+	lt.propagator.Inject(ctx, custom)
+	encoded, err := json.Marshal(lambdacontext.ClientContext{Custom: custom})
+	if err != nil {
+		lt.logger.WithError(err).Error("failed to encode the client context")
+		return false
+	}
+	clientContext := base64.StdEncoding.EncodeToString(encoded)
+	if len(clientContext) > maxClientContextSize {
+		lt.logger.Warn("client context is too large, the invoked lambda isn't linked")
+		return false
+	}
+	header.Set(clientContextHeader, clientContext)
+	return true
+}
+
+// clientContextCarrier returns the custom values of the client
+// context the lambda was invoked with, if any
+func clientContextCarrier(ctx context.Context) propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	if lambdaCtx, ok := lambdacontext.FromContext(ctx); ok {
+		for key, value := range lambdaCtx.ClientContext.Custom {
+			carrier.Set(key, value)
+		}
+	}
+	return carrier
+}
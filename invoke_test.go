@@ -0,0 +1,190 @@
+package lumigotracer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type invokeTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupInvokeSuite(t *testing.T) {
+	suite.Run(t, &invokeTestSuite{})
+}
+
+func (s *invokeTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(s.T())
+}
+
+// fakeLambdaAPI serves the Invoke API of Lambda, the
+// invocations run the callee with the client context
+// of the request, as the Lambda runtime
+func (s *invokeTestSuite) fakeLambdaAPI(callee interface{}) *httptest.Server {
+	handler := reflect.ValueOf(callee)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lambdaCtx := mockLambdaContext
+		lambdaCtx.AwsRequestID = "callee-request-id"
+		if header := r.Header.Get("X-Amz-Client-Context"); header != "" {
+			// the client context must be set before the request is signed
+			assert.Contains(s.T(), r.Header.Get("Authorization"), "x-amz-client-context")
+			decoded, err := base64.StdEncoding.DecodeString(header)
+			assert.NoError(s.T(), err)
+			assert.NoError(s.T(), json.Unmarshal(decoded, &lambdaCtx.ClientContext))
+		}
+		payload, _ := io.ReadAll(r.Body)
+		ctx := lambdacontext.NewContext(context.Background(), &lambdaCtx)
+		results := handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(json.RawMessage(payload))})
+
+		w.Header().Set("X-Amzn-Requestid", lambdaCtx.AwsRequestID)
+		if r.Header.Get("X-Amz-Log-Type") == "Tail" {
+			w.Header().Set("X-Amz-Log-Result", base64.StdEncoding.EncodeToString([]byte("START RequestId: callee-request-id")))
+		}
+		if err, _ := results[1].Interface().(error); err != nil {
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+			_ = json.NewEncoder(w).Encode(map[string]string{"errorMessage": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(results[0].Interface())
+	}))
+}
+
+// invokeChain invokes the caller, which invokes the callee with a
+// Lambda client of the AWS SDK through the fake Lambda API, and returns
+// the spans of both lambdas
+func (s *invokeTestSuite) invokeChain(calleeFunc interface{}, modify func(*lambda.InvokeInput)) (spanContainer, spanContainer) {
+	callerDir, calleeDir := s.T().TempDir(), s.T().TempDir()
+	calleeTracer, err := New(WithToken("token"), WithSpansDir(calleeDir))
+	assert.NoError(s.T(), err)
+	ts := s.fakeLambdaAPI(calleeTracer.WrapHandler(calleeFunc))
+	defer ts.Close()
+
+	client := lambda.NewFromConfig(aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"}, nil
+		}),
+		HTTPClient: &http.Client{Transport: NewTransport(http.DefaultTransport)},
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: ts.URL}, nil
+		}),
+		APIOptions: []func(*middleware.Stack) error{LambdaClientContext},
+	})
+	callerTracer, err := New(WithToken("token"), WithSpansDir(callerDir))
+	assert.NoError(s.T(), err)
+	caller := reflect.ValueOf(callerTracer.WrapHandler(func(ctx context.Context, name string) (string, error) {
+		input := &lambda.InvokeInput{
+			FunctionName: aws.String("callee"),
+			Qualifier:    aws.String("live"),
+			Payload:      []byte(`"caller"`),
+		}
+		if modify != nil {
+			modify(input)
+		}
+		output, err := client.Invoke(ctx, input)
+		if err != nil {
+			return "", err
+		}
+		return string(output.Payload), nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	_ = caller.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+
+	callerSpans, err := readSpansFromDir(callerDir)
+	assert.NoError(s.T(), err)
+	calleeSpans, err := readSpansFromDir(calleeDir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, len(callerSpans.endFileSpans))
+	assert.Equal(s.T(), 1, len(calleeSpans.startFileSpans))
+	assert.Equal(s.T(), 1, len(calleeSpans.endFileSpans))
+	return callerSpans, calleeSpans
+}
+
+func (s *invokeTestSuite) TestInvokeLinksCallee() {
+	callerSpans, calleeSpans := s.invokeChain(func(ctx context.Context, name string) (string, error) {
+		return "Hello " + name, nil
+	}, func(input *lambda.InvokeInput) {
+		input.LogType = types.LogTypeTail
+	})
+
+	invokeSpan := callerSpans.endFileSpans[0]
+	assert.Equal(s.T(), "http", invokeSpan.SpanType)
+	assert.Equal(s.T(), &telemetry.LambdaInvoke{
+		FunctionName:   "callee",
+		Qualifier:      "live",
+		InvocationType: "RequestResponse",
+		RequestID:      "callee-request-id",
+		LogResult:      "START RequestId: callee-request-id",
+	}, invokeSpan.SpanInfo.LambdaInvoke)
+	assert.Equal(s.T(), telemetry.AwsServiceInfo{
+		AwsServiceName: "lambda",
+		AwsOperation:   "Invoke",
+		ResourceName:   "callee",
+	}, invokeSpan.SpanInfo.AwsServiceInfo)
+	assert.Equal(s.T(), "callee-request-id", invokeSpan.SpanInfo.MessageID)
+
+	assert.NotEmpty(s.T(), invokeSpan.ID)
+	assert.Equal(s.T(), "callee-request-id_started", calleeSpans.startFileSpans[0].ID)
+	assert.Equal(s.T(), invokeSpan.ID, calleeSpans.startFileSpans[0].ParentID)
+	assert.Equal(s.T(), "callee-request-id", calleeSpans.endFileSpans[0].ID)
+	assert.Equal(s.T(), invokeSpan.ID, calleeSpans.endFileSpans[0].ParentID)
+	assert.Equal(s.T(), invokeSpan.TransactionID, calleeSpans.endFileSpans[0].TransactionID)
+}
+
+func (s *invokeTestSuite) TestInvokeFunctionError() {
+	callerSpans, calleeSpans := s.invokeChain(func(ctx context.Context, name string) (string, error) {
+		return "", errors.New("callee failed")
+	}, nil)
+
+	invokeSpan := callerSpans.endFileSpans[0]
+	assert.Equal(s.T(), "Unhandled", invokeSpan.SpanInfo.LambdaInvoke.FunctionError)
+	assert.Empty(s.T(), invokeSpan.SpanInfo.LambdaInvoke.LogResult)
+	assert.NotNil(s.T(), calleeSpans.endFileSpans[0].SpanError)
+	assert.Equal(s.T(), invokeSpan.ID, calleeSpans.endFileSpans[0].ParentID)
+}
+
+func (s *invokeTestSuite) TestInvokeKeepsClientContext() {
+	clientContext := base64.StdEncoding.EncodeToString([]byte(`{"custom":{"tenant":"t-1"}}`))
+	_, calleeSpans := s.invokeChain(func(ctx context.Context, name string) (string, error) {
+		lambdaCtx, _ := lambdacontext.FromContext(ctx)
+		return lambdaCtx.ClientContext.Custom["tenant"], nil
+	}, func(input *lambda.InvokeInput) {
+		input.ClientContext = aws.String(clientContext)
+	})
+
+	end := calleeSpans.endFileSpans[0]
+	assert.Equal(s.T(), `"t-1"`, *end.LambdaResponse)
+	assert.Empty(s.T(), end.ParentID)
+}
+
+func TestLambdaInvokeAttributes(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://lambda.us-east-1.amazonaws.com/2015-03-31/functions/arn%3Aaws%3Alambda%3Aus-east-1%3A123%3Afunction%3Acallee/invocations", nil)
+	req.Header.Set("X-Amz-Invocation-Type", "Event")
+	attrs, ok := lambdaInvokeAttributes(req)
+	assert.True(t, ok)
+	assert.Equal(t, "arn:aws:lambda:us-east-1:123:function:callee", attrs[0].Value.AsString())
+	assert.Equal(t, "Event", attrs[1].Value.AsString())
+	assert.Equal(t, 2, len(attrs))
+
+	req = httptest.NewRequest(http.MethodGet, "https://lambda.us-east-1.amazonaws.com/2015-03-31/functions/callee/configuration", nil)
+	_, ok = lambdaInvokeAttributes(req)
+	assert.False(t, ok)
+}
@@ -0,0 +1,179 @@
+// Package kafka traces the messages sent and received with the writers
+// and the readers of kafka-go, the messages of traced invocations are
+// sent as kafka spans. The trace context is propagated in the headers
+// of the messages, with the Lumigo ID of the messages which links the
+// invocations triggered by MSK or self managed Kafka to their producer.
+//
+// The package name shadows github.com/segmentio/kafka-go, import it
+// with an alias if both are used in a file, e.g. lumigokafka.
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MessageWriter is implemented by *kafka.Writer
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// MessageReader is implemented by *kafka.Reader
+type MessageReader interface {
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+}
+
+// Writer traces the messages sent with a writer
+type Writer struct {
+	w MessageWriter
+}
+
+// NewWriter returns a Writer tracing the messages sent with w,
+// w is still used to configure and close the writer
+func NewWriter(w MessageWriter) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteMessages sends msgs with the writer, within a traced invocation
+// the messages are sent with the trace context in their headers. The
+// messages aren't modified, the headers are added to copies.
+func (w *Writer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if !instrumentation.IsTraced(ctx) || len(msgs) == 0 {
+		return w.w.WriteMessages(ctx, msgs...)
+	}
+	topic := msgs[0].Topic
+	if writer, ok := w.w.(*kafka.Writer); ok && writer.Topic != "" {
+		topic = writer.Topic
+	}
+	ctx, span := instrumentation.Start(ctx, topic+" send", telemetry.KafkaSpanType, trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+
+	sent := make([]kafka.Message, len(msgs))
+	messageIDs := make([]string, len(msgs))
+	for i, msg := range msgs {
+		messageIDs[i] = uuid.New().String()
+		msg.Headers = append([]kafka.Header(nil), msg.Headers...)
+		carrier := headersCarrier{headers: &msg.Headers}
+		instrumentation.Inject(ctx, carrier)
+		carrier.Set(telemetry.KafkaMessageIDHeader, messageIDs[i])
+		sent[i] = msg
+	}
+	span.SetAttributes(
+		semconv.MessagingSystemKey.String("kafka"),
+		semconv.MessagingDestinationKey.String(topic),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingOperationKey.String("send"),
+		attribute.Int(telemetry.KafkaMessageCountKey, len(msgs)),
+		attribute.StringSlice(telemetry.KafkaMessageIDsKey, messageIDs),
+	)
+
+	err := w.w.WriteMessages(ctx, sent...)
+	instrumentation.RecordError(span, err)
+	return err
+}
+
+// Reader traces the messages received with a reader
+type Reader struct {
+	r MessageReader
+}
+
+// NewReader returns a Reader tracing the messages received
+// with r, r is still used to commit the messages and to close
+// the reader
+func NewReader(r MessageReader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadMessage reads the next message with the reader, see
+// kafka.Reader.ReadMessage
+func (r *Reader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	return r.read(ctx, r.r.ReadMessage)
+}
+
+// FetchMessage fetches the next message with the reader, see
+// kafka.Reader.FetchMessage
+func (r *Reader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	return r.read(ctx, r.r.FetchMessage)
+}
+
+// read reads a message with read, within a traced invocation the
+// message is sent in a span linked to the span which sent it. The
+// span is dropped when ctx is done before a message is received.
+func (r *Reader) read(ctx context.Context, read func(context.Context) (kafka.Message, error)) (kafka.Message, error) {
+	if !instrumentation.IsTraced(ctx) {
+		return read(ctx)
+	}
+	started := trace.WithTimestamp(time.Now())
+	msg, err := read(ctx)
+	if err != nil && ctx.Err() != nil {
+		return msg, err
+	}
+
+	opts := []trace.SpanStartOption{started, trace.WithSpanKind(trace.SpanKindConsumer)}
+	carrier := headersCarrier{headers: &msg.Headers}
+	if producer := instrumentation.Extract(ctx, carrier); producer.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: producer}))
+	}
+	_, span := instrumentation.Start(ctx, msg.Topic+" receive", telemetry.KafkaSpanType, opts...)
+	defer span.End()
+	span.SetAttributes(
+		semconv.MessagingSystemKey.String("kafka"),
+		semconv.MessagingDestinationKey.String(msg.Topic),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingOperationReceive,
+	)
+	if err == nil {
+		span.SetAttributes(
+			semconv.MessagingKafkaPartitionKey.Int(msg.Partition),
+			attribute.Int64(telemetry.KafkaOffsetKey, msg.Offset),
+			attribute.Int(telemetry.KafkaMessageCountKey, 1),
+		)
+		if messageID := carrier.Get(telemetry.KafkaMessageIDHeader); messageID != "" {
+			span.SetAttributes(attribute.StringSlice(telemetry.KafkaMessageIDsKey, []string{messageID}))
+		}
+	}
+	instrumentation.RecordError(span, err)
+	return msg, err
+}
+
+// headersCarrier adapts the headers of a message
+// to propagation.TextMapCarrier
+type headersCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c headersCarrier) Get(key string) string {
+	for _, header := range *c.headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (c headersCarrier) Set(key string, value string) {
+	for i, header := range *c.headers {
+		if header.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headersCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, header := range *c.headers {
+		keys = append(keys, header.Key)
+	}
+	return keys
+}
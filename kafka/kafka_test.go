@@ -0,0 +1,216 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeBroker keeps the messages written to it
+// and returns them to its readers in order
+type fakeBroker struct {
+	messages []kafka.Message
+	err      error
+}
+
+func (b *fakeBroker) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if b.err != nil {
+		return b.err
+	}
+	for _, msg := range msgs {
+		msg.Offset = int64(len(b.messages))
+		b.messages = append(b.messages, msg)
+	}
+	return nil
+}
+
+func (b *fakeBroker) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return kafka.Message{}, err
+	}
+	if b.err != nil {
+		return kafka.Message{}, b.err
+	}
+	msg := b.messages[0]
+	b.messages = b.messages[1:]
+	return msg, nil
+}
+
+func (b *fakeBroker) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	return b.ReadMessage(ctx)
+}
+
+type kafkaTestSuite struct {
+	suite.Suite
+	recorder *tracetest.SpanRecorder
+	provider *sdktrace.TracerProvider
+	ctx      context.Context
+	broker   *fakeBroker
+}
+
+func TestSetupKafkaSuite(t *testing.T) {
+	suite.Run(t, &kafkaTestSuite{})
+}
+
+func (s *kafkaTestSuite) SetupTest() {
+	s.broker = &fakeBroker{}
+	s.recorder = tracetest.NewSpanRecorder()
+	s.provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.recorder))
+	s.ctx = s.invocationContext()
+}
+
+// invocationContext returns the context of a traced invocation
+func (s *kafkaTestSuite) invocationContext() context.Context {
+	ctx := lumigoctx.NewContext(context.Background(), &lumigoctx.LumigoContext{Propagator: propagation.TraceContext{}})
+	ctx, span := s.provider.Tracer("test").Start(ctx, "invocation")
+	s.T().Cleanup(func() { span.End() })
+	return ctx
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func (s *kafkaTestSuite) TestWriteMessages() {
+	msgs := []kafka.Message{
+		{Topic: "orders", Value: []byte("1"), Headers: []kafka.Header{{Key: "source", Value: []byte("test")}}},
+		{Topic: "orders", Value: []byte("2")},
+	}
+	assert.NoError(s.T(), NewWriter(s.broker).WriteMessages(s.ctx, msgs...))
+
+	assert.Equal(s.T(), 1, len(msgs[0].Headers))
+	assert.Empty(s.T(), msgs[1].Headers)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	span := spans[0]
+	assert.Equal(s.T(), "orders send", span.Name())
+	assert.Equal(s.T(), trace.SpanKindProducer, span.SpanKind())
+	attrs := attributes(span)
+	assert.Equal(s.T(), telemetry.KafkaSpanType, attrs[telemetry.SpanTypeKey].AsString())
+	assert.Equal(s.T(), "kafka", attrs["messaging.system"].AsString())
+	assert.Equal(s.T(), "orders", attrs["messaging.destination"].AsString())
+	assert.Equal(s.T(), "send", attrs["messaging.operation"].AsString())
+	assert.Equal(s.T(), int64(2), attrs[telemetry.KafkaMessageCountKey].AsInt64())
+	messageIDs := attrs[telemetry.KafkaMessageIDsKey].AsStringSlice()
+	assert.Equal(s.T(), 2, len(messageIDs))
+
+	traceparent := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	assert.Equal(s.T(), 2, len(s.broker.messages))
+	for i, msg := range s.broker.messages {
+		carrier := headersCarrier{headers: &msg.Headers}
+		assert.Equal(s.T(), traceparent, carrier.Get("traceparent"))
+		assert.Equal(s.T(), messageIDs[i], carrier.Get(telemetry.KafkaMessageIDHeader))
+	}
+	assert.Equal(s.T(), "test", headersCarrier{headers: &s.broker.messages[0].Headers}.Get("source"))
+}
+
+func (s *kafkaTestSuite) TestWriterTopic() {
+	writer := &kafka.Writer{Topic: "orders", Transport: &kafka.Transport{}}
+	ctx, cancel := context.WithCancel(s.ctx)
+	cancel()
+	assert.Error(s.T(), NewWriter(writer).WriteMessages(ctx, kafka.Message{Value: []byte("1")}))
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), "orders send", spans[0].Name())
+	assert.Equal(s.T(), "orders", attributes(spans[0])["messaging.destination"].AsString())
+}
+
+func (s *kafkaTestSuite) TestWriteError() {
+	s.broker.err = errors.New("leader not available")
+	err := NewWriter(s.broker).WriteMessages(s.ctx, kafka.Message{Topic: "orders"})
+	assert.Equal(s.T(), s.broker.err, err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), codes.Error, spans[0].Status().Code)
+	attrs := attributes(spans[0])
+	assert.True(s.T(), attrs["has_error"].AsBool())
+	assert.Equal(s.T(), "leader not available", attrs["error_message"].AsString())
+}
+
+func (s *kafkaTestSuite) TestReadMessage() {
+	assert.NoError(s.T(), NewWriter(s.broker).WriteMessages(s.ctx, kafka.Message{Topic: "orders", Partition: 2}))
+	producer := s.recorder.Ended()[0]
+
+	// the message is received by another invocation
+	ctx := s.invocationContext()
+	msg, err := NewReader(s.broker).ReadMessage(ctx)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "orders", msg.Topic)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 2, len(spans))
+	consumer := spans[1]
+	assert.Equal(s.T(), "orders receive", consumer.Name())
+	assert.Equal(s.T(), trace.SpanKindConsumer, consumer.SpanKind())
+	assert.Equal(s.T(), trace.SpanContextFromContext(ctx).SpanID(), consumer.Parent().SpanID())
+	assert.Equal(s.T(), 1, len(consumer.Links()))
+	assert.Equal(s.T(), producer.SpanContext().SpanID(), consumer.Links()[0].SpanContext.SpanID())
+	attrs := attributes(consumer)
+	assert.Equal(s.T(), telemetry.KafkaSpanType, attrs[telemetry.SpanTypeKey].AsString())
+	assert.Equal(s.T(), "receive", attrs["messaging.operation"].AsString())
+	assert.Equal(s.T(), "orders", attrs["messaging.destination"].AsString())
+	assert.Equal(s.T(), int64(2), attrs["messaging.kafka.partition"].AsInt64())
+	assert.Equal(s.T(), int64(0), attrs[telemetry.KafkaOffsetKey].AsInt64())
+	assert.Equal(s.T(), int64(1), attrs[telemetry.KafkaMessageCountKey].AsInt64())
+	assert.Equal(s.T(), attributes(producer)[telemetry.KafkaMessageIDsKey].AsStringSlice(), attrs[telemetry.KafkaMessageIDsKey].AsStringSlice())
+}
+
+func (s *kafkaTestSuite) TestFetchMessageWithoutTraceContext() {
+	s.broker.messages = []kafka.Message{{Topic: "orders", Offset: 15}}
+	_, err := NewReader(s.broker).FetchMessage(s.ctx)
+	assert.NoError(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Empty(s.T(), spans[0].Links())
+	attrs := attributes(spans[0])
+	assert.Equal(s.T(), int64(15), attrs[telemetry.KafkaOffsetKey].AsInt64())
+	_, hasMessageIDs := attrs[telemetry.KafkaMessageIDsKey]
+	assert.False(s.T(), hasMessageIDs)
+}
+
+func (s *kafkaTestSuite) TestReadError() {
+	s.broker.err = errors.New("group coordinator not available")
+	_, err := NewReader(s.broker).ReadMessage(s.ctx)
+	assert.Equal(s.T(), s.broker.err, err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), codes.Error, spans[0].Status().Code)
+}
+
+func (s *kafkaTestSuite) TestReadCanceled() {
+	ctx, cancel := context.WithCancel(s.ctx)
+	cancel()
+	_, err := NewReader(s.broker).ReadMessage(ctx)
+	assert.Equal(s.T(), context.Canceled, err)
+	assert.Empty(s.T(), s.recorder.Ended())
+}
+
+func (s *kafkaTestSuite) TestNotTraced() {
+	ctx := context.Background()
+	assert.NoError(s.T(), NewWriter(s.broker).WriteMessages(ctx, kafka.Message{Topic: "orders"}))
+	msg, err := NewReader(s.broker).ReadMessage(ctx)
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), msg.Headers)
+	assert.Empty(s.T(), s.recorder.Ended())
+}
@@ -0,0 +1,65 @@
+// Package logging correlates the logs of the application with the
+// Lumigo invocations, the loggers adapters add the transaction ID,
+// the AWS request ID and the span ID of the context to the logs.
+package logging
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/transform"
+)
+
+const (
+	// TransactionIDKey is the field of the Lumigo transaction ID
+	TransactionIDKey = "transactionId"
+	// AwsRequestIDKey is the field of the AWS request ID of the invocation
+	AwsRequestIDKey = "awsRequestId"
+	// SpanIDKey is the field of the ID of the current span, the manual
+	// span or the HTTP span of the context or else the function span
+	SpanIDKey = "spanId"
+)
+
+// field is a correlation field of a log
+type field struct {
+	key   string
+	value string
+}
+
+// Fields returns the correlation fields of the invocation traced
+// with ctx, there are none outside of a traced invocation
+func Fields(ctx context.Context) map[string]string {
+	var fields map[string]string
+	for _, f := range correlationFields(ctx) {
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		fields[f.key] = f.value
+	}
+	return fields
+}
+
+func correlationFields(ctx context.Context) []field {
+	if ctx == nil {
+		return nil
+	}
+	if _, ok := lumigoctx.FromContext(ctx); !ok {
+		return nil
+	}
+	var fields []field
+	if transactionID := transform.TransactionID(); transactionID != "" {
+		fields = append(fields, field{TransactionIDKey, transactionID})
+	}
+	spanID, _ := lumigoctx.SpanIDFromContext(ctx)
+	if lambdaCtx, ok := lambdacontext.FromContext(ctx); ok {
+		fields = append(fields, field{AwsRequestIDKey, lambdaCtx.AwsRequestID})
+		if spanID == "" {
+			spanID = lambdaCtx.AwsRequestID
+		}
+	}
+	if spanID != "" {
+		fields = append(fields, field{SpanIDKey, spanID})
+	}
+	return fields
+}
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/stretchr/testify/assert"
+)
+
+// tracedContext returns the context of a traced invocation
+func tracedContext(t *testing.T) context.Context {
+	testenv.SetLambdaEnv(t)
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-id"})
+	return lumigoctx.NewContext(ctx, &lumigoctx.LumigoContext{})
+}
+
+func TestFields(t *testing.T) {
+	ctx := tracedContext(t)
+	assert.Equal(t, map[string]string{
+		"transactionId": "bd862e3fe1be46a994272793",
+		"awsRequestId":  "request-id",
+		"spanId":        "request-id",
+	}, Fields(ctx))
+
+	ctx = lumigoctx.WithSpanID(ctx, "span-id")
+	assert.Equal(t, map[string]string{
+		"transactionId": "bd862e3fe1be46a994272793",
+		"awsRequestId":  "request-id",
+		"spanId":        "span-id",
+	}, Fields(ctx))
+}
+
+func TestFieldsOutsideInvocation(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-id"})
+	assert.Nil(t, Fields(ctx))
+	assert.Nil(t, Fields(nil)) // nolint
+}
@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// LogrusHook adds the correlation fields to the entries
+// logged with a context, e.g. logger.WithContext(ctx).Info()
+type LogrusHook struct{}
+
+// NewLogrusHook returns a hook to add to the logrus loggers
+func NewLogrusHook() *LogrusHook {
+	return &LogrusHook{}
+}
+
+// Levels returns all the levels, every entry is correlated
+func (h *LogrusHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire adds the correlation fields of the context of the entry
+func (h *LogrusHook) Fire(entry *logrus.Entry) error {
+	for _, f := range correlationFields(entry.Context) {
+		entry.Data[f.key] = f.value
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogrusHook(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.AddHook(NewLogrusHook())
+
+	logger.WithContext(tracedContext(t)).WithField("user", "u-1").Info("message")
+	assert.Equal(t, logrus.Fields{
+		"user":          "u-1",
+		"transactionId": "bd862e3fe1be46a994272793",
+		"awsRequestId":  "request-id",
+		"spanId":        "request-id",
+	}, hook.LastEntry().Data)
+
+	logger.WithContext(context.Background()).Info("message")
+	assert.Empty(t, hook.LastEntry().Data)
+
+	logger.Info("message")
+	assert.Empty(t, hook.LastEntry().Data)
+}
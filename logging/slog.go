@@ -0,0 +1,35 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogHandler adds the correlation fields to the records
+type slogHandler struct {
+	slog.Handler
+}
+
+// NewSlogHandler wraps handler to correlate the records logged
+// with a context, e.g. logger.InfoContext(ctx, "message")
+func NewSlogHandler(handler slog.Handler) slog.Handler {
+	return slogHandler{Handler: handler}
+}
+
+func (h slogHandler) Handle(ctx context.Context, record slog.Record) error {
+	for _, f := range correlationFields(ctx) {
+		record.AddAttrs(slog.String(f.key, f.value))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return slogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h slogHandler) WithGroup(name string) slog.Handler {
+	return slogHandler{Handler: h.Handler.WithGroup(name)}
+}
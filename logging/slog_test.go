@@ -0,0 +1,33 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewSlogHandler(slog.NewJSONHandler(&buf, nil))).With("user", "u-1")
+
+	logger.InfoContext(tracedContext(t), "message")
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "u-1", record["user"])
+	assert.Equal(t, "bd862e3fe1be46a994272793", record["transactionId"])
+	assert.Equal(t, "request-id", record["awsRequestId"])
+	assert.Equal(t, "request-id", record["spanId"])
+
+	buf.Reset()
+	logger.InfoContext(context.Background(), "message")
+	record = nil
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.NotContains(t, record, "transactionId")
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// zapContextKey is the key of the field carrying the context
+const zapContextKey = "lumigo.context"
+
+// ZapContext returns a field carrying ctx, the cores wrapped with
+// NewZapCore replace it with the correlation fields of ctx. Other
+// cores skip it.
+func ZapContext(ctx context.Context) zap.Field {
+	return zap.Field{Key: zapContextKey, Type: zapcore.SkipType, Interface: ctx}
+}
+
+// zapCore replaces the context fields with the correlation fields
+type zapCore struct {
+	zapcore.Core
+}
+
+// NewZapCore wraps core to correlate the entries logged with
+// a ZapContext field, e.g. logger.Info("message", logging.ZapContext(ctx))
+func NewZapCore(core zapcore.Core) zapcore.Core {
+	return zapCore{Core: core}
+}
+
+func (c zapCore) With(fields []zapcore.Field) zapcore.Core {
+	return zapCore{Core: c.Core.With(correlateZapFields(fields))}
+}
+
+func (c zapCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c zapCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(entry, correlateZapFields(fields))
+}
+
+// correlateZapFields replaces the context fields
+// with the correlation fields of their context
+func correlateZapFields(fields []zapcore.Field) []zapcore.Field {
+	correlated := make([]zapcore.Field, 0, len(fields))
+	for _, f := range fields {
+		ctx, ok := f.Interface.(context.Context)
+		if f.Key != zapContextKey || !ok {
+			correlated = append(correlated, f)
+			continue
+		}
+		for _, cf := range correlationFields(ctx) {
+			correlated = append(correlated, zap.String(cf.key, cf.value))
+		}
+	}
+	return correlated
+}
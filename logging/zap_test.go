@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestZapCore(t *testing.T) {
+	observed, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(NewZapCore(observed))
+
+	logger.Info("message", ZapContext(tracedContext(t)), zap.String("user", "u-1"))
+	logger.With(ZapContext(tracedContext(t))).Info("message")
+	logger.Info("message", ZapContext(context.Background()))
+	logger.Debug("message", ZapContext(tracedContext(t)))
+
+	entries := logs.AllUntimed()
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, map[string]interface{}{
+		"user":          "u-1",
+		"transactionId": "bd862e3fe1be46a994272793",
+		"awsRequestId":  "request-id",
+		"spanId":        "request-id",
+	}, entries[0].ContextMap())
+	assert.Equal(t, map[string]interface{}{
+		"transactionId": "bd862e3fe1be46a994272793",
+		"awsRequestId":  "request-id",
+		"spanId":        "request-id",
+	}, entries[1].ContextMap())
+	assert.Empty(t, entries[2].ContextMap())
+}
+
+func TestZapContextWithoutCore(t *testing.T) {
+	observed, logs := observer.New(zapcore.InfoLevel)
+	zap.New(observed).Info("message", ZapContext(tracedContext(t)))
+	assert.Empty(t, logs.AllUntimed()[0].ContextMap())
+}
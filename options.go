@@ -0,0 +1,173 @@
+package lumigotracer
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Option configures a Tracer created by New
+type Option func(*Tracer)
+
+// WithToken sets the token used to interact with Lumigo API
+func WithToken(token string) Option {
+	return func(t *Tracer) {
+		t.cfg.Token = token
+	}
+}
+
+// WithDebug enables the debug logs of the tracer
+func WithDebug(debug bool) Option {
+	return func(t *Tracer) {
+		t.cfg.debug = debug
+	}
+}
+
+// WithMaxEntrySize sets the maximum size for request body, request header,
+// response body and response header
+func WithMaxEntrySize(size int) Option {
+	return func(t *Tracer) {
+		t.cfg.MaxEntrySize = size
+	}
+}
+
+// WithMaxSizeForRequest sets the maximum amount of bytes to be sent to the edge
+func WithMaxSizeForRequest(size int) Option {
+	return func(t *Tracer) {
+		t.cfg.MaxSizeForRequest = size
+	}
+}
+
+// WithIsEnabled sets the function consulted on every invocation
+// to decide if the invocation is traced
+func WithIsEnabled(isEnabled func(ctx context.Context) bool) Option {
+	return func(t *Tracer) {
+		t.cfg.IsEnabled = isEnabled
+	}
+}
+
+// WithExporter replaces the Lumigo spans exporter with the given one
+func WithExporter(exporter sdktrace.SpanExporter) Option {
+	return func(t *Tracer) {
+		t.exporter = exporter
+	}
+}
+
+// WithHTTPExporter posts the spans straight to the Lumigo edge
+// instead of writing them for the Lumigo extension, an empty
+// URL keeps the edge of the lambda region
+func WithHTTPExporter(edgeURL string) Option {
+	return func(t *Tracer) {
+		t.cfg.exporters = addExporter(removeExporter(t.cfg.exporters, exporterFile), exporterHTTP)
+		if edgeURL != "" {
+			t.cfg.edgeURL = edgeURL
+		}
+	}
+}
+
+// WithOTLPExporter sends the spans to an OpenTelemetry collector over
+// OTLP/HTTP, in addition to the Lumigo exporters. The endpoint is the
+// collector URL, e.g. http://localhost:4318, an empty endpoint keeps
+// the one of the OTEL_EXPORTER_OTLP_ environment variables
+func WithOTLPExporter(endpoint string) Option {
+	return func(t *Tracer) {
+		t.cfg.exporters = addExporter(t.cfg.exporters, exporterOTLP)
+		if endpoint != "" {
+			t.cfg.otlpEndpoint = endpoint
+		}
+	}
+}
+
+// WithOTLPProtocol sets the payload format of the otlp
+// exporter, http/protobuf or http/json
+func WithOTLPProtocol(protocol string) Option {
+	return func(t *Tracer) {
+		t.cfg.otlpProtocol = protocol
+	}
+}
+
+// WithEdgeGzip sets whether the spans sent to the edge are compressed
+func WithEdgeGzip(gzip bool) Option {
+	return func(t *Tracer) {
+		t.cfg.edgeGzip = gzip
+	}
+}
+
+// WithEdgeTimeout sets the timeout of a single request to the edge,
+// requests never outlive the lambda deadline
+func WithEdgeTimeout(timeout time.Duration) Option {
+	return func(t *Tracer) {
+		t.cfg.edgeTimeout = timeout
+	}
+}
+
+// WithEdgeRetries sets the number of retries of a failed request to the edge
+func WithEdgeRetries(retries int) Option {
+	return func(t *Tracer) {
+		t.cfg.edgeRetries = retries
+	}
+}
+
+// WithSecretMaskingRegex sets the regexes matching the keys whose
+// values are masked in the events, the responses, the environment
+// variables and the HTTP headers and bodies. A key is masked when a
+// regex matches it entirely, ignoring case.
+func WithSecretMaskingRegex(regexes ...string) Option {
+	return func(t *Tracer) {
+		t.cfg.secretMaskingRegexes = regexes
+	}
+}
+
+// WithTimeoutTimerBuffer sets how long before the lambda deadline a
+// still running invocation is reported as timed out, with the spans
+// collected so far. Zero switches the timeout detection off.
+func WithTimeoutTimerBuffer(buffer time.Duration) Option {
+	return func(t *Tracer) {
+		t.cfg.timeoutTimerBuffer = buffer
+	}
+}
+
+// WithSpansDir sets the directory the spans files are written to
+func WithSpansDir(dir string) Option {
+	return func(t *Tracer) {
+		t.spansDir = dir
+	}
+}
+
+// WithLogger sets the logger of the tracer, the debug
+// option has no effect on a logger set this way
+func WithLogger(logger logrus.FieldLogger) Option {
+	return func(t *Tracer) {
+		t.logger = logger
+	}
+}
+
+// WithPropagator sets the propagator used to inject the
+// trace context into outgoing requests
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(t *Tracer) {
+		t.propagator = propagator
+	}
+}
+
+func addExporter(exporters []string, name string) []string {
+	for _, exporter := range exporters {
+		if exporter == name {
+			return exporters
+		}
+	}
+	return append(exporters, name)
+}
+
+func removeExporter(exporters []string, name string) []string {
+	var kept []string
+	for _, exporter := range exporters {
+		if exporter != name {
+			kept = append(kept, exporter)
+		}
+	}
+	return kept
+}
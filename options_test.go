@@ -0,0 +1,283 @@
+package lumigotracer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type optionsTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupOptionsSuite(t *testing.T) {
+	suite.Run(t, &optionsTestSuite{})
+}
+
+func (o *optionsTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(o.T())
+}
+
+func (o *optionsTestSuite) TearDownTest() {
+	_ = os.Unsetenv("LUMIGO_TRACER_TOKEN")
+	_ = os.Unsetenv("LUMIGO_DEFAULT_MAX_ENTRY_SIZE")
+	_ = os.Unsetenv("LUMIGO_MAX_SIZE_FOR_REQUEST")
+}
+
+func (o *optionsTestSuite) TestNewMissingToken() {
+	t, err := New()
+	assert.Equal(o.T(), ErrInvalidToken, err)
+	assert.Nil(o.T(), t)
+}
+
+func (o *optionsTestSuite) TestNewDefaults() {
+	_ = os.Setenv("LUMIGO_TRACER_TOKEN", "token")
+
+	t, err := New()
+	assert.NoError(o.T(), err)
+	assert.Equal(o.T(), "token", t.cfg.Token)
+	assert.Equal(o.T(), true, t.cfg.enabled)
+	assert.Equal(o.T(), false, t.cfg.debug)
+	assert.Equal(o.T(), 2048, t.cfg.MaxEntrySize)
+	assert.Equal(o.T(), 512000, t.cfg.MaxSizeForRequest)
+	assert.Equal(o.T(), SPANS_DIR, t.spansDir)
+	assert.Nil(o.T(), t.exporter)
+	assert.Equal(o.T(), propagation.TraceContext{}, t.propagator)
+	assert.NotNil(o.T(), t.logger)
+}
+
+func (o *optionsTestSuite) TestNewOptionsOverrideEnv() {
+	_ = os.Setenv("LUMIGO_TRACER_TOKEN", "env-token")
+	_ = os.Setenv("LUMIGO_DEFAULT_MAX_ENTRY_SIZE", "42")
+	_ = os.Setenv("LUMIGO_MAX_SIZE_FOR_REQUEST", "42")
+	exporter := tracetest.NewInMemoryExporter()
+	logger := logrus.New()
+	propagator := propagation.Baggage{}
+
+	t, err := New(
+		WithToken("token"),
+		WithDebug(true),
+		WithMaxEntrySize(10),
+		WithExporter(exporter),
+		WithSpansDir("/tmp/lumigo-test"),
+		WithLogger(logger),
+		WithPropagator(propagator),
+	)
+	assert.NoError(o.T(), err)
+	assert.Equal(o.T(), "token", t.cfg.Token)
+	assert.Equal(o.T(), true, t.cfg.debug)
+	assert.Equal(o.T(), 10, t.cfg.MaxEntrySize)
+	assert.Equal(o.T(), 42, t.cfg.MaxSizeForRequest)
+	assert.Equal(o.T(), exporter, t.exporter)
+	assert.Equal(o.T(), "/tmp/lumigo-test", t.spansDir)
+	assert.Equal(o.T(), logger, t.logger)
+	assert.Equal(o.T(), propagator, t.propagator)
+}
+
+func (o *optionsTestSuite) TestWithExporter() {
+	exporter := tracetest.NewInMemoryExporter()
+	t, err := New(WithToken("token"), WithExporter(exporter))
+	assert.NoError(o.T(), err)
+
+	handler := reflect.ValueOf(t.WrapHandler(func(ctx context.Context, name string) (string, error) {
+		return "Hello " + name, nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	for i := 0; i < 2; i++ {
+		_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+	}
+
+	// the start and end spans of both invocations
+	assert.Equal(o.T(), 4, len(exporter.GetSpans()))
+}
+
+func (o *optionsTestSuite) TestTracersDoNotShareConfig() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello, world!"))
+	}))
+	defer ts.Close()
+
+	handlerToWrap := func(ctx context.Context, name string) (string, error) {
+		c := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL, bytes.NewBufferString(strings.Repeat("a", 100)))
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		return "Hello " + name, nil
+	}
+
+	firstDir, secondDir := o.T().TempDir(), o.T().TempDir()
+	first, err := New(WithToken("first"), WithSpansDir(firstDir), WithMaxEntrySize(10))
+	assert.NoError(o.T(), err)
+	second, err := New(WithToken("second"), WithSpansDir(secondDir), WithMaxEntrySize(20))
+	assert.NoError(o.T(), err)
+	firstHandler := reflect.ValueOf(first.WrapHandler(handlerToWrap))
+	secondHandler := reflect.ValueOf(second.WrapHandler(handlerToWrap))
+
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	_ = firstHandler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+	_ = secondHandler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+
+	for _, tc := range []struct {
+		dir      string
+		token    string
+		bodySize int
+	}{
+		{dir: firstDir, token: "first", bodySize: 10},
+		{dir: secondDir, token: "second", bodySize: 20},
+	} {
+		files, err := ioutil.ReadDir(tc.dir)
+		assert.NoError(o.T(), err)
+		assert.Equal(o.T(), 2, len(files))
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), "_end") {
+				continue
+			}
+			var spans []struct {
+				Token string `json:"token"`
+				Type  string `json:"type"`
+				Info  struct {
+					HttpInfo struct {
+						Request struct {
+							Body string `json:"body"`
+						} `json:"request"`
+					} `json:"httpInfo"`
+				} `json:"info"`
+			}
+			content, err := ioutil.ReadFile(tc.dir + "/" + file.Name())
+			assert.NoError(o.T(), err)
+			assert.NoError(o.T(), json.Unmarshal(content, &spans))
+			assert.Equal(o.T(), 2, len(spans))
+			assert.Equal(o.T(), "http", spans[0].Type)
+			assert.Equal(o.T(), tc.token, spans[0].Token)
+			assert.Equal(o.T(), tc.bodySize, len(spans[0].Info.HttpInfo.Request.Body))
+		}
+	}
+}
+
+func (o *optionsTestSuite) TestTracersConcurrently() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(o.T(), r.Header.Get("traceparent"))
+		_, _ = w.Write([]byte("Hello, world!"))
+	}))
+	defer ts.Close()
+
+	handlerToWrap := func(ctx context.Context, name string) (string, error) {
+		c := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		return "Hello " + name, nil
+	}
+
+	var wg sync.WaitGroup
+	dirs := make([]string, 8)
+	for i := range dirs {
+		dirs[i] = o.T().TempDir()
+		wg.Add(1)
+		go func(dir string) {
+			defer wg.Done()
+			t, err := New(WithToken("token"), WithSpansDir(dir))
+			if !assert.NoError(o.T(), err) {
+				return
+			}
+			handler := reflect.ValueOf(t.WrapHandler(handlerToWrap))
+			inputPayload, _ := json.Marshal("test")
+			ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+			for j := 0; j < 3; j++ {
+				_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+			}
+		}(dirs[i])
+	}
+	wg.Wait()
+
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		assert.NoError(o.T(), err)
+		assert.Equal(o.T(), 6, len(files))
+	}
+}
+
+// TestTracersFromOptionsInParallel runs tracers configured only by
+// their options, without a lambda environment, in parallel subtests
+func TestTracersFromOptionsInParallel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello, world!"))
+	}))
+	t.Cleanup(ts.Close)
+
+	handlerToWrap := func(ctx context.Context, name string) (string, error) {
+		c := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL, bytes.NewBufferString(strings.Repeat("a", 100)))
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		return "Hello " + name, nil
+	}
+
+	for i := 1; i <= 8; i++ {
+		token, bodySize := fmt.Sprintf("token-%d", i), 10*i
+		t.Run(token, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			lt, err := New(WithToken(token), WithSpansDir(dir), WithMaxEntrySize(bodySize))
+			if !assert.NoError(t, err) {
+				return
+			}
+			handler := reflect.ValueOf(lt.WrapHandler(handlerToWrap))
+			inputPayload, _ := json.Marshal("test")
+			ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+			for j := 0; j < 3; j++ {
+				_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+			}
+
+			files, err := ioutil.ReadDir(dir)
+			assert.NoError(t, err)
+			assert.Equal(t, 6, len(files))
+			for _, file := range files {
+				if !strings.HasSuffix(file.Name(), "_end") {
+					continue
+				}
+				var spans []telemetry.Span
+				content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+				assert.NoError(t, err)
+				assert.NoError(t, json.Unmarshal(content, &spans))
+				if !assert.Equal(t, 2, len(spans)) {
+					continue
+				}
+				assert.Equal(t, "http", spans[0].SpanType)
+				assert.Equal(t, token, spans[0].Token)
+				assert.Equal(t, bodySize, len(spans[0].SpanInfo.HttpInfo.Request.Body))
+				assert.Equal(t, token, spans[1].Token)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package lumigotracer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// otlpProtocolProtobuf and otlpProtocolJSON are the payload
+	// formats of the otlp exporter, named after the values of
+	// OTEL_EXPORTER_OTLP_PROTOCOL
+	otlpProtocolProtobuf = "http/protobuf"
+	otlpProtocolJSON     = "http/json"
+
+	// otlpTracesPath is the path of the traces on a collector
+	otlpTracesPath = "/v1/traces"
+
+	// otlpDefaultEndpoint is the collector of the json payloads
+	// when neither its URL nor the OTEL_EXPORTER_OTLP_ variables
+	// are set
+	otlpDefaultEndpoint = "http://localhost:4318"
+
+	// otlpTimeout is the timeout of a request to the collector
+	otlpTimeout = 10 * time.Second
+)
+
+// newOTLPExporter creates an exporter sending the spans to an
+// OpenTelemetry collector over OTLP/HTTP, with protobuf or json
+// payloads
+func newOTLPExporter(ctx context.Context, t *Tracer) (sdktrace.SpanExporter, error) {
+	if t.cfg.otlpProtocol == otlpProtocolJSON {
+		client, err := newOTLPJSONClient(t.cfg.otlpEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := otlptrace.New(ctx, client)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create otlp exporter")
+		}
+		return otlpExporter{exporter}, nil
+	}
+	opts, err := otlpOptions(t.cfg.otlpEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create otlp exporter")
+	}
+	return otlpExporter{exporter}, nil
+}
+
+// otlpExporter removes the Lumigo token from the resource of
+// the spans it exports, the collectors aren't Lumigo's
+type otlpExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	stripped := make([]sdktrace.ReadOnlySpan, 0, len(spans))
+	for _, span := range spans {
+		stub := tracetest.SpanStubFromReadOnlySpan(span)
+		attrs, _ := span.Resource().Set().Filter(func(kv attribute.KeyValue) bool {
+			return kv.Key != lumigoTokenKey
+		})
+		stub.Resource = resource.NewWithAttributes(span.Resource().SchemaURL(), attrs.ToSlice()...)
+		stripped = append(stripped, stub.Snapshot())
+	}
+	return e.SpanExporter.ExportSpans(ctx, stripped)
+}
+
+// otlpOptions translates the collector URL to the options
+// of the otlp exporter, an empty URL has no options
+func otlpOptions(endpoint string) ([]otlptracehttp.Option, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("invalid otlp endpoint: %s", endpoint)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	return opts, nil
+}
+
+// otlpJSONClient sends the spans to a collector with the json
+// payloads of OTLP/HTTP, which otlptracehttp doesn't support
+type otlpJSONClient struct {
+	url    string
+	client *http.Client
+}
+
+// newOTLPJSONClient creates a client sending the spans to the
+// collector URL, or to the one of the OTEL_EXPORTER_OTLP_ variables
+// when it is empty. The traces path is added to a URL without path.
+func newOTLPJSONClient(endpoint string) (*otlpJSONClient, error) {
+	tracesURL := endpoint
+	if tracesURL == "" {
+		tracesURL = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	}
+	if tracesURL == "" {
+		// the generic endpoint is a base URL, the signal is its path
+		tracesURL = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if tracesURL == "" {
+			tracesURL = otlpDefaultEndpoint
+		}
+		tracesURL = strings.TrimSuffix(tracesURL, "/") + otlpTracesPath
+	}
+	u, err := url.Parse(tracesURL)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("invalid otlp endpoint: %s", tracesURL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+	return &otlpJSONClient{url: u.String(), client: &http.Client{Timeout: otlpTimeout}}, nil
+}
+
+func (c *otlpJSONClient) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *otlpJSONClient) Stop(ctx context.Context) error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *otlpJSONClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	body, err := marshalOTLPJSON(&collectortracepb.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode otlp spans")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to send spans to %s", c.url)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("failed to send spans to %s: %s", c.url, resp.Status)
+	}
+	return nil
+}
+
+// otlpIDKeys are the keys of the trace and span IDs, which are
+// hex encoded in the json payloads of OTLP instead of base64
+var otlpIDKeys = map[string]bool{"traceId": true, "spanId": true, "parentSpanId": true}
+
+// marshalOTLPJSON encodes msg as the json payloads of OTLP: the
+// protobuf json mapping with the enums as integers and hex IDs
+func marshalOTLPJSON(msg proto.Message) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	if err := hexEncodeIDs(payload); err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+// hexEncodeIDs re-encodes the base64 IDs of the payload in hex
+func hexEncodeIDs(value interface{}) error {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if id, ok := field.(string); ok && otlpIDKeys[key] {
+				raw, err := base64.StdEncoding.DecodeString(id)
+				if err != nil {
+					return errors.Wrapf(err, "invalid %s", key)
+				}
+				value[key] = hex.EncodeToString(raw)
+			} else if err := hexEncodeIDs(field); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if err := hexEncodeIDs(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package lumigotracer
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/go-redis/redis/v8"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	lumigoredis "github.com/lumigo-io/lumigo-go-tracer/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type otlpTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupOTLPSuite(t *testing.T) {
+	suite.Run(t, &otlpTestSuite{})
+}
+
+func (o *otlpTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(o.T())
+}
+
+func (o *otlpTestSuite) TearDownTest() {
+	_ = os.Unsetenv("LUMIGO_EXPORTER")
+	_ = os.Unsetenv("LUMIGO_OTLP_ENDPOINT")
+}
+
+// collectorStub is an httptest stand-in for an OpenTelemetry collector
+type collectorStub struct {
+	*httptest.Server
+	mu           sync.Mutex
+	paths        []string
+	spans        []string
+	traceIDs     []string
+	resourceKeys []string
+	bodies       []string
+}
+
+// otlpJSONRequest is the part of the json payloads
+// of OTLP checked by the tests
+type otlpJSONRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key string `json:"key"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		InstrumentationLibrarySpans []struct {
+			Spans []struct {
+				Name    string `json:"name"`
+				TraceID string `json:"traceId"`
+				Kind    int    `json:"kind"`
+			} `json:"spans"`
+		} `json:"instrumentationLibrarySpans"`
+	} `json:"resourceSpans"`
+}
+
+func newCollectorStub(t *testing.T) *collectorStub {
+	stub := &collectorStub{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.paths = append(stub.paths, r.URL.Path)
+		stub.bodies = append(stub.bodies, string(body))
+		if r.Header.Get("Content-Type") == "application/json" {
+			var req otlpJSONRequest
+			assert.NoError(t, json.Unmarshal(body, &req))
+			for _, rs := range req.ResourceSpans {
+				for _, attr := range rs.Resource.Attributes {
+					stub.resourceKeys = append(stub.resourceKeys, attr.Key)
+				}
+				for _, ils := range rs.InstrumentationLibrarySpans {
+					for _, span := range ils.Spans {
+						stub.spans = append(stub.spans, span.Name)
+						stub.traceIDs = append(stub.traceIDs, span.TraceID)
+					}
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		var req collectortracepb.ExportTraceServiceRequest
+		assert.NoError(t, proto.Unmarshal(body, &req))
+		for _, rs := range req.ResourceSpans {
+			for _, attr := range rs.GetResource().GetAttributes() {
+				stub.resourceKeys = append(stub.resourceKeys, attr.Key)
+			}
+			for _, ils := range rs.InstrumentationLibrarySpans {
+				for _, span := range ils.Spans {
+					stub.spans = append(stub.spans, span.Name)
+					stub.traceIDs = append(stub.traceIDs, hex.EncodeToString(span.TraceId))
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (c *collectorStub) received() ([]string, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paths, c.spans
+}
+
+func (o *otlpTestSuite) invoke(lt *Tracer) {
+	handler := reflect.ValueOf(lt.WrapHandler(func(name string) (string, error) {
+		return "Hello " + name, nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+}
+
+func (o *otlpTestSuite) TestFanOutFileAndOTLP() {
+	collector := newCollectorStub(o.T())
+	dir := o.T().TempDir()
+
+	lt, err := New(WithToken("token"), WithSpansDir(dir), WithOTLPExporter(collector.URL))
+	assert.NoError(o.T(), err)
+	o.invoke(lt)
+
+	paths, spans := collector.received()
+	assert.NotEmpty(o.T(), paths)
+	for _, path := range paths {
+		assert.Equal(o.T(), "/v1/traces", path)
+	}
+	assert.ElementsMatch(o.T(), []string{"LumigoParentSpan", "testFunction"}, spans)
+
+	container, err := readSpansFromDir(dir)
+	assert.NoError(o.T(), err)
+	assert.Equal(o.T(), 1, len(container.startFileSpans))
+	assert.Equal(o.T(), 1, len(container.endFileSpans))
+	assert.Equal(o.T(), mockLambdaContext.AwsRequestID, container.endFileSpans[0].ID)
+	assert.Equal(o.T(), "token", container.endFileSpans[0].Token)
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.NotEmpty(o.T(), collector.resourceKeys)
+	assert.NotContains(o.T(), collector.resourceKeys, lumigoTokenKey)
+}
+
+func (o *otlpTestSuite) TestOTLPNeverSeesRedisSecrets() {
+	collector := newCollectorStub(o.T())
+	server := miniredis.RunT(o.T())
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	client.AddHook(lumigoredis.NewHook())
+	defer client.Close()
+
+	lt, err := New(WithToken("token"), WithSpansDir(o.T().TempDir()), WithOTLPExporter(collector.URL), WithOTLPProtocol(otlpProtocolJSON))
+	assert.NoError(o.T(), err)
+	handler := reflect.ValueOf(lt.WrapHandler(func(ctx context.Context) error {
+		if err := client.Set(ctx, "api_token", "raw-token-value", 0).Err(); err != nil {
+			return err
+		}
+		return client.HSet(ctx, "user:1", "password", "raw-password-value").Err()
+	}))
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	results := handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(json.RawMessage(`{}`))})
+	assert.Nil(o.T(), results[1].Interface())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Contains(o.T(), collector.spans, "set")
+	assert.Contains(o.T(), collector.spans, "hset")
+	for _, body := range collector.bodies {
+		assert.NotContains(o.T(), body, "raw-token-value")
+		assert.NotContains(o.T(), body, "raw-password-value")
+	}
+}
+
+func (o *otlpTestSuite) TestOTLPOnlyFromEnv() {
+	collector := newCollectorStub(o.T())
+	_ = os.Setenv("LUMIGO_EXPORTER", "otlp")
+	_ = os.Setenv("LUMIGO_OTLP_ENDPOINT", collector.URL+"/custom/traces")
+	dir := o.T().TempDir()
+
+	lt, err := New(WithToken("token"), WithSpansDir(dir))
+	assert.NoError(o.T(), err)
+	o.invoke(lt)
+
+	paths, spans := collector.received()
+	assert.NotEmpty(o.T(), paths)
+	for _, path := range paths {
+		assert.Equal(o.T(), "/custom/traces", path)
+	}
+	assert.Equal(o.T(), 2, len(spans))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(o.T(), err)
+	assert.Equal(o.T(), 0, len(files))
+}
+
+func (o *otlpTestSuite) TestOTLPJSON() {
+	collector := newCollectorStub(o.T())
+	_ = os.Setenv("LUMIGO_OTLP_PROTOCOL", "http/json")
+	defer os.Unsetenv("LUMIGO_OTLP_PROTOCOL")
+	dir := o.T().TempDir()
+
+	lt, err := New(WithToken("token"), WithSpansDir(dir), WithOTLPExporter(collector.URL))
+	assert.NoError(o.T(), err)
+	o.invoke(lt)
+
+	paths, spans := collector.received()
+	assert.NotEmpty(o.T(), paths)
+	for _, path := range paths {
+		assert.Equal(o.T(), "/v1/traces", path)
+	}
+	assert.ElementsMatch(o.T(), []string{"LumigoParentSpan", "testFunction"}, spans)
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.NotEmpty(o.T(), collector.resourceKeys)
+	assert.NotContains(o.T(), collector.resourceKeys, lumigoTokenKey)
+	for _, traceID := range collector.traceIDs {
+		_, err := hex.DecodeString(traceID)
+		assert.NoError(o.T(), err)
+		assert.Equal(o.T(), 32, len(traceID))
+	}
+}
+
+func (o *otlpTestSuite) TestMarshalOTLPJSON() {
+	req := &collectortracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		InstrumentationLibrarySpans: []*tracepb.InstrumentationLibrarySpans{{
+			Spans: []*tracepb.Span{{
+				TraceId:           []byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+				SpanId:            []byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+				Name:              "span",
+				Kind:              tracepb.Span_SPAN_KIND_CLIENT,
+				StartTimeUnixNano: 1544712660000000000,
+			}},
+		}},
+	}}}
+	data, err := marshalOTLPJSON(req)
+	assert.NoError(o.T(), err)
+	assert.JSONEq(o.T(), `{"resourceSpans": [{"instrumentationLibrarySpans": [{"spans": [{
+		"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId": "00f067aa0ba902b7",
+		"name": "span",
+		"kind": 3,
+		"startTimeUnixNano": "1544712660000000000"
+	}]}]}]}`, string(data))
+}
+
+func (o *otlpTestSuite) TestOTLPJSONEndpoint() {
+	testcases := []struct {
+		testname string
+		endpoint string
+		env      map[string]string
+		expected string
+	}{
+		{testname: "collector url", endpoint: "http://collector:4318", expected: "http://collector:4318/v1/traces"},
+		{testname: "collector url with path", endpoint: "http://collector:4318/custom/traces", expected: "http://collector:4318/custom/traces"},
+		{testname: "otel endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318/"}, expected: "http://collector:4318/v1/traces"},
+		{testname: "otel traces endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/traces"}, expected: "http://collector:4318/traces"},
+		{testname: "default", expected: "http://localhost:4318/v1/traces"},
+	}
+	for _, tc := range testcases {
+		o.Run(tc.testname, func() {
+			for key, value := range tc.env {
+				_ = os.Setenv(key, value)
+				defer os.Unsetenv(key)
+			}
+			client, err := newOTLPJSONClient(tc.endpoint)
+			assert.NoError(o.T(), err)
+			assert.Equal(o.T(), tc.expected, client.url)
+		})
+	}
+
+	_, err := newOTLPJSONClient("localhost:4318")
+	assert.Error(o.T(), err)
+}
+
+func (o *otlpTestSuite) TestInvalidOTLPProtocol() {
+	_, err := New(WithToken("token"), WithOTLPExporter(""), WithOTLPProtocol("grpc"))
+	assert.Equal(o.T(), ErrInvalidOTLPProtocol, err)
+
+	// the protocol is ignored without the otlp exporter
+	_, err = New(WithToken("token"), WithOTLPProtocol("grpc"))
+	assert.NoError(o.T(), err)
+}
+
+func (o *otlpTestSuite) TestInvalidOTLPEndpoint() {
+	_, err := otlpOptions("localhost:4318")
+	assert.Error(o.T(), err)
+
+	opts, err := otlpOptions("")
+	assert.NoError(o.T(), err)
+	assert.Empty(o.T(), opts)
+}
+
+type failingExporter struct{}
+
+func (failingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	return errors.New("collector unreachable")
+}
+
+func (failingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (o *otlpTestSuite) TestMultiExporterKeepsExportingOnFailure() {
+	exporter := tracetest.NewInMemoryExporter()
+	multi := multiExporter{failingExporter{}, exporter}
+
+	spans := tracetest.SpanStubs{{Name: "span"}}.Snapshots()
+	err := multi.ExportSpans(context.Background(), spans)
+	assert.Error(o.T(), err)
+	assert.Contains(o.T(), err.Error(), "collector unreachable")
+	assert.Equal(o.T(), 1, len(exporter.GetSpans()))
+	assert.NoError(o.T(), multi.Shutdown(context.Background()))
+}
@@ -0,0 +1,96 @@
+package lumigotracer
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// eventCarrier returns the carrier of the trace context propagated
+// with the event: the headers of an API Gateway or ALB request, the
+// message attributes of the first SQS or SNS record or the detail
+// of an EventBridge event, or the headers of the first record of a
+// Kafka event. The keys are lower case, the carrier holds the Lumigo
+// parent span ID header along with the headers of the propagator.
+func eventCarrier(payload []byte) propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	var kafkaEvent events.KafkaEvent
+	if err := json.Unmarshal(payload, &kafkaEvent); err == nil && telemetry.IsKafkaEventSource(kafkaEvent.EventSource) {
+		// the records of Kafka events are grouped by topic
+		// and partition, they don't share the shape below
+		keys := make([]string, 0, len(kafkaEvent.Records))
+		for key := range kafkaEvent.Records {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if records := kafkaEvent.Records[key]; len(records) > 0 {
+				for _, header := range records[0].Headers {
+					for name, value := range header {
+						carrier.Set(strings.ToLower(name), string(value))
+					}
+				}
+				break
+			}
+		}
+		return carrier
+	}
+	var event struct {
+		Headers           map[string]string   `json:"headers"`
+		MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+		Records           []struct {
+			MessageAttributes map[string]messageAttribute `json:"messageAttributes"`
+			Sns               struct {
+				MessageAttributes map[string]messageAttribute `json:"MessageAttributes"`
+			} `json:"Sns"`
+		} `json:"Records"`
+		DetailType string                 `json:"detail-type"`
+		Detail     map[string]interface{} `json:"detail"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return carrier
+	}
+	for key, values := range event.MultiValueHeaders {
+		if len(values) > 0 {
+			carrier.Set(strings.ToLower(key), values[0])
+		}
+	}
+	for key, value := range event.Headers {
+		carrier.Set(strings.ToLower(key), value)
+	}
+	if len(event.Records) > 0 {
+		record := event.Records[0]
+		for key, attribute := range record.MessageAttributes {
+			carrier.Set(strings.ToLower(key), attribute.value())
+		}
+		for key, attribute := range record.Sns.MessageAttributes {
+			carrier.Set(strings.ToLower(key), attribute.value())
+		}
+	}
+	if event.DetailType != "" {
+		for key, value := range event.Detail {
+			if value, ok := value.(string); ok {
+				carrier.Set(strings.ToLower(key), value)
+			}
+		}
+	}
+	return carrier
+}
+
+// messageAttribute is a string message attribute of an SQS
+// message, with a stringValue, or of an SNS message, with a Value
+type messageAttribute struct {
+	StringValue string `json:"stringValue"`
+	Value       string `json:"Value"`
+}
+
+func (a messageAttribute) value() string {
+	if a.StringValue != "" {
+		return a.StringValue
+	}
+	return a.Value
+}
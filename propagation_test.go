@@ -0,0 +1,237 @@
+package lumigotracer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTraceparent          = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	testUnsampledTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+	testTracestate           = "vendor=value"
+)
+
+type propagationTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupPropagationSuite(t *testing.T) {
+	suite.Run(t, &propagationTestSuite{})
+}
+
+func (s *propagationTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(s.T())
+}
+
+func (s *propagationTestSuite) TestEventCarrier() {
+	testcases := []struct {
+		testname string
+		event    string
+		expected propagation.MapCarrier
+	}{
+		{
+			testname: "api gateway v1",
+			event: `{"resource": "/orders", "httpMethod": "POST",
+				"headers": {"Traceparent": "` + testTraceparent + `", "Tracestate": "` + testTracestate + `"},
+				"multiValueHeaders": {"Traceparent": ["` + testTraceparent + `"], "Tracestate": ["` + testTracestate + `"]}}`,
+			expected: propagation.MapCarrier{"traceparent": testTraceparent, "tracestate": testTracestate},
+		},
+		{
+			testname: "api gateway v2",
+			event: `{"version": "2.0", "routeKey": "POST /orders",
+				"headers": {"traceparent": "` + testTraceparent + `", "tracestate": "` + testTracestate + `"}}`,
+			expected: propagation.MapCarrier{"traceparent": testTraceparent, "tracestate": testTracestate},
+		},
+		{
+			testname: "lumigo header",
+			event: `{"resource": "/orders", "httpMethod": "POST",
+				"headers": {"Lumigo_Parent_Span_Id": "lumigo-span-1", "traceparent": "` + testTraceparent + `"}}`,
+			expected: propagation.MapCarrier{"lumigo_parent_span_id": "lumigo-span-1", "traceparent": testTraceparent},
+		},
+		{
+			testname: "sqs lumigo header",
+			event: `{"Records": [{"eventSource": "aws:sqs", "messageAttributes": {
+				"lumigo_parent_span_id": {"stringValue": "lumigo-span-1", "dataType": "String"}}}]}`,
+			expected: propagation.MapCarrier{"lumigo_parent_span_id": "lumigo-span-1"},
+		},
+		{
+			testname: "alb with multi value headers",
+			event: `{"requestContext": {"elb": {"targetGroupArn": "arn"}},
+				"multiValueHeaders": {"traceparent": ["` + testTraceparent + `", "ignored"]}}`,
+			expected: propagation.MapCarrier{"traceparent": testTraceparent},
+		},
+		{
+			testname: "sqs",
+			event: `{"Records": [{"eventSource": "aws:sqs", "messageAttributes": {
+				"traceparent": {"stringValue": "` + testTraceparent + `", "dataType": "String"}}}]}`,
+			expected: propagation.MapCarrier{"traceparent": testTraceparent},
+		},
+		{
+			testname: "sns",
+			event: `{"Records": [{"EventSource": "aws:sns", "Sns": {"MessageAttributes": {
+				"traceparent": {"Type": "String", "Value": "` + testTraceparent + `"}}}}]}`,
+			expected: propagation.MapCarrier{"traceparent": testTraceparent},
+		},
+		{
+			testname: "eventbridge",
+			event: `{"detail-type": "OrderCreated", "source": "orders",
+				"detail": {"traceparent": "` + testTraceparent + `", "order": {"id": 1}}}`,
+			expected: propagation.MapCarrier{"traceparent": testTraceparent},
+		},
+		{
+			testname: "msk",
+			event: `{"eventSource": "aws:kafka", "records": {
+				"orders-0": [{"topic": "orders", "headers": [{"Traceparent": ` + kafkaHeaderValue(testTraceparent) + `}]}]}}`,
+			expected: propagation.MapCarrier{"traceparent": testTraceparent},
+		},
+		{
+			testname: "detail without detail-type",
+			event:    `{"detail": {"traceparent": "` + testTraceparent + `"}}`,
+			expected: propagation.MapCarrier{},
+		},
+		{
+			testname: "not an object",
+			event:    `"test"`,
+			expected: propagation.MapCarrier{},
+		},
+	}
+
+	for _, tc := range testcases {
+		s.Run(tc.testname, func() {
+			assert.Equal(s.T(), tc.expected, eventCarrier([]byte(tc.event)))
+		})
+	}
+}
+
+// kafkaHeaderValue returns value as in the headers
+// of Kafka events, an array of bytes
+func kafkaHeaderValue(value string) string {
+	bytes := make([]string, len(value))
+	for i := range value {
+		bytes[i] = fmt.Sprint(value[i])
+	}
+	return "[" + strings.Join(bytes, ",") + "]"
+}
+
+func (s *propagationTestSuite) TestInboundTraceContext() {
+	testcases := []struct {
+		testname         string
+		event            string
+		expectedParentID string
+	}{
+		{
+			testname:         "propagated trace context",
+			event:            `{"headers": {"traceparent": "` + testTraceparent + `"}}`,
+			expectedParentID: "00f067aa0ba902b7",
+		},
+		{
+			testname:         "lumigo header",
+			event:            `{"headers": {"traceparent": "` + testTraceparent + `", "lumigo_parent_span_id": "lumigo-span-1"}}`,
+			expectedParentID: "lumigo-span-1",
+		},
+		{
+			testname: "no trace context",
+			event:    `{"headers": {"host": "example.com"}}`,
+		},
+	}
+
+	for _, tc := range testcases {
+		s.Run(tc.testname, func() {
+			dir := s.T().TempDir()
+			lt, err := New(WithToken("token"), WithSpansDir(dir))
+			assert.NoError(s.T(), err)
+			var handlerTraceID trace.TraceID
+			handler := reflect.ValueOf(lt.WrapHandler(func(ctx context.Context, event json.RawMessage) (string, error) {
+				handlerTraceID = trace.SpanContextFromContext(ctx).TraceID()
+				return "ok", nil
+			}))
+			ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+			_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(json.RawMessage(tc.event))})
+
+			if tc.expectedParentID != "" {
+				assert.Equal(s.T(), "4bf92f3577b34da6a3ce929d0e0e4736", handlerTraceID.String())
+			} else {
+				assert.NotEqual(s.T(), "4bf92f3577b34da6a3ce929d0e0e4736", handlerTraceID.String())
+			}
+			container, err := readSpansFromDir(dir)
+			assert.NoError(s.T(), err)
+			assert.Equal(s.T(), 1, len(container.startFileSpans))
+			assert.Equal(s.T(), 1, len(container.endFileSpans))
+			assert.Equal(s.T(), tc.expectedParentID, container.startFileSpans[0].ParentID)
+			assert.Equal(s.T(), tc.expectedParentID, container.endFileSpans[0].ParentID)
+		})
+	}
+}
+
+func (s *propagationTestSuite) TestUnsampledTraceContext() {
+	testcases := []struct {
+		testname string
+		event    string
+	}{
+		{
+			testname: "api gateway v1",
+			event: `{"resource": "/orders", "httpMethod": "POST",
+				"headers": {"Traceparent": "` + testUnsampledTraceparent + `"}}`,
+		},
+		{
+			testname: "api gateway v2",
+			event:    `{"version": "2.0", "routeKey": "POST /orders", "headers": {"traceparent": "` + testUnsampledTraceparent + `"}}`,
+		},
+		{
+			testname: "alb with multi value headers",
+			event: `{"requestContext": {"elb": {"targetGroupArn": "arn"}},
+				"multiValueHeaders": {"traceparent": ["` + testUnsampledTraceparent + `"]}}`,
+		},
+		{
+			testname: "sqs",
+			event: `{"Records": [{"eventSource": "aws:sqs", "messageAttributes": {
+				"traceparent": {"stringValue": "` + testUnsampledTraceparent + `", "dataType": "String"}}}]}`,
+		},
+		{
+			testname: "sns",
+			event: `{"Records": [{"EventSource": "aws:sns", "Sns": {"MessageAttributes": {
+				"traceparent": {"Type": "String", "Value": "` + testUnsampledTraceparent + `"}}}}]}`,
+		},
+		{
+			testname: "eventbridge",
+			event: `{"detail-type": "OrderCreated", "source": "orders",
+				"detail": {"traceparent": "` + testUnsampledTraceparent + `"}}`,
+		},
+		{
+			testname: "msk",
+			event: `{"eventSource": "aws:kafka", "records": {
+				"orders-0": [{"topic": "orders", "headers": [{"traceparent": ` + kafkaHeaderValue(testUnsampledTraceparent) + `}]}]}}`,
+		},
+	}
+
+	for _, tc := range testcases {
+		s.Run(tc.testname, func() {
+			dir := s.T().TempDir()
+			lt, err := New(WithToken("token"), WithSpansDir(dir))
+			assert.NoError(s.T(), err)
+			handler := reflect.ValueOf(lt.WrapHandler(func(ctx context.Context, event json.RawMessage) (string, error) {
+				return "ok", nil
+			}))
+			ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+			_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(json.RawMessage(tc.event))})
+
+			container, err := readSpansFromDir(dir)
+			assert.NoError(s.T(), err)
+			assert.Equal(s.T(), 1, len(container.startFileSpans))
+			if assert.Equal(s.T(), 1, len(container.endFileSpans)) {
+				assert.Equal(s.T(), "00f067aa0ba902b7", container.endFileSpans[0].ParentID)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Package redis traces the commands of go-redis clients, the commands
+// run within traced invocations are sent as redis spans.
+//
+// The package name shadows go-redis, import it with an alias
+// if both are used in a file, e.g. lumigoredis.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxCommands bounds the commands of a pipeline sent with its span
+const maxCommands = 100
+
+// multiKeyCommands are the commands whose arguments are all keys
+var multiKeyCommands = map[string]bool{
+	"del": true, "exists": true, "mget": true, "touch": true, "unlink": true, "watch": true,
+}
+
+// keyValueCommands are the commands whose arguments are pairs of a key and a value
+var keyValueCommands = map[string]bool{
+	"mset": true, "msetnx": true,
+}
+
+// keylessCommands are the commands whose arguments aren't keys
+var keylessCommands = map[string]bool{
+	"client": true, "command": true, "dbsize": true, "discard": true, "echo": true, "exec": true,
+	"flushall": true, "flushdb": true, "info": true, "keys": true, "multi": true, "ping": true,
+	"quit": true, "scan": true, "select": true, "time": true,
+}
+
+// credentialCommands are the commands whose arguments may hold
+// passwords, e.g. AUTH or CONFIG SET requirepass, they aren't sent
+var credentialCommands = map[string]bool{
+	"acl": true, "auth": true, "config": true, "hello": true,
+}
+
+// spanKey is the key for the span of a command in Contexts
+type spanKey struct{}
+
+// Hook traces the commands and the pipelines of a client
+type Hook struct{}
+
+var _ redis.Hook = Hook{}
+
+// NewHook returns a hook tracing the commands of a
+// client, add it to the client with AddHook
+func NewHook() Hook {
+	return Hook{}
+}
+
+func (Hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return startSpan(ctx, cmd.Name(), []redis.Cmder{cmd}), nil
+}
+
+func (Hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(ctx, []redis.Cmder{cmd})
+	return nil
+}
+
+func (Hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx = startSpan(ctx, "pipeline", cmds)
+	if span, ok := ctx.Value(spanKey{}).(trace.Span); ok {
+		span.SetAttributes(attribute.Int(telemetry.RedisPipelineSizeKey, len(cmds)))
+	}
+	return ctx, nil
+}
+
+func (Hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	endSpan(ctx, cmds)
+	return nil
+}
+
+// command is a command sent with a redis span
+type command struct {
+	Name string        `json:"name"`
+	Keys []string      `json:"keys,omitempty"`
+	Args []interface{} `json:"args,omitempty"`
+}
+
+// startSpan starts the span of the commands, if
+// they are run within a traced invocation
+func startSpan(ctx context.Context, operation string, cmds []redis.Cmder) context.Context {
+	if !instrumentation.IsTraced(ctx) {
+		return ctx
+	}
+	spanCtx, span := instrumentation.Start(ctx, operation, telemetry.RedisSpanType, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		semconv.DBSystemRedis,
+		semconv.DBOperationKey.String(operation),
+	)
+	commands := make([]command, 0, len(cmds))
+	for i, cmd := range cmds {
+		if i == maxCommands {
+			break
+		}
+		commands = append(commands, newCommand(ctx, cmd))
+	}
+	if encoded, err := json.Marshal(commands); err == nil {
+		span.SetAttributes(attribute.String(telemetry.RedisCommandsKey, string(encoded)))
+	}
+	return context.WithValue(spanCtx, spanKey{}, span)
+}
+
+// endSpan ends the span of the commands with the size of their
+// results and their first error, a missing key isn't an error
+func endSpan(ctx context.Context, cmds []redis.Cmder) {
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+	var size int64
+	var err error
+	for _, cmd := range cmds {
+		size += resultSize(cmd)
+		if cmdErr := cmd.Err(); err == nil && cmdErr != nil && cmdErr != redis.Nil {
+			err = cmdErr
+		}
+	}
+	span.SetAttributes(attribute.Int64(telemetry.RedisResultSizeKey, size))
+	instrumentation.RecordError(span, err)
+}
+
+// newCommand splits the arguments of a command into its keys and
+// values, the values are masked and truncated when they are captured
+func newCommand(ctx context.Context, cmd redis.Cmder) command {
+	c := command{Name: cmd.Name()}
+	args := cmd.Args()
+	if len(args) < 2 || credentialCommands[c.Name] {
+		return c
+	}
+	args = args[1:]
+	switch {
+	case keylessCommands[c.Name]:
+		c.Args = formatArgs(args)
+	case multiKeyCommands[c.Name]:
+		c.Keys = formatKeys(args)
+	case keyValueCommands[c.Name]:
+		for i, arg := range args {
+			if i%2 == 0 {
+				c.Keys = append(c.Keys, fmt.Sprint(arg))
+			} else {
+				c.Args = append(c.Args, formatArg(arg))
+			}
+		}
+	default:
+		c.Keys = formatKeys(args[:1])
+		c.Args = formatArgs(args[1:])
+	}
+	c.Args = maskArgs(ctx, c)
+	return c
+}
+
+// maskArgs returns the arguments of the command with the secrets
+// masked: all of them if a key is secret, else the values of the
+// secret hash fields and the secrets of the JSON values. The
+// arguments past the max entry size are dropped.
+func maskArgs(ctx context.Context, c command) []interface{} {
+	masker := instrumentation.Masker(ctx)
+	for _, key := range c.Keys {
+		if masker.IsSecret(key) && len(c.Args) > 0 {
+			return []interface{}{masking.MaskedValue}
+		}
+	}
+	if telemetry.RedisHashCommands[c.Name] {
+		for i := 0; i+1 < len(c.Args); i += 2 {
+			if field, ok := c.Args[i].(string); ok && masker.IsSecret(field) {
+				c.Args[i+1] = masking.MaskedValue
+			}
+		}
+	}
+	maxEntrySize := instrumentation.MaxEntrySize(ctx)
+	size := 0
+	for i, arg := range c.Args {
+		if value, ok := arg.(string); ok {
+			arg = instrumentation.Limit(ctx, masker.MaskJSON(value))
+			c.Args[i] = arg
+		}
+		size += len(fmt.Sprint(arg))
+		if maxEntrySize > 0 && size > maxEntrySize {
+			return c.Args[:i+1]
+		}
+	}
+	return c.Args
+}
+
+func formatKeys(args []interface{}) []string {
+	keys := make([]string, 0, len(args))
+	for _, arg := range args {
+		keys = append(keys, fmt.Sprint(formatArg(arg)))
+	}
+	return keys
+}
+
+func formatArgs(args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	formatted := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		formatted = append(formatted, formatArg(arg))
+	}
+	return formatted
+}
+
+func formatArg(arg interface{}) interface{} {
+	if b, ok := arg.([]byte); ok {
+		return string(b)
+	}
+	return arg
+}
+
+// resultSize returns the length of the string result of a command,
+// the number of elements of its list or map result or else 1
+func resultSize(cmd redis.Cmder) int64 {
+	if cmd.Err() != nil {
+		return 0
+	}
+	val := reflect.ValueOf(cmd).MethodByName("Val")
+	if !val.IsValid() || val.Type().NumIn() != 0 || val.Type().NumOut() == 0 {
+		return 0
+	}
+	result := val.Call(nil)[0]
+	switch result.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return int64(result.Len())
+	}
+	return 1
+}
@@ -0,0 +1,196 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type redisTestSuite struct {
+	suite.Suite
+	recorder *tracetest.SpanRecorder
+	ctx      context.Context
+	server   *miniredis.Miniredis
+	client   *redis.Client
+}
+
+func TestSetupRedisSuite(t *testing.T) {
+	suite.Run(t, &redisTestSuite{})
+}
+
+func (s *redisTestSuite) SetupTest() {
+	s.server = miniredis.RunT(s.T())
+	s.client = redis.NewClient(&redis.Options{Addr: s.server.Addr()})
+	s.client.AddHook(NewHook())
+	s.T().Cleanup(func() { s.client.Close() })
+
+	s.recorder = tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.recorder))
+	ctx := lumigoctx.NewContext(context.Background(), &lumigoctx.LumigoContext{})
+	ctx, span := provider.Tracer("test").Start(ctx, "invocation")
+	s.T().Cleanup(func() { span.End() })
+	s.ctx = ctx
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func commands(t *testing.T, span sdktrace.ReadOnlySpan) []command {
+	var cmds []command
+	assert.NoError(t, json.Unmarshal([]byte(attributes(span)[telemetry.RedisCommandsKey].AsString()), &cmds))
+	return cmds
+}
+
+func (s *redisTestSuite) TestCommand() {
+	assert.NoError(s.T(), s.client.Set(s.ctx, "user:1", []byte("John"), time.Minute).Err())
+	name, err := s.client.Get(s.ctx, "user:1").Result()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "John", name)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 2, len(spans))
+	set, get := spans[0], spans[1]
+	assert.Equal(s.T(), "set", set.Name())
+	assert.Equal(s.T(), s.recorder.Started()[0].SpanContext().SpanID(), set.Parent().SpanID())
+	attrs := attributes(set)
+	assert.Equal(s.T(), telemetry.RedisSpanType, attrs[telemetry.SpanTypeKey].AsString())
+	assert.Equal(s.T(), "redis", attrs["db.system"].AsString())
+	assert.Equal(s.T(), "set", attrs["db.operation"].AsString())
+	assert.Equal(s.T(), int64(2), attrs[telemetry.RedisResultSizeKey].AsInt64())
+	assert.Equal(s.T(), []command{
+		{Name: "set", Keys: []string{"user:1"}, Args: []interface{}{"John", "ex", float64(60)}},
+	}, commands(s.T(), set))
+	_, isPipeline := attrs[telemetry.RedisPipelineSizeKey]
+	assert.False(s.T(), isPipeline)
+
+	assert.Equal(s.T(), []command{{Name: "get", Keys: []string{"user:1"}}}, commands(s.T(), get))
+	assert.Equal(s.T(), int64(4), attributes(get)[telemetry.RedisResultSizeKey].AsInt64())
+	assert.Equal(s.T(), codes.Unset, get.Status().Code)
+}
+
+func (s *redisTestSuite) TestMultiKeyCommands() {
+	assert.NoError(s.T(), s.client.MSet(s.ctx, "a", "1", "b", "2").Err())
+	values, err := s.client.MGet(s.ctx, "a", "b", "c").Result()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []interface{}{"1", "2", nil}, values)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 2, len(spans))
+	assert.Equal(s.T(), []command{
+		{Name: "mset", Keys: []string{"a", "b"}, Args: []interface{}{"1", "2"}},
+	}, commands(s.T(), spans[0]))
+	assert.Equal(s.T(), []command{
+		{Name: "mget", Keys: []string{"a", "b", "c"}},
+	}, commands(s.T(), spans[1]))
+	assert.Equal(s.T(), int64(3), attributes(spans[1])[telemetry.RedisResultSizeKey].AsInt64())
+}
+
+func (s *redisTestSuite) TestCredentialCommands() {
+	s.server.RequireAuth("secret")
+	assert.NoError(s.T(), s.client.Do(s.ctx, "auth", "secret").Err())
+	_ = s.client.ConfigSet(s.ctx, "requirepass", "secret").Err()
+	_ = s.client.Do(s.ctx, "hello", "2", "auth", "default", "secret").Err()
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 3, len(spans))
+	assert.Equal(s.T(), []command{{Name: "auth"}}, commands(s.T(), spans[0]))
+	assert.Equal(s.T(), []command{{Name: "config"}}, commands(s.T(), spans[1]))
+	assert.Equal(s.T(), []command{{Name: "hello"}}, commands(s.T(), spans[2]))
+}
+
+func (s *redisTestSuite) TestArgsMaskedAtCapture() {
+	lc, _ := lumigoctx.FromContext(s.ctx)
+	lc.MaxEntrySize = 16
+	assert.NoError(s.T(), s.client.Set(s.ctx, "api_token", "abcd", 0).Err())
+	assert.NoError(s.T(), s.client.HSet(s.ctx, "user:1", "name", "John", "password", "1234").Err())
+	assert.NoError(s.T(), s.client.Set(s.ctx, "user:2", `{"password":"1234"}`, 0).Err())
+	assert.NoError(s.T(), s.client.RPush(s.ctx, "list", strings.Repeat("v", 10), strings.Repeat("w", 10), "x").Err())
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 4, len(spans))
+	assert.Equal(s.T(), []command{
+		{Name: "set", Keys: []string{"api_token"}, Args: []interface{}{"****"}},
+	}, commands(s.T(), spans[0]))
+	assert.Equal(s.T(), []command{
+		{Name: "hset", Keys: []string{"user:1"}, Args: []interface{}{"name", "John", "password", "****"}},
+	}, commands(s.T(), spans[1]))
+	assert.Equal(s.T(), []command{
+		{Name: "set", Keys: []string{"user:2"}, Args: []interface{}{`{"password":"***`}},
+	}, commands(s.T(), spans[2]))
+	assert.Equal(s.T(), []command{
+		{Name: "rpush", Keys: []string{"list"}, Args: []interface{}{strings.Repeat("v", 10), strings.Repeat("w", 10)}},
+	}, commands(s.T(), spans[3]))
+}
+
+func (s *redisTestSuite) TestMissingKeyIsNotAnError() {
+	err := s.client.Get(s.ctx, "missing").Err()
+	assert.Equal(s.T(), redis.Nil, err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), codes.Unset, spans[0].Status().Code)
+	assert.Equal(s.T(), int64(0), attributes(spans[0])[telemetry.RedisResultSizeKey].AsInt64())
+}
+
+func (s *redisTestSuite) TestError() {
+	assert.NoError(s.T(), s.client.Set(s.ctx, "name", "John", 0).Err())
+	err := s.client.Incr(s.ctx, "name").Err()
+	assert.Error(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 2, len(spans))
+	attrs := attributes(spans[1])
+	assert.Equal(s.T(), codes.Error, spans[1].Status().Code)
+	assert.True(s.T(), attrs["has_error"].AsBool())
+	assert.Equal(s.T(), err.Error(), attrs["error_message"].AsString())
+}
+
+func (s *redisTestSuite) TestPipeline() {
+	pipe := s.client.Pipeline()
+	pipe.Set(s.ctx, "a", "1", 0)
+	pipe.Incr(s.ctx, "counter")
+	pipe.Get(s.ctx, "a")
+	_, err := pipe.Exec(s.ctx)
+	assert.NoError(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), "pipeline", spans[0].Name())
+	attrs := attributes(spans[0])
+	assert.Equal(s.T(), int64(3), attrs[telemetry.RedisPipelineSizeKey].AsInt64())
+	// OK, 1 and 1
+	assert.Equal(s.T(), int64(4), attrs[telemetry.RedisResultSizeKey].AsInt64())
+	assert.Equal(s.T(), []command{
+		{Name: "set", Keys: []string{"a"}, Args: []interface{}{"1"}},
+		{Name: "incr", Keys: []string{"counter"}},
+		{Name: "get", Keys: []string{"a"}},
+	}, commands(s.T(), spans[0]))
+}
+
+func (s *redisTestSuite) TestNotTraced() {
+	assert.NoError(s.T(), s.client.Set(context.Background(), "a", "1", 0).Err())
+	pipe := s.client.Pipeline()
+	pipe.Get(context.Background(), "a")
+	_, err := pipe.Exec(context.Background())
+	assert.NoError(s.T(), err)
+
+	assert.Empty(s.T(), s.recorder.Ended())
+}
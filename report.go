@@ -0,0 +1,62 @@
+package lumigotracer
+
+import (
+	"context"
+	"time"
+
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation"
+)
+
+const (
+	// issueLevelError and issueLevelWarning are the levels of the
+	// issues reported with ReportError and ReportWarning
+	issueLevelError   = "ERROR"
+	issueLevelWarning = "WARNING"
+
+	// maxReportedIssues is the maximum number of issues of an invocation
+	maxReportedIssues = 50
+)
+
+// ReportError reports a non fatal error on the traced invocation of
+// ctx, e.g. an error the handler recovered from. The invocation isn't
+// marked as failed.
+func ReportError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	described := instrumentation.DescribeError(err)
+	if described.Stacktrace == "" {
+		// the stack trace starts where the error was reported
+		described.Stacktrace = takeCallerStacktrace()
+	}
+	reportIssue(ctx, lumigoctx.ReportedIssue{
+		Level:      issueLevelError,
+		Type:       described.Type,
+		Message:    described.Message,
+		Stacktrace: described.Stacktrace,
+	})
+}
+
+// ReportWarning reports a warning on the traced invocation of ctx
+func ReportWarning(ctx context.Context, message string) {
+	reportIssue(ctx, lumigoctx.ReportedIssue{
+		Level:   issueLevelWarning,
+		Message: message,
+	})
+}
+
+func reportIssue(ctx context.Context, issue lumigoctx.ReportedIssue) {
+	lt, ok := tracerFromContext(ctx)
+	if !ok {
+		return
+	}
+	lumigoCtx, ok := lumigoctx.FromContext(ctx)
+	if !ok {
+		return
+	}
+	issue.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	if !lumigoCtx.AddReportedIssue(issue, maxReportedIssues) {
+		lt.logger.Warnf("reported issue dropped, an invocation has at most %d issues", maxReportedIssues)
+	}
+}
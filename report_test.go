@@ -0,0 +1,68 @@
+package lumigotracer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type reportTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupReportSuite(t *testing.T) {
+	suite.Run(t, &reportTestSuite{})
+}
+
+func (s *reportTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(s.T())
+}
+
+func (s *reportTestSuite) TestReportErrorAndWarning() {
+	end, _ := invokeEndSpan(s.T(), func(ctx context.Context, name string) (string, error) {
+		ReportError(ctx, fmt.Errorf("cache: %w", &notFoundError{key: "user"}))
+		ReportWarning(ctx, "slow response")
+		ReportError(ctx, nil)
+		return "Hello " + name, nil
+	})
+
+	assert.Nil(s.T(), end.SpanError)
+	assert.NotNil(s.T(), end.LambdaResponse)
+	assert.Equal(s.T(), 2, len(end.ReportedIssues))
+
+	reportedError := end.ReportedIssues[0]
+	assert.Equal(s.T(), "ERROR", reportedError.Level)
+	assert.Equal(s.T(), "*lumigotracer.notFoundError", reportedError.Type)
+	assert.Equal(s.T(), "cache: user not found", reportedError.Message)
+	assert.True(s.T(), strings.HasPrefix(reportedError.Stacktrace, "github.com/lumigo-io/lumigo-go-tracer.(*reportTestSuite).TestReportErrorAndWarning.func1"))
+	assert.NotContains(s.T(), reportedError.Stacktrace, "lumigo-go-tracer.ReportError")
+	assert.NotZero(s.T(), reportedError.Timestamp)
+
+	warning := end.ReportedIssues[1]
+	assert.Equal(s.T(), "WARNING", warning.Level)
+	assert.Empty(s.T(), warning.Type)
+	assert.Equal(s.T(), "slow response", warning.Message)
+	assert.Empty(s.T(), warning.Stacktrace)
+}
+
+func (s *reportTestSuite) TestReportedIssuesLimit() {
+	end, hook := invokeEndSpan(s.T(), func(ctx context.Context, name string) (string, error) {
+		for i := 0; i <= maxReportedIssues; i++ {
+			ReportWarning(ctx, "slow response")
+		}
+		return "Hello " + name, nil
+	})
+	assert.Equal(s.T(), maxReportedIssues, len(end.ReportedIssues))
+	assert.Equal(s.T(), []string{"reported issue dropped, an invocation has at most 50 issues"}, warnings(hook))
+}
+
+func (s *reportTestSuite) TestReportOutsideInvocation() {
+	ReportError(context.Background(), errors.New("failed"))
+	ReportWarning(context.Background(), "slow response")
+}
@@ -0,0 +1,94 @@
+package lumigotracer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span traces a block of code of an invocation, e.g. a computation,
+// a query through a non HTTP driver or a cache lookup. A Span started
+// outside of a traced invocation does nothing.
+type Span struct {
+	span trace.Span
+}
+
+// SpanOption configures a Span started by StartSpan
+type SpanOption func(*Span)
+
+// WithSpanAttribute sets an attribute of the Span when it starts
+func WithSpanAttribute(key string, value interface{}) SpanOption {
+	return func(s *Span) {
+		s.SetAttribute(key, value)
+	}
+}
+
+// StartSpan starts a Span of the traced invocation of ctx, the
+// returned context carries the Span, the spans started with it are
+// its children. The Span is sent once ended with End.
+func StartSpan(ctx context.Context, name string, opts ...SpanOption) (context.Context, Span) {
+	if _, ok := tracerFromContext(ctx); !ok {
+		return ctx, Span{}
+	}
+	ctx, span := instrumentation.Start(ctx, name, telemetry.ManualSpanType)
+	s := Span{span: span}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return ctx, s
+}
+
+// SetAttribute sets an attribute of the Span, the values which
+// aren't a string, a bool or a number are formatted as strings
+func (s Span) SetAttribute(key string, value interface{}) {
+	if s.span == nil {
+		return
+	}
+	s.span.SetAttributes(spanAttribute(telemetry.AttributePrefix+key, value))
+}
+
+// RecordError marks the Span as failed with err
+func (s Span) RecordError(err error) {
+	if s.span == nil || err == nil {
+		return
+	}
+	described := instrumentation.DescribeError(err)
+	if described.Stacktrace == "" {
+		// the error didn't record where it was created
+		described.Stacktrace = takeCallerStacktrace()
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+	instrumentation.SetErrorAttributes(s.span, described)
+}
+
+// End ends the Span, it is sent with the spans of the invocation
+func (s Span) End() {
+	if s.span == nil {
+		return
+	}
+	s.span.End()
+}
+
+func spanAttribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
@@ -0,0 +1,105 @@
+package lumigotracer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type spanTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupSpanSuite(t *testing.T) {
+	suite.Run(t, &spanTestSuite{})
+}
+
+func (s *spanTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(s.T())
+}
+
+func (s *spanTestSuite) TestManualSpans() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello, world!"))
+	}))
+	defer ts.Close()
+
+	dir := s.T().TempDir()
+	lt, err := New(WithToken("token"), WithSpansDir(dir))
+	assert.NoError(s.T(), err)
+	var requestSpanID string
+	recorder := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requestSpanID, _ = lumigoctx.SpanIDFromContext(req.Context())
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	handler := reflect.ValueOf(lt.WrapHandler(func(ctx context.Context, name string) (string, error) {
+		ctx, compute := StartSpan(ctx, "compute", WithSpanAttribute("items", 3))
+		defer compute.End()
+		compute.SetAttribute("api_key", "secret")
+
+		lookupCtx, lookup := StartSpan(ctx, "lookup")
+		req, _ := http.NewRequestWithContext(lookupCtx, http.MethodGet, ts.URL, nil)
+		c := &http.Client{Transport: NewTransport(recorder)}
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		res.Body.Close()
+		lookup.RecordError(errors.New("cache miss"))
+		lookup.End()
+		return "Hello " + name, nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+
+	container, err := readSpansFromDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 4, len(container.endFileSpans))
+	httpSpan, lookup, compute, end := container.endFileSpans[0], container.endFileSpans[1], container.endFileSpans[2], container.endFileSpans[3]
+
+	assert.Equal(s.T(), "manual", compute.SpanType)
+	assert.Equal(s.T(), "compute", compute.SpanInfo.ManualInfo.Name)
+	assert.Equal(s.T(), map[string]interface{}{"items": float64(3), "api_key": "****"}, compute.SpanInfo.ManualInfo.Attributes)
+	assert.Equal(s.T(), mockLambdaContext.AwsRequestID, compute.ParentID)
+	assert.Nil(s.T(), compute.SpanError)
+
+	assert.Equal(s.T(), "manual", lookup.SpanType)
+	assert.Equal(s.T(), "lookup", lookup.SpanInfo.ManualInfo.Name)
+	assert.Equal(s.T(), compute.ID, lookup.ParentID)
+	assert.NotNil(s.T(), lookup.SpanError)
+	assert.Equal(s.T(), "cache miss", lookup.SpanError.Message)
+	// the stack trace starts at the caller of RecordError
+	assert.True(s.T(), strings.HasPrefix(lookup.SpanError.Stacktrace, "github.com/lumigo-io/lumigo-go-tracer.(*spanTestSuite).TestManualSpans.func"))
+	assert.NotContains(s.T(), lookup.SpanError.Stacktrace, "lumigo-go-tracer.Span.RecordError")
+
+	assert.Equal(s.T(), "http", httpSpan.SpanType)
+	assert.Equal(s.T(), lookup.ID, httpSpan.ParentID)
+	assert.Equal(s.T(), httpSpan.ID, requestSpanID)
+	assert.Equal(s.T(), "function", end.SpanType)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (s *spanTestSuite) TestStartSpanOutsideInvocation() {
+	ctx, span := StartSpan(context.Background(), "compute")
+	assert.Equal(s.T(), context.Background(), ctx)
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("failed"))
+	span.End()
+}
@@ -0,0 +1,145 @@
+// Package sql traces the statements run through database/sql
+// drivers, the statements of traced invocations are sent as db spans.
+//
+// The package name shadows database/sql, import it with an alias
+// if both are used in a file, e.g. lumigosql.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/pkg/errors"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// Option configures the tracing of a driver
+type Option func(*config)
+
+type config struct {
+	system         string
+	maskParameters bool
+}
+
+func newConfig(opts []Option) config {
+	c := config{system: semconv.DBSystemOtherSQL.Value.AsString()}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithDBSystem sets the database system of the spans,
+// e.g. postgresql or mysql
+func WithDBSystem(system string) Option {
+	return func(c *config) {
+		c.system = system
+	}
+}
+
+// WithMaskedParameters masks the values of all the parameters of
+// the statements, by default only the named parameters whose names
+// match the secret masking regexes are masked
+func WithMaskedParameters() Option {
+	return func(c *config) {
+		c.maskParameters = true
+	}
+}
+
+// Open opens a database like sql.Open, with the driver registered
+// as driverName wrapped. The database system defaults to driverName.
+func Open(driverName, dataSourceName string, opts ...Option) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	if err := db.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close the unwrapped database")
+	}
+
+	var connector driver.Connector = dsnConnector{dsn: dataSourceName, driver: d}
+	if dc, ok := d.(driver.DriverContext); ok {
+		connector, err = dc.OpenConnector(dataSourceName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts = append([]Option{WithDBSystem(driverName)}, opts...)
+	return sql.OpenDB(WrapConnector(connector, opts...)), nil
+}
+
+// WrapDriver wraps d, the statements run within traced
+// invocations through its connections are traced
+func WrapDriver(d driver.Driver, opts ...Option) driver.Driver {
+	return &wrappedDriver{driver: d, cfg: newConfig(opts)}
+}
+
+// WrapConnector wraps c, the statements run within traced
+// invocations through its connections are traced
+func WrapConnector(c driver.Connector, opts ...Option) driver.Connector {
+	cfg := newConfig(opts)
+	return &wrappedConnector{
+		connector: c,
+		driver:    &wrappedDriver{driver: c.Driver(), cfg: cfg},
+		cfg:       cfg,
+	}
+}
+
+type wrappedDriver struct {
+	driver driver.Driver
+	cfg    config
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{conn: c, cfg: d.cfg}, nil
+}
+
+func (d *wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.driver.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &wrappedConnector{connector: c, driver: d, cfg: d.cfg}, nil
+	}
+	return &wrappedConnector{connector: dsnConnector{dsn: name, driver: d.driver}, driver: d, cfg: d.cfg}, nil
+}
+
+type wrappedConnector struct {
+	connector driver.Connector
+	driver    *wrappedDriver
+	cfg       config
+}
+
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{conn: cn, cfg: c.cfg}, nil
+}
+
+func (c *wrappedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// dsnConnector connects with a driver
+// which doesn't implement DriverContext
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,311 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var errDuplicateKey = errors.New("duplicate key")
+
+// fakeDriver is an in memory driver whose statements affect
+// one row, and fail with the statements of failQuery
+type fakeDriver struct {
+	// legacy connections implement only driver.Conn,
+	// their statements are run as prepared statements
+	legacy bool
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	if d.legacy {
+		return &fakeLegacyConn{}, nil
+	}
+	return &fakeConn{}, nil
+}
+
+const failQuery = "INSERT INTO users VALUES (1)"
+
+type fakeLegacyConn struct{}
+
+func (c *fakeLegacyConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{query: query}, nil
+}
+
+func (c *fakeLegacyConn) Close() error {
+	return nil
+}
+
+func (c *fakeLegacyConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeConn struct {
+	fakeLegacyConn
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if query == failQuery {
+		return nil, errDuplicateKey
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+type fakeStmt struct {
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.query == failQuery {
+		return nil, errDuplicateKey
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+// fakeRows has a single row with the column id
+type fakeRows struct {
+	read bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0] = int64(1)
+	return nil
+}
+
+type sqlTestSuite struct {
+	suite.Suite
+	recorder *tracetest.SpanRecorder
+	ctx      context.Context
+}
+
+func TestSetupSQLSuite(t *testing.T) {
+	suite.Run(t, &sqlTestSuite{})
+}
+
+func (s *sqlTestSuite) SetupTest() {
+	s.recorder = tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.recorder))
+	ctx := lumigoctx.NewContext(context.Background(), &lumigoctx.LumigoContext{})
+	ctx, span := provider.Tracer("test").Start(ctx, "invocation")
+	s.T().Cleanup(func() { span.End() })
+	s.ctx = ctx
+}
+
+func (s *sqlTestSuite) openDB(d driver.Driver, opts ...Option) *sql.DB {
+	db := sql.OpenDB(WrapConnector(dsnConnector{driver: d}, opts...))
+	s.T().Cleanup(func() { db.Close() })
+	return db
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func (s *sqlTestSuite) TestExec() {
+	for _, legacy := range []bool{false, true} {
+		s.SetupTest()
+		db := s.openDB(fakeDriver{legacy: legacy}, WithDBSystem("postgresql"))
+
+		result, err := db.ExecContext(s.ctx, "UPDATE users SET name = $1 WHERE id = $2", "John", 1)
+		assert.NoError(s.T(), err)
+		rowsAffected, _ := result.RowsAffected()
+		assert.Equal(s.T(), int64(1), rowsAffected)
+
+		spans := s.recorder.Ended()
+		assert.Equal(s.T(), 1, len(spans))
+		span := spans[0]
+		assert.Equal(s.T(), "UPDATE", span.Name())
+		assert.Equal(s.T(), s.recorder.Started()[0].SpanContext().SpanID(), span.Parent().SpanID())
+		attrs := attributes(span)
+		assert.Equal(s.T(), telemetry.DBSpanType, attrs[telemetry.SpanTypeKey].AsString())
+		assert.Equal(s.T(), "postgresql", attrs["db.system"].AsString())
+		assert.Equal(s.T(), "UPDATE users SET name = $1 WHERE id = $2", attrs["db.statement"].AsString())
+		assert.Equal(s.T(), `{"1":"John","2":1}`, attrs[telemetry.DBParametersKey].AsString())
+		assert.Equal(s.T(), int64(1), attrs[telemetry.DBRowsAffectedKey].AsInt64())
+		assert.Equal(s.T(), codes.Unset, span.Status().Code)
+	}
+}
+
+func (s *sqlTestSuite) TestQuery() {
+	for _, legacy := range []bool{false, true} {
+		s.SetupTest()
+		db := s.openDB(fakeDriver{legacy: legacy})
+
+		var id int64
+		err := db.QueryRowContext(s.ctx, "select id from users where email = @email", sql.Named("email", "john@example.com")).Scan(&id)
+		if legacy {
+			// the statements of drivers without the context
+			// interfaces have only positional parameters
+			assert.Error(s.T(), err)
+		} else {
+			assert.NoError(s.T(), err)
+			assert.Equal(s.T(), int64(1), id)
+		}
+
+		spans := s.recorder.Ended()
+		assert.Equal(s.T(), 1, len(spans))
+		attrs := attributes(spans[0])
+		assert.Equal(s.T(), "SELECT", spans[0].Name())
+		assert.Equal(s.T(), "other_sql", attrs["db.system"].AsString())
+		assert.Equal(s.T(), `{"email":"john@example.com"}`, attrs[telemetry.DBParametersKey].AsString())
+		_, hasRowsAffected := attrs[telemetry.DBRowsAffectedKey]
+		assert.False(s.T(), hasRowsAffected)
+	}
+}
+
+func (s *sqlTestSuite) TestError() {
+	for _, legacy := range []bool{false, true} {
+		s.SetupTest()
+		db := s.openDB(fakeDriver{legacy: legacy})
+
+		_, err := db.ExecContext(s.ctx, failQuery)
+		assert.Equal(s.T(), errDuplicateKey, err)
+
+		spans := s.recorder.Ended()
+		assert.Equal(s.T(), 1, len(spans))
+		attrs := attributes(spans[0])
+		assert.Equal(s.T(), codes.Error, spans[0].Status().Code)
+		assert.True(s.T(), attrs["has_error"].AsBool())
+		assert.Equal(s.T(), "*errors.errorString", attrs["error_type"].AsString())
+		assert.Equal(s.T(), "duplicate key", attrs["error_message"].AsString())
+		_, hasParameters := attrs[telemetry.DBParametersKey]
+		assert.False(s.T(), hasParameters)
+	}
+}
+
+func (s *sqlTestSuite) TestMaskedParameters() {
+	db := s.openDB(fakeDriver{}, WithMaskedParameters())
+
+	_, err := db.ExecContext(s.ctx, "UPDATE users SET password = $1", []byte("secret"))
+	assert.NoError(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), `{"1":"****"}`, attributes(spans[0])[telemetry.DBParametersKey].AsString())
+}
+
+func (s *sqlTestSuite) TestParametersMaskedAtCapture() {
+	lc, _ := lumigoctx.FromContext(s.ctx)
+	lc.MaxEntrySize = 32
+	db := s.openDB(fakeDriver{})
+
+	_, err := db.ExecContext(s.ctx, "UPDATE users SET password = @password", sql.Named("password", "secret"))
+	assert.NoError(s.T(), err)
+	_, err = db.ExecContext(s.ctx, "UPDATE users SET bio = $1", strings.Repeat("b", 64))
+	assert.NoError(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 2, len(spans))
+	assert.Equal(s.T(), `{"password":"****"}`, attributes(spans[0])[telemetry.DBParametersKey].AsString())
+	assert.Equal(s.T(), `{"1":"`+strings.Repeat("b", 26), attributes(spans[1])[telemetry.DBParametersKey].AsString())
+}
+
+func (s *sqlTestSuite) TestParentManualSpan() {
+	db := s.openDB(fakeDriver{})
+
+	_, err := db.ExecContext(lumigoctx.WithSpanID(s.ctx, "manual-span"), "DELETE FROM users")
+	assert.NoError(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), "manual-span", attributes(spans[0])[telemetry.ParentIDKey].AsString())
+}
+
+func (s *sqlTestSuite) TestNotTraced() {
+	db := s.openDB(fakeDriver{})
+
+	_, err := db.ExecContext(context.Background(), "DELETE FROM users")
+	assert.NoError(s.T(), err)
+	_, err = db.ExecContext(lumigoctx.NewContext(context.Background(), &lumigoctx.LumigoContext{}), "DELETE FROM users")
+	assert.NoError(s.T(), err)
+
+	assert.Empty(s.T(), s.recorder.Ended())
+}
+
+func (s *sqlTestSuite) TestOpen() {
+	sql.Register("lumigo-fake", fakeDriver{})
+	db, err := Open("lumigo-fake", "dsn")
+	assert.NoError(s.T(), err)
+	defer db.Close()
+
+	_, err = db.ExecContext(s.ctx, "DELETE FROM users")
+	assert.NoError(s.T(), err)
+	tx, err := db.BeginTx(s.ctx, nil)
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), tx.Commit())
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), "lumigo-fake", attributes(spans[0])["db.system"].AsString())
+
+	_, err = Open("unknown", "dsn")
+	assert.Error(s.T(), err)
+}
+
+func (s *sqlTestSuite) TestWrapDriver() {
+	sql.Register("lumigo-wrapped", WrapDriver(fakeDriver{legacy: true}, WithDBSystem("mysql")))
+	db, err := sql.Open("lumigo-wrapped", "dsn")
+	assert.NoError(s.T(), err)
+	defer db.Close()
+
+	_, err = db.ExecContext(s.ctx, "DELETE FROM users")
+	assert.NoError(s.T(), err)
+
+	spans := s.recorder.Ended()
+	assert.Equal(s.T(), 1, len(spans))
+	assert.Equal(s.T(), "mysql", attributes(spans[0])["db.system"].AsString())
+}
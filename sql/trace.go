@@ -0,0 +1,74 @@
+package sql
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// trace sends the db span of a statement run from started, if it
+// was run within a traced invocation. The span of a query ends
+// when the query returns, before its rows are read.
+func (c config) trace(ctx context.Context, started time.Time, query string, args []driver.NamedValue, result driver.Result, err error) {
+	if err == driver.ErrSkip || !instrumentation.IsTraced(ctx) {
+		return
+	}
+	_, span := instrumentation.Start(ctx, operation(query), telemetry.DBSpanType, trace.WithTimestamp(started))
+	defer span.End()
+	span.SetAttributes(
+		semconv.DBSystemKey.String(c.system),
+		semconv.DBStatementKey.String(query),
+	)
+	if len(args) > 0 {
+		span.SetAttributes(attribute.String(telemetry.DBParametersKey, instrumentation.MaskAndLimit(ctx, c.parameters(args))))
+	}
+	if result != nil && err == nil {
+		if rowsAffected, err := result.RowsAffected(); err == nil {
+			span.SetAttributes(attribute.Int64(telemetry.DBRowsAffectedKey, rowsAffected))
+		}
+	}
+	instrumentation.RecordError(span, err)
+}
+
+// parameters returns the JSON encoded parameters of a statement,
+// by name, or by ordinal for the positional parameters
+func (c config) parameters(args []driver.NamedValue) string {
+	parameters := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		key := arg.Name
+		if key == "" {
+			key = strconv.Itoa(arg.Ordinal)
+		}
+		value := arg.Value
+		if c.maskParameters {
+			value = masking.MaskedValue
+		} else if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		parameters[key] = value
+	}
+	encoded, err := json.Marshal(parameters)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// operation returns the first keyword of the statement, e.g. SELECT
+func operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,43 @@
+package lumigotracer
+
+import (
+	"context"
+
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+)
+
+const (
+	// maxTagKeyLength is the maximum length of the key of an execution tag
+	maxTagKeyLength = 50
+	// maxTagValueLength is the maximum length of the value of an execution tag
+	maxTagValueLength = 70
+	// maxTagsCount is the maximum number of execution tags of an invocation
+	maxTagsCount = 50
+)
+
+// AddExecutionTag tags the traced invocation of ctx, e.g. with a
+// customer ID, an order ID or a tenant, so that it can be searched
+// for in Lumigo. The tags with an empty or too long key or value and
+// the ones beyond the maximum number of tags are dropped with a warning.
+func AddExecutionTag(ctx context.Context, key string, value string) {
+	lt, ok := tracerFromContext(ctx)
+	if !ok {
+		return
+	}
+	lumigoCtx, ok := lumigoctx.FromContext(ctx)
+	if !ok {
+		return
+	}
+	logger := lt.logger.WithField("key", key)
+	if key == "" || len(key) > maxTagKeyLength {
+		logger.Warnf("execution tag dropped, its key must be 1 to %d characters long", maxTagKeyLength)
+		return
+	}
+	if value == "" || len(value) > maxTagValueLength {
+		logger.Warnf("execution tag dropped, its value must be 1 to %d characters long", maxTagValueLength)
+		return
+	}
+	if !lumigoCtx.AddExecutionTag(key, value, maxTagsCount) {
+		logger.Warnf("execution tag dropped, an invocation has at most %d tags", maxTagsCount)
+	}
+}
@@ -0,0 +1,99 @@
+package lumigotracer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type tagsTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupTagsSuite(t *testing.T) {
+	suite.Run(t, &tagsTestSuite{})
+}
+
+func (s *tagsTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(s.T())
+}
+
+// invokeEndSpan invokes the traced handler and returns
+// its end span and the logs of the tracer
+func invokeEndSpan(t *testing.T, handlerFunc interface{}) (telemetry.Span, *test.Hook) {
+	dir := t.TempDir()
+	logger, hook := test.NewNullLogger()
+	lt, err := New(WithToken("token"), WithSpansDir(dir), WithLogger(logger))
+	assert.NoError(t, err)
+	handler := reflect.ValueOf(lt.WrapHandler(handlerFunc))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+
+	container, err := readSpansFromDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(container.startFileSpans))
+	assert.Equal(t, 1, len(container.endFileSpans))
+	assert.Empty(t, container.startFileSpans[0].ExecutionTags)
+	return container.endFileSpans[0], hook
+}
+
+func warnings(hook *test.Hook) []string {
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			messages = append(messages, entry.Message)
+		}
+	}
+	return messages
+}
+
+func (s *tagsTestSuite) TestAddExecutionTag() {
+	end, hook := invokeEndSpan(s.T(), func(ctx context.Context, name string) (string, error) {
+		AddExecutionTag(ctx, "customer", "c-1")
+		AddExecutionTag(ctx, "order", "o-1")
+		return "Hello " + name, nil
+	})
+	assert.Equal(s.T(), []telemetry.ExecutionTag{
+		{Key: "customer", Value: "c-1"},
+		{Key: "order", Value: "o-1"},
+	}, end.ExecutionTags)
+	assert.Empty(s.T(), warnings(hook))
+}
+
+func (s *tagsTestSuite) TestAddExecutionTagLimits() {
+	end, hook := invokeEndSpan(s.T(), func(ctx context.Context, name string) (string, error) {
+		AddExecutionTag(ctx, "", "value")
+		AddExecutionTag(ctx, strings.Repeat("k", maxTagKeyLength+1), "value")
+		AddExecutionTag(ctx, "key", "")
+		AddExecutionTag(ctx, "key", strings.Repeat("v", maxTagValueLength+1))
+		for i := 0; i <= maxTagsCount; i++ {
+			AddExecutionTag(ctx, fmt.Sprintf("key%d", i), strings.Repeat("v", maxTagValueLength))
+		}
+		return "Hello " + name, nil
+	})
+	assert.Equal(s.T(), maxTagsCount, len(end.ExecutionTags))
+	assert.Equal(s.T(), "key0", end.ExecutionTags[0].Key)
+	assert.Equal(s.T(), []string{
+		"execution tag dropped, its key must be 1 to 50 characters long",
+		"execution tag dropped, its key must be 1 to 50 characters long",
+		"execution tag dropped, its value must be 1 to 70 characters long",
+		"execution tag dropped, its value must be 1 to 70 characters long",
+		"execution tag dropped, an invocation has at most 50 tags",
+	}, warnings(hook))
+}
+
+func (s *tagsTestSuite) TestAddExecutionTagOutsideInvocation() {
+	AddExecutionTag(context.Background(), "customer", "c-1")
+}
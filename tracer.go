@@ -3,90 +3,262 @@ package lumigotracer
 import (
 	"context"
 	"encoding/json"
-	"reflect"
+	"fmt"
+	"sync"
+	"time"
 
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/instrumentation"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/transform"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/propagation"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 )
 
-type tracer struct {
-	provider  *sdktrace.TracerProvider
-	logger    logrus.FieldLogger
-	span      trace.Span
-	eventData []byte
-	ctx       context.Context
-	traceCtx  context.Context
+// invocation holds the tracing state of a single invocation,
+// the provider is shared by all the invocations of a Tracer
+type invocation struct {
+	provider     *sdktrace.TracerProvider
+	logger       logrus.FieldLogger
+	masker       *masking.Masker
+	span         trace.Span
+	eventData    []byte
+	triggerAttrs []attribute.KeyValue
+	parentID     string
+	ctx          context.Context
+	traceCtx     context.Context
+
+	clock         clock
+	timeoutBuffer time.Duration
+	stopWatchdog  func() bool
+	// shutdown stops the provider when the invocation ends,
+	// if the provider isn't shared with other invocations
+	shutdown func(ctx context.Context) error
+
+	endMu sync.Mutex
+	ended bool
+}
+
+// clock schedules the timeout watchdog of the invocations
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// realClock is the clock of the wall time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// NewTracer creates the tracer of a single invocation configured by
+// cfg and the LUMIGO_ environment variables, it returns a nil tracer
+// if tracing is switched off
+//
+// Deprecated: NewTracer creates a provider per invocation, use New
+// and Tracer.WrapHandler which share it between the invocations.
+func NewTracer(ctx context.Context, cfg Config, payload json.RawMessage) (*invocation, error) {
+	loaded, err := loadConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	lt := tracerFromConfig(loaded)
+	inv, err := newInvocation(ctx, lt, payload)
+	if inv != nil {
+		inv.shutdown = lt.Shutdown
+	}
+	return inv, err
 }
 
-func NewTracer(ctx context.Context, cfg Config, payload json.RawMessage) (retTracer *tracer, err error) {
-	defer recoverWithLogs()
-	retTracer = &tracer{
-		ctx:    ctx,
-		logger: logger,
+// newInvocation creates the state and the context of an invocation
+// traced by lt, shared by NewTracer and Tracer.WrapHandler,
+// it returns a nil invocation if tracing is switched off
+func newInvocation(ctx context.Context, lt *Tracer, payload json.RawMessage) (inv *invocation, err error) {
+	defer recoverWithLogs(lt.logger)
+	if !lt.cfg.enabled {
+		return nil, nil
+	}
+	ctx = lumigoctx.NewContext(ctx, &lumigoctx.LumigoContext{
+		TracerVersion:    version,
+		InvocationNumber: transform.NextInvocationNumber(),
+		Propagator:       lt.propagator,
+		Masker:           lt.masker,
+		MaxEntrySize:     lt.cfg.MaxEntrySize,
+	})
+
+	// the spans of the invocation continue the trace of the event,
+	// or of the lambda which invoked it with a client context. The
+	// Lumigo parent span ID is preferred to the one of the propagator.
+	carrier := eventCarrier(payload)
+	for key, value := range clientContextCarrier(ctx) {
+		carrier.Set(key, value)
+	}
+	ctx = lt.propagator.Extract(ctx, carrier)
+	parentID := carrier.Get(clientContextParentIDKey)
+	if sc := trace.SpanContextFromContext(ctx); parentID == "" && sc.IsRemote() {
+		parentID = sc.SpanID().String()
 	}
 
-	exporter, err := createExporter(cfg.PrintStdout, ctx, logger)
+	provider, err := lt.startInvocation(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create otel exporter")
+		return nil, errors.Wrap(err, "failed to create otel tracer provider")
 	}
 
 	data, err := json.Marshal(&payload)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse event payload")
 	}
-	retTracer.eventData = data
 
-	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(newResource(ctx,
-			attribute.String("event", string(retTracer.eventData)),
-		)),
-	)
-	retTracer.provider = tracerProvider
-	otel.SetTracerProvider(tracerProvider)
-	otel.SetTextMapPropagator(propagation.TraceContext{})
+	// the trigger is parsed before the event is masked,
+	// the masked keys of its messages would be lost
+	triggerAttrs, err := transform.TriggerAttributes(string(data))
+	if err != nil {
+		lt.logger.WithError(err).Error("unable to parse lambda trigger")
+	}
+
+	return &invocation{
+		ctx:          ctx,
+		provider:     provider,
+		logger:       lt.logger,
+		masker:       lt.masker,
+		eventData:    []byte(lt.masker.MaskJSON(string(data))),
+		triggerAttrs: triggerAttrs,
+		parentID:     parentID,
 
-	return retTracer, nil
+		clock:         lt.clock,
+		timeoutBuffer: lt.cfg.timeoutTimerBuffer,
+	}, nil
 }
 
 // Start tracks the span start data
-func (t *tracer) Start() {
-	defer recoverWithLogs()
+func (inv *invocation) Start() {
+	if inv == nil {
+		return
+	}
+	defer recoverWithLogs(inv.logger)
+
+	inv.logger.Info("tracer starting")
+
+	traceCtx, span := inv.provider.Tracer("lumigo").Start(inv.ctx, "LumigoParentSpan")
+	span.SetAttributes(inv.functionAttributes()...)
+	inv.span = span
+	inv.traceCtx = traceCtx
+	inv.startWatchdog()
+}
+
+// functionAttributes returns the attributes of the function spans,
+// the event and the span which propagated its trace context
+func (inv *invocation) functionAttributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("event", string(inv.eventData))}
+	attrs = append(attrs, inv.triggerAttrs...)
+	if inv.parentID != "" {
+		attrs = append(attrs, attribute.String(telemetry.ParentIDKey, inv.parentID))
+	}
+	return attrs
+}
 
-	t.logger.Info("tracer starting")
+// startWatchdog ends the span as timed out shortly before the lambda
+// deadline, if the invocation is still running by then
+func (inv *invocation) startWatchdog() {
+	deadline, ok := inv.ctx.Deadline()
+	if !ok || inv.timeoutBuffer <= 0 {
+		return
+	}
+	delay := deadline.Add(-inv.timeoutBuffer).Sub(inv.clock.Now())
+	if delay <= 0 {
+		return
+	}
+	inv.stopWatchdog = inv.clock.AfterFunc(delay, inv.EndWithTimeout)
+}
 
-	traceCtx, span := t.provider.Tracer("lumigo").Start(t.ctx, "LumigoParentSpan")
-	span.SetAttributes(attribute.String("event", string(t.eventData)))
-	t.span = span
-	t.traceCtx = traceCtx
+// EndWithTimeout tracks the span end data when the lambda is about
+// to time out, with the spans collected so far
+func (inv *invocation) EndWithTimeout() {
+	if inv == nil {
+		return
+	}
+	defer recoverWithLogs(inv.logger)
+	inv.end(func() {
+		inv.logger.Warn("lambda is about to time out")
+		inv.span.SetAttributes(
+			attribute.Bool("has_error", true),
+			attribute.String("error_type", timeoutErrorType),
+			attribute.String("error_message", timeoutErrorMessage),
+			attribute.String("error_stacktrace", ""),
+		)
+	})
 }
 
 // End tracks the span end data after lambda execution
-func (t *tracer) End(response []byte, lambdaErr error) {
-	defer recoverWithLogs()
-	if data, err := json.Marshal(json.RawMessage(response)); err == nil && lambdaErr == nil {
-		t.span.SetAttributes(attribute.String("response", string(data)))
-	} else {
-		t.logger.WithError(err).Error("failed to track response")
+func (inv *invocation) End(response []byte, lambdaErr error) {
+	if inv == nil {
+		return
+	}
+	defer recoverWithLogs(inv.logger)
+	inv.end(func() {
+		if data, err := json.Marshal(json.RawMessage(response)); err == nil && lambdaErr == nil {
+			inv.span.SetAttributes(attribute.String("response", inv.masker.MaskJSON(string(data))))
+		} else {
+			inv.logger.WithError(err).Error("failed to track response")
+		}
+
+		if lambdaErr != nil {
+			instrumentation.SetErrorAttributes(inv.span, instrumentation.DescribeError(lambdaErr))
+		}
+	})
+}
+
+// EndWithPanic tracks the span end data after the lambda panicked,
+// the stacktrace is the one of the goroutine at the panic
+func (inv *invocation) EndWithPanic(recovered interface{}, stacktrace string) {
+	if inv == nil {
+		return
 	}
+	defer recoverWithLogs(inv.logger)
+	inv.end(func() {
+		inv.span.SetAttributes(
+			attribute.Bool("has_error", true),
+			attribute.String("error_type", panicErrorType),
+			attribute.String("error_message", fmt.Sprint(recovered)),
+			attribute.String("error_stacktrace", stacktrace),
+		)
+	})
+}
 
-	if lambdaErr != nil {
-		t.span.SetAttributes(attribute.Bool("has_error", true))
-		t.span.SetAttributes(attribute.String("error_type", reflect.TypeOf(lambdaErr).String()))
-		t.span.SetAttributes(attribute.String("error_message", lambdaErr.Error()))
-		t.span.SetAttributes(attribute.String("error_stacktrace", takeStacktrace()))
+// end tags the span of the invocation, ends it and flushes it to the
+// exporter. Only the first end of the invocation is tracked, the
+// others are dropped.
+func (inv *invocation) end(tag func()) {
+	inv.endMu.Lock()
+	defer inv.endMu.Unlock()
+	if inv.ended {
+		return
+	}
+	inv.ended = true
+	if inv.stopWatchdog != nil {
+		inv.stopWatchdog()
 	}
-	t.span.End()
-	t.provider.ForceFlush(t.traceCtx)
 
-	if err := t.provider.Shutdown(t.traceCtx); err != nil {
-		t.logger.WithError(err).Error("failed to shutdown tracer")
+	tag()
+	inv.span.End()
+
+	if err := inv.provider.ForceFlush(inv.traceCtx); err != nil {
+		inv.logger.WithError(err).Error("failed to flush tracer")
 	} else {
-		t.logger.Info("tracer shutdown successfully")
+		inv.logger.Info("tracer flushed successfully")
+	}
+	if inv.shutdown != nil {
+		if err := inv.shutdown(inv.traceCtx); err != nil {
+			inv.logger.WithError(err).Error("failed to shutdown tracer")
+		}
 	}
 }
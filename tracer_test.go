@@ -0,0 +1,441 @@
+package lumigotracer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/testenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type tracerTestSuite struct {
+	suite.Suite
+}
+
+func TestSetupTracerSuite(t *testing.T) {
+	suite.Run(t, &tracerTestSuite{})
+}
+
+func (s *tracerTestSuite) SetupTest() {
+	testenv.SetLambdaEnv(s.T())
+}
+
+func (s *tracerTestSuite) TestProviderReusedAcrossInvocations() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello, world!"))
+	}))
+	defer ts.Close()
+
+	dir := s.T().TempDir()
+	lt, err := New(WithToken("token"), WithSpansDir(dir))
+	assert.NoError(s.T(), err)
+	requests := 0
+	handler := reflect.ValueOf(lt.WrapHandler(func(ctx context.Context, name string) (string, error) {
+		requests++
+		c := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+		for i := 0; i < requests; i++ {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+			res, err := c.Do(req)
+			if err != nil {
+				return "", err
+			}
+			res.Body.Close()
+		}
+		return "Hello " + name, nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+	provider := lt.provider
+	assert.NotNil(s.T(), provider)
+	assert.NoError(s.T(), deleteAllFilesInDir(dir))
+
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+	assert.Same(s.T(), provider, lt.provider)
+
+	container, err := readSpansFromDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, len(container.startFileSpans))
+	assert.Equal(s.T(), string(inputPayload), container.startFileSpans[0].Event)
+	// only the http spans of the second invocation and its end span
+	assert.Equal(s.T(), 3, len(container.endFileSpans))
+	assert.Equal(s.T(), "function", container.endFileSpans[2].SpanType)
+	assert.Equal(s.T(), "warm", container.endFileSpans[2].LambdaReadiness)
+}
+
+func (s *tracerTestSuite) TestContainerIDStableAcrossInvocations() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello, world!"))
+	}))
+	defer ts.Close()
+
+	dir := s.T().TempDir()
+	lt, err := New(WithToken("token"), WithSpansDir(dir))
+	assert.NoError(s.T(), err)
+	handler := reflect.ValueOf(lt.WrapHandler(func(ctx context.Context, name string) (string, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		c := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		res.Body.Close()
+		return "Hello " + name, nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+
+	var containerIDs []string
+	var invocationNumbers []int64
+	for i := 0; i < 3; i++ {
+		_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+		container, err := readSpansFromDir(dir)
+		assert.NoError(s.T(), err)
+		assert.Equal(s.T(), 2, len(container.endFileSpans))
+
+		start, httpSpan, end := container.startFileSpans[0], container.endFileSpans[0], container.endFileSpans[1]
+		containerIDs = append(containerIDs, start.LambdaContainerID, httpSpan.LambdaContainerID, end.LambdaContainerID)
+		assert.Equal(s.T(), start.InvocationNumber, end.InvocationNumber)
+		assert.Zero(s.T(), httpSpan.InvocationNumber)
+		invocationNumbers = append(invocationNumbers, end.InvocationNumber)
+		assert.NoError(s.T(), deleteAllFilesInDir(dir))
+	}
+
+	assert.NotEmpty(s.T(), containerIDs[0])
+	for _, containerID := range containerIDs {
+		assert.Equal(s.T(), containerIDs[0], containerID)
+	}
+	assert.Equal(s.T(), invocationNumbers[0]+1, invocationNumbers[1])
+	assert.Equal(s.T(), invocationNumbers[1]+1, invocationNumbers[2])
+}
+
+// fakeClock fires the timeout watchdog on demand
+type fakeClock struct {
+	now time.Time
+
+	mu      sync.Mutex
+	delay   time.Duration
+	fire    func()
+	stopped bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delay = d
+	c.fire = f
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.stopped = true
+		return true
+	}
+}
+
+// Fire runs the watchdog, even if it was stopped
+func (c *fakeClock) Fire() {
+	c.mu.Lock()
+	fire := c.fire
+	c.mu.Unlock()
+	fire()
+}
+
+func (s *tracerTestSuite) newTimeoutHandler(dir string, clock *fakeClock, handlerFunc interface{}) (reflect.Value, context.Context) {
+	lt, err := New(WithToken("token"), WithSpansDir(dir), WithTimeoutTimerBuffer(time.Second))
+	assert.NoError(s.T(), err)
+	lt.clock = clock
+	ctx, cancel := context.WithDeadline(lambdacontext.NewContext(context.Background(), &mockLambdaContext), clock.now.Add(10*time.Second))
+	s.T().Cleanup(cancel)
+	return reflect.ValueOf(lt.WrapHandler(handlerFunc)), ctx
+}
+
+func (s *tracerTestSuite) TestTimeoutWritesPartialEndSpan() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello, world!"))
+	}))
+	defer ts.Close()
+
+	dir := s.T().TempDir()
+	clock := &fakeClock{now: time.Now()}
+	handler, ctx := s.newTimeoutHandler(dir, clock, func(ctx context.Context, name string) (string, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		c := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		res.Body.Close()
+		clock.Fire()
+		return "Hello " + name, nil
+	})
+	inputPayload, _ := json.Marshal("test")
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+
+	assert.Equal(s.T(), 9*time.Second, clock.delay)
+	files, err := os.ReadDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, len(files))
+	container, err := readSpansFromDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, len(container.endFileSpans))
+	assert.Equal(s.T(), "http", container.endFileSpans[0].SpanType)
+	end := container.endFileSpans[1]
+	assert.Equal(s.T(), "function", end.SpanType)
+	assert.NotNil(s.T(), end.SpanError)
+	assert.Equal(s.T(), "TimeoutError", end.SpanError.Type)
+	assert.Nil(s.T(), end.LambdaResponse)
+}
+
+func (s *tracerTestSuite) TestTimeoutAfterCompletionIsDropped() {
+	dir := s.T().TempDir()
+	clock := &fakeClock{now: time.Now()}
+	handler, ctx := s.newTimeoutHandler(dir, clock, func(name string) (string, error) {
+		return "Hello " + name, nil
+	})
+	inputPayload, _ := json.Marshal("test")
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+	assert.True(s.T(), clock.stopped)
+
+	// the watchdog fired while it was being stopped
+	clock.Fire()
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, len(files))
+	container, err := readSpansFromDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, len(container.endFileSpans))
+	assert.Nil(s.T(), container.endFileSpans[0].SpanError)
+	assert.Equal(s.T(), `"Hello test"`, *container.endFileSpans[0].LambdaResponse)
+}
+
+func (s *tracerTestSuite) TestTimeoutRacesCompletion() {
+	dir := s.T().TempDir()
+	clock := &fakeClock{now: time.Now()}
+	fired := make(chan struct{})
+	handler, ctx := s.newTimeoutHandler(dir, clock, func(name string) (string, error) {
+		go func() {
+			clock.Fire()
+			close(fired)
+		}()
+		return "Hello " + name, nil
+	})
+	inputPayload, _ := json.Marshal("test")
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+	<-fired
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, len(files))
+	container, err := readSpansFromDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, len(container.endFileSpans))
+	if end := container.endFileSpans[0]; end.SpanError != nil {
+		assert.Equal(s.T(), "TimeoutError", end.SpanError.Type)
+		assert.Nil(s.T(), end.LambdaResponse)
+	} else {
+		assert.Equal(s.T(), `"Hello test"`, *end.LambdaResponse)
+	}
+}
+
+func (s *tracerTestSuite) TestNoWatchdogWithoutBuffer() {
+	dir := s.T().TempDir()
+	lt, err := New(WithToken("token"), WithSpansDir(dir), WithTimeoutTimerBuffer(0))
+	assert.NoError(s.T(), err)
+	clock := &fakeClock{now: time.Now()}
+	lt.clock = clock
+	ctx, cancel := context.WithDeadline(lambdacontext.NewContext(context.Background(), &mockLambdaContext), clock.now.Add(10*time.Second))
+	defer cancel()
+	handler := reflect.ValueOf(lt.WrapHandler(func(name string) (string, error) {
+		return "Hello " + name, nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+	assert.Nil(s.T(), clock.fire)
+}
+
+func (s *tracerTestSuite) TestTriggerParsedBeforeMasking() {
+	dir := s.T().TempDir()
+	lt, err := New(WithToken("token"), WithSpansDir(dir))
+	assert.NoError(s.T(), err)
+	handler := reflect.ValueOf(lt.WrapHandler(func(event events.DynamoDBEvent) error {
+		return nil
+	}))
+	inputPayload, _ := json.Marshal(events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{{
+		EventSource:    "aws:dynamodb",
+		EventName:      "MODIFY",
+		EventSourceArn: "arn:aws:dynamodb:us-east-1:123:table/users/stream/1",
+		Change: events.DynamoDBStreamRecord{
+			Keys: map[string]events.DynamoDBAttributeValue{"id": events.NewStringAttribute("1")},
+		},
+	}}})
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+
+	container, err := readSpansFromDir(dir)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, len(container.startFileSpans))
+	for _, span := range []telemetry.Span{container.startFileSpans[0], container.endFileSpans[len(container.endFileSpans)-1]} {
+		// the keys are masked in the stored event only
+		assert.Contains(s.T(), span.Event, `"Keys":"****"`)
+		assert.Equal(s.T(), "dynamodb", span.SpanInfo.TriggeredBy)
+		assert.Equal(s.T(), "arn:aws:dynamodb:us-east-1:123:table/users/stream/1", span.SpanInfo.Arn)
+		assert.Equal(s.T(), "63fdc94765b4cce96704e7f2a10ccf31", span.SpanInfo.MessageID)
+	}
+}
+
+func (s *tracerTestSuite) TestShutdown() {
+	exporter := tracetest.NewInMemoryExporter()
+	lt, err := New(WithToken("token"), WithExporter(exporter))
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), lt.Shutdown(context.Background()))
+
+	handler := reflect.ValueOf(lt.WrapHandler(func(name string) (string, error) {
+		return "Hello " + name, nil
+	}))
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(inputPayload)})
+	assert.NotNil(s.T(), lt.provider)
+	assert.Equal(s.T(), 2, len(exporter.GetSpans()))
+
+	// the in memory exporter drops its spans once shut down
+	assert.NoError(s.T(), lt.Shutdown(context.Background()))
+	assert.Nil(s.T(), lt.provider)
+	assert.Equal(s.T(), 0, len(exporter.GetSpans()))
+}
+
+func benchmarkEnv(b *testing.B) {
+	b.Helper()
+	_ = os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "testFunction")
+	_ = os.Setenv("AWS_REGION", "us-east-1")
+	b.Cleanup(func() {
+		_ = os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+		_ = os.Unsetenv("AWS_REGION")
+	})
+}
+
+// BenchmarkInvocation measures the tracing overhead of a warm
+// invocation with the provider shared across invocations
+func BenchmarkInvocation(b *testing.B) {
+	benchmarkEnv(b)
+	lt, err := New(WithToken("token"), WithExporter(tracetest.NewNoopExporter()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	payload := json.RawMessage(`{"key":"value"}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		inv, err := newInvocation(ctx, lt, payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+		inv.Start()
+		_, span := inv.provider.Tracer("lumigo").Start(inv.traceCtx, "testFunction")
+		span.End()
+		inv.End([]byte(`"response"`), nil)
+	}
+}
+
+// BenchmarkInvocationProviderPerCall measures the same invocation
+// when a provider is built and shut down on every call
+func BenchmarkInvocationProviderPerCall(b *testing.B) {
+	benchmarkEnv(b)
+	lt, err := New(WithToken("token"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	payload := json.RawMessage(`{"key":"value"}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(tracetest.NewNoopExporter()),
+			sdktrace.WithResource(lt.newResource(ctx, attribute.String("event", string(payload)))),
+		)
+		traceCtx, parent := provider.Tracer("lumigo").Start(ctx, "LumigoParentSpan")
+		parent.SetAttributes(attribute.String("event", string(payload)))
+		_, span := provider.Tracer("lumigo").Start(traceCtx, "testFunction")
+		span.End()
+		parent.SetAttributes(attribute.String("response", `"response"`))
+		parent.End()
+		_ = provider.ForceFlush(traceCtx)
+		_ = provider.Shutdown(traceCtx)
+	}
+}
+
+func (s *tracerTestSuite) TestNewTracer() {
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	inv, err := NewTracer(ctx, Config{Token: "token"}, inputPayload)
+	assert.NoError(s.T(), err)
+	s.T().Cleanup(func() { assert.NoError(s.T(), deleteAllFiles()) })
+
+	inv.Start()
+	inv.End([]byte(`"Hello test"`), nil)
+
+	container, err := readSpansFromFile()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, len(container.endFileSpans))
+	assert.Equal(s.T(), string(inputPayload), container.endFileSpans[0].Event)
+	assert.Equal(s.T(), `"Hello test"`, *container.endFileSpans[0].LambdaResponse)
+}
+
+func (s *tracerTestSuite) TestNewTracerInvocationNumber() {
+	inputPayload, _ := json.Marshal("test")
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	s.T().Cleanup(func() { assert.NoError(s.T(), deleteAllFiles()) })
+
+	var invocationNumbers []int64
+	for i := 0; i < 2; i++ {
+		inv, err := NewTracer(ctx, Config{Token: "token"}, inputPayload)
+		assert.NoError(s.T(), err)
+		inv.Start()
+		inv.End([]byte(`"Hello test"`), nil)
+
+		container, err := readSpansFromFile()
+		assert.NoError(s.T(), err)
+		assert.Equal(s.T(), 1, len(container.endFileSpans))
+		end := container.endFileSpans[0]
+		assert.Equal(s.T(), version, end.SpanInfo.TracerVersion.Version)
+		invocationNumbers = append(invocationNumbers, end.InvocationNumber)
+		assert.NoError(s.T(), deleteAllFiles())
+	}
+
+	assert.NotZero(s.T(), invocationNumbers[0])
+	assert.Equal(s.T(), invocationNumbers[0]+1, invocationNumbers[1])
+}
+
+func (s *tracerTestSuite) TestNewTracerDisabled() {
+	_ = os.Setenv("LUMIGO_ENABLED", "false")
+	defer os.Unsetenv("LUMIGO_ENABLED")
+	inv, err := NewTracer(context.Background(), Config{Token: "token"}, json.RawMessage(`{}`))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), inv)
+	inv.Start()
+	inv.End(nil, nil)
+}
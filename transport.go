@@ -6,7 +6,10 @@ import (
 	"io"
 	"net/http"
 
-	"go.opentelemetry.io/otel"
+	"github.com/google/uuid"
+	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/transform"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/propagation"
@@ -14,86 +17,113 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-var getTracerProvider = otel.GetTracerProvider // For mocking in unittests
-
 type Transport struct {
-	rt         http.RoundTripper
-	propagator propagation.TextMapPropagator
+	rt http.RoundTripper
 }
 
+// NewTransport wraps the given transport, the requests are traced
+// only within invocations traced by the wrapped handler
 func NewTransport(transport http.RoundTripper) *Transport {
 	return &Transport{
-		rt:         transport,
-		propagator: otel.GetTextMapPropagator(),
+		rt: transport,
 	}
 }
 
 func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	logger.Info("Starting RoundTrip")
-	provider := getTracerProvider()
+	lt, ok := tracerFromContext(req.Context())
+	if !ok {
+		return t.rt.RoundTrip(req)
+	}
+	lt.logger.Info("Starting RoundTrip")
+	provider := trace.SpanFromContext(req.Context()).TracerProvider()
 	traceCtx, span := provider.Tracer("lumigo").Start(req.Context(), "HttpSpan")
 	defer span.End()
+	if parentID, ok := lumigoctx.SpanIDFromContext(req.Context()); ok {
+		span.SetAttributes(attribute.String(telemetry.ParentIDKey, parentID))
+	}
+	spanID, ok := invokeSpanID(req.Context())
+	if !ok {
+		spanID = uuid.New().String()
+	}
+	span.SetAttributes(attribute.String(telemetry.SpanIDKey, spanID))
 
-	req = req.WithContext(traceCtx)
+	req = req.WithContext(lumigoctx.WithSpanID(traceCtx, spanID))
 	span.SetAttributes(semconv.HTTPClientAttributesFromHTTPRequest(req)...)
 	span.SetAttributes(semconv.HTTPTargetKey.String(req.URL.Path))
 	span.SetAttributes(semconv.HTTPHostKey.String(req.URL.Host))
-	t.propagator.Inject(traceCtx, propagation.HeaderCarrier(req.Header))
-	req, span = addRequestDataToSpanAndWrap(req, span)
+	lt.propagator.Inject(traceCtx, propagation.HeaderCarrier(req.Header))
+	invokeAttrs, isLambdaInvoke := lambdaInvokeAttributes(req)
+	if isLambdaInvoke {
+		span.SetAttributes(invokeAttrs...)
+	}
+	req, span = lt.addRequestDataToSpanAndWrap(req, span)
 	resp, err = t.rt.RoundTrip(req)
 	if resp == nil {
 		return nil, err
 	}
 	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+	if isLambdaInvoke {
+		span.SetAttributes(lambdaInvokeResponseAttributes(resp)...)
+	}
 	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.StatusCode))
-	resp = addResponseDataToSpanAndWrap(resp, span)
-	logger.Info("Finished RoundTrip")
+	resp = lt.addResponseDataToSpanAndWrap(resp, span)
+	lt.logger.Info("Finished RoundTrip")
 	return resp, err
 }
 
-func addRequestDataToSpanAndWrap(req *http.Request, span trace.Span) (*http.Request, trace.Span) {
-	req.Body, span = addBodyToSpan(req.Body, span, "http.request_body")
-	addHeaderToSpan(req.Header, span, "http.request_headers")
+func (lt *Tracer) addRequestDataToSpanAndWrap(req *http.Request, span trace.Span) (*http.Request, trace.Span) {
+	var body string
+	req.Body, span, body = lt.addBodyToSpan(req.Body, span, "http.request_body")
+	// the resource and the IDs of the written items
+	// are read before the body is masked
+	span.SetAttributes(transform.AwsRequestAttributes(req.URL.Host, req.Header.Get("X-Amz-Target"), body)...)
+	lt.addHeaderToSpan(req.Header, span, "http.request_headers")
 	return req, span
 }
 
-func addResponseDataToSpanAndWrap(resp *http.Response, span trace.Span) *http.Response {
-	resp.Body, span = addBodyToSpan(resp.Body, span, "http.response_body")
-	addHeaderToSpan(resp.Header, span, "http.response_headers")
+func (lt *Tracer) addResponseDataToSpanAndWrap(resp *http.Response, span trace.Span) *http.Response {
+	resp.Body, span, _ = lt.addBodyToSpan(resp.Body, span, "http.response_body")
+	lt.addHeaderToSpan(resp.Header, span, "http.response_headers")
 	return resp
 }
 
-func addBodyToSpan(body io.ReadCloser, span trace.Span, attributeKey string) (io.ReadCloser, trace.Span) {
+// addBodyToSpan sets the masked body as the attribute of the span,
+// it returns the body before it is masked
+func (lt *Tracer) addBodyToSpan(body io.ReadCloser, span trace.Span, attributeKey string) (io.ReadCloser, trace.Span, string) {
+	var bodyStr string
 	if body != nil {
-		logger.Info("adding body to span")
-		bodyStr, bodyReadCloser, bodyErr := getFirstNCharsFromReadCloser(body, cfg.MaxEntrySize)
+		lt.logger.Info("adding body to span")
+		var bodyReadCloser io.ReadCloser
+		var bodyErr error
+		bodyStr, bodyReadCloser, bodyErr = getFirstNCharsFromReadCloser(body, lt.cfg.MaxEntrySize)
 		if bodyErr != nil {
+			lt.logger.WithError(bodyErr).Errorf("failed to read from readCloser %+v", body)
 			span.RecordError(bodyErr)
 			span.SetStatus(codes.Error, bodyErr.Error())
 			span.SetAttributes(attribute.String(attributeKey, ""))
 		} else {
-			span.SetAttributes(attribute.String(attributeKey, bodyStr))
+			span.SetAttributes(attribute.String(attributeKey, lt.masker.MaskJSON(bodyStr)))
 			body = bodyReadCloser
 		}
 	}
-	return body, span
+	return body, span, bodyStr
 }
 
-func addHeaderToSpan(srcHeaders http.Header, span trace.Span, attributeKey string) {
+func (lt *Tracer) addHeaderToSpan(srcHeaders http.Header, span trace.Span, attributeKey string) {
 	headers := make(map[string]string)
 	for k, values := range srcHeaders {
 		for _, value := range values {
 			headers[k] = value
 		}
 	}
-	headersJson, jsonErr := json.Marshal(headers)
+	headersJson, jsonErr := json.Marshal(lt.masker.MaskMap(headers))
 	if jsonErr != nil {
-		logger.WithError(jsonErr).Error("failed to fetch request headers")
+		lt.logger.WithError(jsonErr).Error("failed to fetch request headers")
 		span.RecordError(jsonErr)
 		span.SetStatus(codes.Error, jsonErr.Error())
 	}
-	if len(headersJson) > cfg.MaxEntrySize {
-		span.SetAttributes(attribute.String(attributeKey, string(headersJson[:cfg.MaxEntrySize])))
+	if len(headersJson) > lt.cfg.MaxEntrySize {
+		span.SetAttributes(attribute.String(attributeKey, string(headersJson[:lt.cfg.MaxEntrySize])))
 	} else {
 		span.SetAttributes(attribute.String(attributeKey, string(headersJson)))
 	}
@@ -103,7 +133,6 @@ func getFirstNCharsFromReadCloser(rc io.ReadCloser, n int) (string, io.ReadClose
 	buf := make([]byte, n)
 	readBytes, err := rc.Read(buf)
 	if err != nil && err != io.EOF {
-		logger.WithError(err).Errorf("failed to read from readCloser %+v", rc)
 		return "", rc, err
 	}
 	if err == io.EOF || readBytes < n {
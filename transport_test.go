@@ -10,11 +10,14 @@ import (
 	"regexp"
 	"testing"
 
+	"github.com/lumigo-io/lumigo-go-tracer/internal/telemetry"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/transform"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -73,13 +76,26 @@ func (rc readCloser) Read(p []byte) (n int, err error) {
 func (rc readCloser) Close() error {
 	return rc.closeErr
 }
+
+// tracedContext returns the context of an invocation traced by lt,
+// the spans of the invocation are created by the given provider
+func tracedContext(lt *Tracer, p trace.TracerProvider) context.Context {
+	ctx := trace.ContextWithSpan(context.Background(), &mySpan{p: p})
+	return contextWithTracer(ctx, lt)
+}
+
 func cleanDates(str string) string {
 	m1 := regexp.MustCompile(`"Date":"\w\w\w, ((\d\d)|(\d)) \w\w\w \d\d\d\d \d\d:\d\d:\d\d \w\w\w"`)
 	return m1.ReplaceAllString(str, `"Date":"Fri, 07 Dec 1979 19:00:18 GMT"`)
 }
 
+func cleanSpanIDs(str string) string {
+	m1 := regexp.MustCompile(`lumigo.span_id:[0-9a-f-]{36};`)
+	return m1.ReplaceAllString(str, `lumigo.span_id:<id>;`)
+}
+
 func TestTransport(t *testing.T) {
-	err := loadConfig(Config{Token: "test"})
+	lt, err := New(WithToken("test"), WithLogger(newLogger(false)))
 	assert.NoError(t, err)
 
 	content := []byte("Hello, world!")
@@ -114,13 +130,13 @@ func TestTransport(t *testing.T) {
 	}
 
 	for _, tc := range testcases {
-		defer func() { getTracerProvider = otel.GetTracerProvider }()
 		t.Run(tc.testname, func(t *testing.T) {
 			spanMock := &mySpan{}
 			lTrans := NewTransport(http.DefaultTransport)
-			getTracerProvider = func() trace.TracerProvider { return &provider{s: spanMock} }
 			c := http.Client{Transport: lTrans}
-			res, err := c.Post(ts.URL, "application/json", bytes.NewReader([]byte("post body")))
+			req, _ := http.NewRequestWithContext(tracedContext(lt, &provider{s: spanMock}), http.MethodPost, ts.URL, bytes.NewReader([]byte("post body")))
+			req.Header.Set("Content-Type", "application/json")
+			res, err := c.Do(req)
 			if err != nil {
 				assert.FailNow(t, err.Error())
 			}
@@ -129,7 +145,8 @@ func TestTransport(t *testing.T) {
 			res.Body.Close()
 			assert.Equal(t, tc.expected, body)
 			assert.Equal(t, true, spanMock.endCalled)
-			assert.Equal(t, fmt.Sprintf(`http.method:POST;
+			assert.Equal(t, fmt.Sprintf(`lumigo.span_id:<id>;
+http.method:POST;
 http.url:%s;
 http.request_content_length:;
 http.scheme:http;
@@ -142,7 +159,7 @@ http.request_headers:{"Content-Type":"application/json"};
 http.status_code:;
 http.response_body:Hello, world!;
 http.response_headers:{"Content-Length":"13","Content-Type":"text/plain; charset=utf-8","Date":"Fri, 07 Dec 1979 19:00:18 GMT"};
-`, ts.URL, ts.URL[7:], ts.URL[7:]), cleanDates(spanMock.attrs))
+`, ts.URL, ts.URL[7:], ts.URL[7:]), cleanSpanIDs(cleanDates(spanMock.attrs)))
 
 		})
 	}
@@ -166,24 +183,23 @@ func TestGetFirstNCharsFromReaderWithErr(t *testing.T) {
 }
 
 func TestTransportBodyReadError(t *testing.T) {
-	err := loadConfig(Config{Token: "test"})
+	lt, err := New(WithToken("test"), WithLogger(newLogger(false)))
 	assert.NoError(t, err)
 	spanMock := &mySpan{}
 	lTrans := NewTransport(http.DefaultTransport)
-	defer func() { getTracerProvider = otel.GetTracerProvider }()
-	getTracerProvider = func() trace.TracerProvider { return &provider{s: spanMock} }
 	c := http.Client{Transport: lTrans}
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if _, err := w.Write([]byte("Hello, world!")); err != nil {
 			t.Fatal(err)
 		}
 	}))
-	r, _ := http.NewRequest("POST", ts.URL, bytes.NewReader([]byte("post body")))
+	r, _ := http.NewRequestWithContext(tracedContext(lt, &provider{s: spanMock}), http.MethodPost, ts.URL, readCloser{readErr: errors.New("test")})
 	r.Header.Set("Content-Type", "application/json")
-	_, err = c.Post(ts.URL, "application/json", readCloser{readErr: errors.New("test")})
+	_, err = c.Do(r)
 	assert.Error(t, err)
 	assert.Equal(t, true, spanMock.endCalled)
-	assert.Equal(t, fmt.Sprintf(`http.method:POST;
+	assert.Equal(t, fmt.Sprintf(`lumigo.span_id:<id>;
+http.method:POST;
 http.url:%s;
 http.scheme:http;
 http.host:%s;
@@ -192,5 +208,107 @@ http.target:;
 http.host:%s;
 http.request_body:;
 http.request_headers:{"Content-Type":"application/json"};
-`, ts.URL, ts.URL[7:], ts.URL[7:]), cleanDates(spanMock.attrs))
+`, ts.URL, ts.URL[7:], ts.URL[7:]), cleanSpanIDs(cleanDates(spanMock.attrs)))
+}
+
+func TestTransportMasksSecrets(t *testing.T) {
+	lt, err := New(WithToken("test"), WithLogger(newLogger(false)))
+	assert.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"access_token":"xyz"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer ts.Close()
+
+	spanMock := &mySpan{}
+	c := http.Client{Transport: NewTransport(http.DefaultTransport)}
+	req, _ := http.NewRequestWithContext(tracedContext(lt, &provider{s: spanMock}), http.MethodPost, ts.URL, bytes.NewReader([]byte(`{"user":{"password":"1234"}}`)))
+	req.Header.Set("Authorization", "Bearer abc")
+	res, err := c.Do(req)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, `{"access_token":"xyz"}`, string(body))
+
+	assert.Contains(t, spanMock.attrs, `http.request_body:{"user":{"password":"****"}};`)
+	assert.Contains(t, spanMock.attrs, `http.request_headers:{"Authorization":"****"};`)
+	assert.Contains(t, spanMock.attrs, `http.response_body:{"access_token":"****"};`)
+	assert.NotContains(t, spanMock.attrs, "Bearer abc")
+}
+
+func TestTransportOutsideInvocation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("traceparent"))
+		if _, err := w.Write([]byte("Hello, world!")); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer ts.Close()
+
+	c := http.Client{Transport: NewTransport(http.DefaultTransport)}
+	res, err := c.Post(ts.URL, "application/json", bytes.NewReader([]byte("post body")))
+	assert.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, []byte("Hello, world!"), body)
+}
+
+func TestTransportDynamoDBMessageIDsBeforeMasking(t *testing.T) {
+	lt, err := New(WithToken("test"), WithLogger(newLogger(false)))
+	assert.NoError(t, err)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	dynamoDB := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewBufferString("{}"))}, nil
+	})
+	c := http.Client{Transport: NewTransport(dynamoDB)}
+	req, _ := http.NewRequestWithContext(tracedContext(lt, tp), http.MethodPost, "https://dynamodb.us-east-1.amazonaws.com/",
+		bytes.NewBufferString(`{"TableName":"users","Key":{"id":{"S":"1"}}}`))
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810.GetItem")
+	res, err := c.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans))
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[0].Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.JSONEq(t, `{"TableName":"users","Key":"****"}`, attrs["http.request_body"].AsString())
+	assert.Equal(t, []string{"63fdc94765b4cce96704e7f2a10ccf31"}, attrs[telemetry.AwsMessageIDsKey].AsStringSlice())
+}
+
+func TestTransportAwsResourceBeforeMasking(t *testing.T) {
+	lt, err := New(WithToken("test"), WithLogger(newLogger(false)))
+	assert.NoError(t, err)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	secretsManager := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewBufferString(`{"SecretString":"1234"}`))}, nil
+	})
+	c := http.Client{Transport: NewTransport(secretsManager)}
+	req, _ := http.NewRequestWithContext(tracedContext(lt, tp), http.MethodPost, "https://secretsmanager.us-east-1.amazonaws.com/",
+		bytes.NewBufferString(`{"SecretId":"db-password"}`))
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	res, err := c.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+
+	spans := exporter.GetSpans().Snapshots()
+	assert.Equal(t, 1, len(spans))
+	lumigoSpan := transform.NewMapper(context.Background(), spans[0], lt.logger, lt.cfg.MaxEntrySize, lt.masker).Transform(0)
+	assert.JSONEq(t, `{"SecretId":"****"}`, lumigoSpan.SpanInfo.HttpInfo.Request.Body)
+	assert.Equal(t, telemetry.AwsServiceInfo{
+		AwsServiceName: "secretsmanager",
+		AwsOperation:   "GetSecretValue",
+		ResourceName:   "db-password",
+	}, lumigoSpan.SpanInfo.AwsServiceInfo)
 }
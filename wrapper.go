@@ -8,34 +8,45 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	lumigoctx "github.com/lumigo-io/lumigo-go-tracer/internal/context"
+	"github.com/lumigo-io/lumigo-go-tracer/internal/masking"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	lambdadetector "go.opentelemetry.io/contrib/detectors/aws/lambda"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-lambda-go/otellambda"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
 
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 )
 
 const SPANS_DIR = "/tmp/lumigo-spans"
 
-var logger *log.Logger
-
 const (
 	version = "0.1.0"
 )
 
-func init() {
-	logger = log.New()
-	logger.Out = os.Stdout
-	logger.SetFormatter(&LogFormatter{})
+// lumigoTokenKey is the resource attribute of the Lumigo
+// token, it is sent to Lumigo only
+const lumigoTokenKey = "lumigo_token"
+
+// newLogger returns a logger in the Lumigo format which
+// discards the logs unless debug is enabled
+func newLogger(debug bool) *log.Logger {
+	l := log.New()
+	l.Out = os.Stdout
+	if !debug {
+		l.Out = io.Discard
+	}
+	l.SetFormatter(&LogFormatter{})
+	return l
 }
 
 //Log custom format
@@ -66,49 +77,197 @@ func (s *LogFormatter) Format(entry *log.Entry) ([]byte, error) {
 	return []byte(msg), nil
 }
 
+// Tracer traces the invocations of the handlers it wraps,
+// using its own configuration
+type Tracer struct {
+	cfg        Config
+	logger     log.FieldLogger
+	exporter   trace.SpanExporter
+	spansDir   string
+	propagator propagation.TextMapPropagator
+	masker     *masking.Masker
+	clock      clock
+
+	providerMu         sync.Mutex
+	provider           *trace.TracerProvider
+	invocationExporter invocationExporter
+}
+
+// invocationExporter is implemented by exporters
+// which keep state of the current invocation
+type invocationExporter interface {
+	startInvocation(ctx context.Context)
+}
+
+// New creates a Tracer configured by the LUMIGO_ environment variables
+// and the given options, the options take precedence
+func New(opts ...Option) (*Tracer, error) {
+	t := &Tracer{
+		cfg:        newConfig(Config{}),
+		spansDir:   SPANS_DIR,
+		propagator: propagation.TraceContext{},
+		clock:      realClock{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.logger == nil {
+		t.logger = newLogger(t.cfg.debug)
+	}
+	if err := t.cfg.validate(); err != nil {
+		return nil, err
+	}
+	t.masker, _ = t.cfg.newMasker()
+	return t, nil
+}
+
+// tracerFromConfig creates a Tracer with the default settings
+// for the given config
+func tracerFromConfig(conf Config) *Tracer {
+	masker, _ := conf.newMasker()
+	return &Tracer{
+		cfg:        conf,
+		logger:     newLogger(conf.debug),
+		spansDir:   SPANS_DIR,
+		propagator: propagation.TraceContext{},
+		masker:     masker,
+		clock:      realClock{},
+	}
+}
+
 // WrapHandler wraps the lambda handler
 func WrapHandler(handler interface{}, conf *Config) interface{} {
-	if err := loadConfig(*conf); err != nil {
-		recoverAndCheckFailWriteSpan()
-		logger.WithError(err).Error("failed validation error")
+	loaded, err := loadConfig(*conf)
+	if !loaded.enabled {
 		return handler
 	}
-	if !cfg.debug {
-		logger.Out = io.Discard
+	t := tracerFromConfig(loaded)
+	if err != nil {
+		t.recoverAndCheckFailWriteSpan()
+		t.logger.WithError(err).Error("failed validation error")
+		return handler
+	}
+	return t.WrapHandler(handler)
+}
+
+// WrapHandler wraps the lambda handler, the invocations
+// are traced with the configuration of the Tracer
+func (t *Tracer) WrapHandler(handler interface{}) interface{} {
+	if !t.cfg.enabled {
+		return handler
 	}
 	return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
-		defer recoverAndCheckFailWriteSpan()
-		ctx = lumigoctx.NewContext(ctx, &lumigoctx.LumigoContext{
-			TracerVersion: version,
-		})
-		tracer, err := NewTracer(ctx, cfg, payload)
+		if !t.cfg.isEnabled(ctx) {
+			response, err := lambda.NewHandler(handler).Invoke(ctx, payload)
+			return json.RawMessage(response), err
+		}
+		defer t.recoverAndCheckFailWriteSpan()
+		ctx = contextWithTracer(ctx, t)
+		inv, err := newInvocation(ctx, t, payload)
 		// catch all errors and exceptions
-		if tracer == nil || err != nil {
+		if inv == nil || err != nil {
 			response, err := lambda.NewHandler(handler).Invoke(ctx, payload)
 			return json.RawMessage(response), err
 		}
-		tracer.Start()
+		inv.Start()
+		defer func() {
+			// report the panic of the handler and
+			// let the lambda runtime see it
+			if recovered := recover(); recovered != nil {
+				inv.EndWithPanic(recovered, takePanicStacktrace())
+				panic(recovered)
+			}
+		}()
 
-		response, lambdaErr := otellambda.WrapHandler(lambda.NewHandler(handler),
-			otellambda.WithTracerProvider(tracer.provider),
-			otellambda.WithFlusher(tracer.provider)).Invoke(tracer.traceCtx, payload)
+		functionHandler := &eventHandler{handler: lambda.NewHandler(handler), attrs: inv.functionAttributes()}
+		response, lambdaErr := otellambda.WrapHandler(functionHandler,
+			otellambda.WithTracerProvider(inv.provider),
+			otellambda.WithFlusher(inv.provider),
+			otellambda.WithPropagator(t.propagator)).Invoke(inv.traceCtx, payload)
 
-		tracer.End(response, lambdaErr)
+		inv.End(response, lambdaErr)
 
 		return json.RawMessage(response), lambdaErr
 	}
 }
 
+// startInvocation returns the provider shared by the invocations, ready
+// to trace a new invocation. The provider is created on the first
+// invocation of the container and reused by the warm ones.
+func (t *Tracer) startInvocation(ctx context.Context) (*trace.TracerProvider, error) {
+	t.providerMu.Lock()
+	defer t.providerMu.Unlock()
+	if t.provider == nil {
+		exporter, err := t.createExporter(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create otel exporter")
+		}
+		// the spans are always sampled, an unsampled inbound
+		// trace context would drop all the spans otherwise
+		t.provider = trace.NewTracerProvider(
+			trace.WithSampler(trace.AlwaysSample()),
+			trace.WithBatcher(exporter),
+			trace.WithResource(t.newResource(ctx)),
+		)
+		t.invocationExporter, _ = exporter.(invocationExporter)
+	}
+	if t.invocationExporter != nil {
+		t.invocationExporter.startInvocation(ctx)
+	}
+	return t.provider, nil
+}
+
+// Shutdown flushes the spans and stops the provider shared by
+// the invocations, a later invocation creates a new one
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.providerMu.Lock()
+	defer t.providerMu.Unlock()
+	if t.provider == nil {
+		return nil
+	}
+	err := t.provider.Shutdown(ctx)
+	t.provider = nil
+	t.invocationExporter = nil
+	return err
+}
+
+// eventHandler tags the function span started by
+// otellambda with the attributes of the invocation
+type eventHandler struct {
+	handler lambda.Handler
+	attrs   []attribute.KeyValue
+}
+
+func (h *eventHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	oteltrace.SpanFromContext(ctx).SetAttributes(h.attrs...)
+	return h.handler.Invoke(ctx, payload)
+}
+
+// tracerKey is the key for the Tracer of
+// the traced invocation in Contexts
+type tracerKey struct{}
+
+// contextWithTracer returns a new Context that carries the Tracer
+func contextWithTracer(parent context.Context, t *Tracer) context.Context {
+	return context.WithValue(parent, tracerKey{}, t)
+}
+
+// tracerFromContext returns the Tracer stored in ctx, if any.
+func tracerFromContext(ctx context.Context) (*Tracer, bool) {
+	t, ok := ctx.Value(tracerKey{}).(*Tracer)
+	return t, ok
+}
+
 // newResource returns a resource describing this application.
-func newResource(ctx context.Context, extraAttrs ...attribute.KeyValue) *resource.Resource {
+func (t *Tracer) newResource(ctx context.Context, extraAttrs ...attribute.KeyValue) *resource.Resource {
 	attrs := []attribute.KeyValue{
-		attribute.String("lumigo_token", cfg.Token),
+		attribute.String(lumigoTokenKey, t.cfg.Token),
 	}
 	attrs = append(attrs, extraAttrs...)
 	detector := lambdadetector.NewResourceDetector()
 	res, err := detector.Detect(ctx)
 	if err != nil {
-		logger.WithError(err).Warn("failed to detect AWS lambda resources")
+		t.logger.WithError(err).Warn("failed to detect AWS lambda resources")
 		return resource.NewWithAttributes(semconv.SchemaURL, attrs...)
 	}
 	r, _ := resource.Merge(
@@ -118,26 +277,58 @@ func newResource(ctx context.Context, extraAttrs ...attribute.KeyValue) *resourc
 	return r
 }
 
-// createExporter returns a console exporter.
-func createExporter(printStdout bool, ctx context.Context, logger log.FieldLogger) (trace.SpanExporter, error) {
-	if printStdout {
+// createExporter returns the exporter of the Tracer, a console
+// exporter or the selected exporters, fanned out if more than one
+func (t *Tracer) createExporter(ctx context.Context) (trace.SpanExporter, error) {
+	if t.exporter != nil {
+		return t.exporter, nil
+	}
+	if t.cfg.PrintStdout {
 		return stdouttrace.New()
 	}
-	if _, err := os.Stat(SPANS_DIR); errors.Is(err, os.ErrNotExist) {
-		if err := os.Mkdir(SPANS_DIR, os.ModePerm); err != nil {
-			return nil, errors.Wrapf(err, "failed to create dir: %s", SPANS_DIR)
+	var exporters multiExporter
+	for _, name := range t.cfg.exporters {
+		exporter, err := t.createNamedExporter(ctx, name)
+		if err != nil {
+			_ = exporters.Shutdown(ctx)
+			return nil, err
 		}
-	} else if err != nil {
-		logger.WithError(err).Error()
+		exporters = append(exporters, exporter)
+	}
+	if len(exporters) == 1 {
+		return exporters[0], nil
 	}
-	return newExporter(ctx, logger)
+	return exporters, nil
 }
 
-func recoverAndCheckFailWriteSpan() {
-	defer recoverWithLogs()
-	dirEntries, err := os.ReadDir(SPANS_DIR)
+func (t *Tracer) createNamedExporter(ctx context.Context, name string) (trace.SpanExporter, error) {
+	switch name {
+	case exporterHTTP:
+		return newExporter(ctx, t, newEdgeWriter(t))
+	case exporterOTLP:
+		return newOTLPExporter(ctx, t)
+	case exporterFile:
+		if _, err := os.Stat(t.spansDir); errors.Is(err, os.ErrNotExist) {
+			if err := os.MkdirAll(t.spansDir, os.ModePerm); err != nil {
+				return nil, errors.Wrapf(err, "failed to create dir: %s", t.spansDir)
+			}
+		} else if err != nil {
+			t.logger.WithError(err).Error()
+		}
+		return newExporter(ctx, t, fileWriter{dir: t.spansDir})
+	}
+	return nil, ErrInvalidExporter
+}
+
+func (t *Tracer) recoverAndCheckFailWriteSpan() {
+	defer recoverWithLogs(t.logger)
+	if t.exporter != nil || (len(t.cfg.exporters) > 0 && !t.cfg.hasExporter(exporterFile)) {
+		// spans files are written only by the Lumigo file exporter
+		return
+	}
+	dirEntries, err := os.ReadDir(t.spansDir)
 	if err != nil {
-		logger.WithError(err).Error("failed to read spans dir")
+		t.logger.WithError(err).Error("failed to read spans dir")
 	}
 	found := false
 	for _, file := range dirEntries {
@@ -149,9 +340,9 @@ func recoverAndCheckFailWriteSpan() {
 		}
 	}
 	if !found {
-		endFile, err := os.Create(filepath.Join(SPANS_DIR, "balagan_stop"))
+		endFile, err := os.Create(filepath.Join(t.spansDir, "balagan_stop"))
 		if err != nil {
-			logger.WithError(err).Error("failed to create file _end")
+			t.logger.WithError(err).Error("failed to create file _end")
 		}
 		endFile.Close()
 	}
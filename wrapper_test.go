@@ -15,6 +15,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambda/messages"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/stretchr/testify/assert"
@@ -66,6 +67,7 @@ func (w *wrapperTestSuite) TearDownTest() {
 	_ = os.Unsetenv("AWS_LAMBDA_LOG_GROUP_NAME")
 	_ = os.Unsetenv("AWS_LAMBDA_FUNCTION_VERSION")
 	_ = os.Unsetenv("_X_AMZN_TRACE_ID")
+	_ = os.Unsetenv("LUMIGO_ENABLED")
 	assert.NoError(w.T(), deleteAllFiles())
 }
 
@@ -335,8 +337,9 @@ func (w *wrapperTestSuite) TestLambdaHandlerE2ELocal() {
 				assert.Equal(w.T(), testCase.expected.err.Error(), endFuncSpan.SpanError.Message)
 				assert.Equal(w.T(), reflect.TypeOf(testCase.expected.err).String(), endFuncSpan.SpanError.Type)
 
-				assert.Contains(t, endFuncSpan.SpanError.Stacktrace, "lumigo-go-tracer.WrapHandler.func1")
-				assert.Contains(t, endFuncSpan.SpanError.Stacktrace, "lumigo-go-tracer.(*wrapperTestSuite).TestLambdaHandlerE2ELocal.func7")
+				// the errors of the standard library don't record their
+				// stack, the one of the tracer isn't reported instead
+				assert.Empty(t, endFuncSpan.SpanError.Stacktrace)
 			} else {
 				assert.NotNil(w.T(), endFuncSpan.LambdaResponse)
 				assert.Equal(w.T(), testCase.expected.val, *endFuncSpan.LambdaResponse)
@@ -353,7 +356,7 @@ func (w *wrapperTestSuite) TestCheckFailWriteSpanHandler() {
 	assert.NoError(w.T(), err)
 	assert.Equal(w.T(), 0, len(dirEntries))
 
-	recoverAndCheckFailWriteSpan()
+	tracerFromConfig(Config{}).recoverAndCheckFailWriteSpan()
 	dirEntries, err = os.ReadDir(SPANS_DIR)
 	assert.NoError(w.T(), err)
 	assert.Equal(w.T(), 1, len(dirEntries))
@@ -406,3 +409,104 @@ func (w *wrapperTestSuite) TestCheckFailWriteSpanHandler_FailLoadConfig() {
 
 	assert.Equal(w.T(), "balagan_stop", dirEntries[0].Name())
 }
+
+func (w *wrapperTestSuite) TestWrapHandlerDisabled() {
+	_ = os.Setenv("LUMIGO_ENABLED", "false")
+	ts := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		assert.Empty(w.T(), r.Header.Get("traceparent"))
+		_, _ = wr.Write([]byte("Hello, world!"))
+	}))
+	defer ts.Close()
+
+	handlerToWrap := func(ctx context.Context, name string) (string, error) {
+		c := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+		res, err := ctxhttp.Get(ctx, c, ts.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		return fmt.Sprintf("Hello %s!", name), nil
+	}
+	lambdaHandler := WrapHandler(handlerToWrap, &Config{Token: "token"})
+	assert.Equal(w.T(), reflect.ValueOf(handlerToWrap).Pointer(), reflect.ValueOf(lambdaHandler).Pointer())
+
+	inputPayload, _ := json.Marshal("test")
+	response, err := lambda.NewHandler(lambdaHandler).Invoke(mockContext, inputPayload)
+	assert.NoError(w.T(), err)
+	assert.Equal(w.T(), `"Hello test!"`, string(response))
+
+	dirEntries, err := os.ReadDir(SPANS_DIR)
+	assert.NoError(w.T(), err)
+	assert.Equal(w.T(), 0, len(dirEntries))
+}
+
+func (w *wrapperTestSuite) TestWrapHandlerDisabledPerInvocation() {
+	enabled := false
+	handlerToWrap := func(s string) (string, error) {
+		return fmt.Sprintf("Hello %s!", s), nil
+	}
+	lambdaHandler := WrapHandler(handlerToWrap, &Config{
+		Token:     "token",
+		IsEnabled: func(ctx context.Context) bool { return enabled },
+	})
+
+	inputPayload, _ := json.Marshal("test")
+	handler := reflect.ValueOf(lambdaHandler)
+	response := handler.Call([]reflect.Value{reflect.ValueOf(mockContext), reflect.ValueOf(inputPayload)})
+	assert.Nil(w.T(), response[1].Interface())
+	assert.Equal(w.T(), json.RawMessage(`"Hello test!"`), response[0].Interface())
+
+	dirEntries, err := os.ReadDir(SPANS_DIR)
+	assert.NoError(w.T(), err)
+	assert.Equal(w.T(), 0, len(dirEntries))
+
+	enabled = true
+	_ = handler.Call([]reflect.Value{reflect.ValueOf(mockContext), reflect.ValueOf(inputPayload)})
+
+	dirEntries, err = os.ReadDir(SPANS_DIR)
+	assert.NoError(w.T(), err)
+	assert.Equal(w.T(), 2, len(dirEntries))
+}
+
+type panicReason struct {
+	Code int
+}
+
+func (w *wrapperTestSuite) TestLambdaHandlerPanics() {
+	testCases := []struct {
+		name      string
+		recovered interface{}
+		message   string
+	}{
+		{name: "string", recovered: "handler failed", message: "handler failed"},
+		{name: "error", recovered: errors.New("handler failed"), message: "handler failed"},
+		{name: "custom type", recovered: panicReason{Code: 42}, message: "{42}"},
+	}
+
+	for _, testCase := range testCases {
+		w.T().Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			lt, err := New(WithToken("token"), WithSpansDir(dir))
+			assert.NoError(t, err)
+			handler := reflect.ValueOf(lt.WrapHandler(func(ctx context.Context, name string) (string, error) {
+				panic(testCase.recovered)
+			}))
+			inputPayload, _ := json.Marshal("test")
+
+			assert.PanicsWithValue(t, testCase.recovered, func() {
+				_ = handler.Call([]reflect.Value{reflect.ValueOf(mockContext), reflect.ValueOf(inputPayload)})
+			})
+
+			container, err := readSpansFromDir(dir)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(container.startFileSpans))
+			assert.Equal(t, 1, len(container.endFileSpans))
+			spanError := container.endFileSpans[0].SpanError
+			assert.NotNil(t, spanError)
+			assert.Equal(t, "Runtime.Panic", spanError.Type)
+			assert.Equal(t, testCase.message, spanError.Message)
+			assert.True(t, strings.HasPrefix(spanError.Stacktrace, "github.com/lumigo-io/lumigo-go-tracer.(*wrapperTestSuite).TestLambdaHandlerPanics"))
+			assert.NotContains(t, spanError.Stacktrace, "takePanicStacktrace")
+		})
+	}
+}